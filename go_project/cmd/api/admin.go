@@ -0,0 +1,516 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"goproject/internal/data"
+	"goproject/internal/validator"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string
+		Email     string
+		Activated string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readString(qs, "activated", "")
+	v.Check(validator.In(input.Activated, "", "true", "false"), "activated", "must be true or false")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	access, err := app.userAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "access": access}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	access, err := app.userAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, access, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+		Roles       []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Permissions) > 0 || len(input.Roles) > 0, "permissions", "must provide at least one permission or role")
+
+	err = app.validatePermissionsAndRoles(v, input.Permissions, input.Roles)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Permissions.GrantForUser(user.ID, input.Permissions, input.Roles)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	access, err := app.userAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, access, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code := app.readParam(r, "code")
+
+	err := app.modelsFor(r).Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	access, err := app.userAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, access, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	role := app.readParam(r, "role")
+
+	err := app.modelsFor(r).Permissions.RemoveRolesForUser(user.ID, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	access, err := app.userAccess(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, access, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// Deactivating yourself would lock the last admin out, so don't allow it.
+	if user.ID == app.contextGetUser(r).ID {
+		v := validator.New()
+		v.AddError("id", "you cannot deactivate your own account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Disabling the account is separate from it being activated, so that the user
+	// can't turn it back on again by asking for a new activation token.
+	user.Disabled = true
+
+	err := app.disableUser(r, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The disableUser() helper saves a user who has just been disabled and deletes all of
+// their sessions, in one transaction so that a failure can't leave a disabled account
+// whose sessions still work. Their cached permissions are dropped too, because a
+// disabled user has none; that also stops JWT access tokens, which can't be deleted,
+// from being used to make changes before they expire.
+func (app *application) disableUser(r *http.Request, user *data.User) error {
+	tx, err := app.models.Begin()
+	if err != nil {
+		return err
+	}
+	// Rolling back after a successful commit is a no-op, so it's safe to always defer it.
+	defer tx.Rollback()
+
+	models := app.modelsFor(r).WithTx(tx)
+
+	err = models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	err = models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	app.models.Permissions.Cache.Invalidate(user.ID)
+	return nil
+}
+
+// The reactivateUserHandler() turns a disabled account back on. Whether the account is
+// activated is left as it was.
+func (app *application) reactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	user.Disabled = false
+
+	err := app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.models.Permissions.Cache.Invalidate(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.recordAuditEvent(r, "user.logged_out", user.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens for the user have been deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The linkUserResearcherHandler() links a user to a researcher profile, which lets them
+// write that researcher's expeditions and artifacts. A null researcher_id removes the
+// link.
+func (app *application) linkUserResearcherHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ResearcherID *int64 `json:"researcher_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.ResearcherID != nil {
+		_, err = app.models.Researchers.Get(*input.ResearcherID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v := validator.New()
+				v.AddError("researcher_id", "must refer to an existing researcher")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	user.ResearcherID = input.ResearcherID
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Permissions.GetAllRoles()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	requiresTwoFactor, err := app.models.Permissions.GetRequiringTwoFactor()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions, "roles": roles, "requires_2fa": requiresTwoFactor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updatePermissionHandler() lets an admin choose whether a permission requires
+// two-factor authentication. Users without it keep the grant, but it doesn't count
+// until they enable two-factor authentication.
+func (app *application) updatePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	code := app.readParam(r, "code")
+
+	var input struct {
+		RequiresTwoFactor *bool `json:"requires_2fa"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.RequiresTwoFactor != nil, "requires_2fa", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Permissions.SetRequiresTwoFactor(code, *input.RequiresTwoFactor)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permission": envelope{"code": code, "requires_2fa": *input.RequiresTwoFactor}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readUserParam() helper looks up the user named by the "id" URL parameter. If the
+// user can't be found (or something else goes wrong) it sends the appropriate response
+// itself and returns false, in which case the handler should simply return.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// userAccess gathers everything that an admin needs to see about a user's access: the
+// roles they have, the permissions granted to them directly, and the full set of
+// permissions that they end up with.
+func (app *application) userAccess(userID int64) (envelope, error) {
+	roles, err := app.models.Permissions.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	effective, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if effective == nil {
+		effective = data.Permissions{}
+	}
+
+	return envelope{"roles": roles, "direct_permissions": direct, "permissions": effective}, nil
+}
+
+// validatePermissionsAndRoles checks that every permission code and role name that an
+// admin is trying to grant actually exists, since AddForUser() and AddRolesForUser()
+// would otherwise silently ignore them.
+func (app *application) validatePermissionsAndRoles(v *validator.Validator, codes []string, roles []string) error {
+	if len(codes) > 0 {
+		known, err := app.models.Permissions.GetAll()
+		if err != nil {
+			return err
+		}
+		for _, code := range codes {
+			v.Check(known.Include(code), "permissions", "must only contain existing permission codes")
+		}
+	}
+
+	if len(roles) > 0 {
+		known, err := app.models.Permissions.GetAllRoles()
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			_, exists := known[role]
+			v.Check(exists, "roles", "must only contain existing roles")
+		}
+	}
+
+	return nil
+}
+
+// recordAuditEvent adds an entry to the audit trail for an action taken on a user by the
+// current (admin) user. Changes made through the models returned by app.modelsFor() are
+// recorded automatically, so this is only needed for actions which don't change a
+// record, like logging a user out. A failure to record the event is logged rather than
+// sent to the client, because the action itself has already happened by this point.
+func (app *application) recordAuditEvent(r *http.Request, action string, userID int64, details map[string]interface{}) {
+	event := &data.AuditEvent{
+		ActorID:    app.contextGetUser(r).ID,
+		RequestID:  app.contextGetRequestID(r),
+		IP:         app.clientIP(r),
+		Action:     action,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    details,
+	}
+
+	err := app.models.AuditEvents.Insert(event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
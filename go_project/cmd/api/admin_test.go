@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// userAccessResponse is the body of the responses which describe a user's access.
+type userAccessResponse struct {
+	Roles             []string `json:"roles"`
+	DirectPermissions []string `json:"direct_permissions"`
+	Permissions       []string `json:"permissions"`
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGrantAndRevokeUserPermissions(t *testing.T) {
+	app := newTestDBApplication(t)
+	admin := app.newTestUser(t)
+	user := app.newTestUser(t)
+	id := strconv.FormatInt(user.ID, 10)
+
+	send := func(method, target, body string, params httprouter.Params, handler http.HandlerFunc) (int, userAccessResponse) {
+		t.Helper()
+		rr := app.serveTest(t, handler, testRequest{method: method, target: target, body: body, params: params, user: admin})
+		var access userAccessResponse
+		if rr.Code == http.StatusOK {
+			decodeResponse(t, rr, &access)
+		}
+		return rr.Code, access
+	}
+	grant := func(body string) (int, userAccessResponse) {
+		t.Helper()
+		return send(http.MethodPost, "/v1/admin/users/"+id+"/permissions", body, httprouter.Params{{Key: "id", Value: id}}, app.grantUserPermissionsHandler)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"nothing to grant", `{}`, http.StatusUnprocessableEntity},
+		{"unknown permission", `{"permissions": ["spells:cast"]}`, http.StatusUnprocessableEntity},
+		{"unknown role", `{"roles": ["wizard"]}`, http.StatusUnprocessableEntity},
+		{"permission and role", `{"permissions": ["expeditions:write"], "roles": ["viewer"]}`, http.StatusOK},
+		{"granted again", `{"permissions": ["expeditions:write"], "roles": ["viewer"]}`, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, access := grant(tc.body)
+			if status != tc.want {
+				t.Fatalf("got status %d; want %d", status, tc.want)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if !contains(access.Roles, "viewer") || !contains(access.DirectPermissions, "expeditions:write") {
+				t.Errorf("got %+v; want the viewer role and expeditions:write", access)
+			}
+			if !contains(access.Permissions, "artifacts:read") {
+				t.Errorf("got permissions %v; want the viewer role's artifacts:read", access.Permissions)
+			}
+		})
+	}
+
+	status, access := send(http.MethodDelete, "/v1/admin/users/"+id+"/permissions/expeditions:write", "",
+		httprouter.Params{{Key: "id", Value: id}, {Key: "code", Value: "expeditions:write"}}, app.revokeUserPermissionHandler)
+	if status != http.StatusOK || contains(access.DirectPermissions, "expeditions:write") || contains(access.Permissions, "expeditions:write") {
+		t.Errorf("revoking expeditions:write: got status %d and %+v", status, access)
+	}
+
+	status, access = send(http.MethodDelete, "/v1/admin/users/"+id+"/roles/viewer", "",
+		httprouter.Params{{Key: "id", Value: id}, {Key: "role", Value: "viewer"}}, app.revokeUserRoleHandler)
+	if status != http.StatusOK || len(access.Roles) != 0 || len(access.Permissions) != 0 {
+		t.Errorf("revoking the viewer role: got status %d and %+v", status, access)
+	}
+}
+
+func TestDeactivateUser(t *testing.T) {
+	app := newTestDBApplication(t)
+	app.models.Permissions.Cache = data.NewPermissionCache(time.Minute)
+	admin := app.newTestUser(t)
+	user := app.newTestUser(t)
+	id := strconv.FormatInt(user.ID, 10)
+
+	if err := app.models.Permissions.AddForUser(user.ID, "artifacts:write"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.models.Tokens.NewSession(user.ID, time.Hour, data.ScopeAuthentication, "192.0.2.1", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the user's permissions into the cache, as a request of theirs would.
+	if permissions, err := app.models.Permissions.GetAllForUser(user.ID); err != nil || !permissions.Include("artifacts:write") {
+		t.Fatalf("got permissions %v, %v; want artifacts:write", permissions, err)
+	}
+
+	serve := func(handler http.HandlerFunc, target string, who *data.User) int {
+		t.Helper()
+		rr := app.serveTest(t, handler, testRequest{
+			method: http.MethodPut,
+			target: target,
+			params: httprouter.Params{{Key: "id", Value: strconv.FormatInt(who.ID, 10)}},
+			user:   admin,
+		})
+		return rr.Code
+	}
+
+	if status := serve(app.deactivateUserHandler, "/v1/admin/users/self/deactivate", admin); status != http.StatusUnprocessableEntity {
+		t.Errorf("deactivating yourself: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	if status := serve(app.deactivateUserHandler, "/v1/admin/users/"+id+"/deactivate", user); status != http.StatusOK {
+		t.Fatalf("got status %d; want %d", status, http.StatusOK)
+	}
+
+	sessions, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("got %d sessions after deactivating; want none", len(sessions))
+	}
+
+	// A JWT access token can't be deleted, so a disabled user mustn't have any
+	// permissions left to use it with, cached or not.
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 0 {
+		t.Errorf("got permissions %v after deactivating; want none", permissions)
+	}
+
+	if status := serve(app.reactivateUserHandler, "/v1/admin/users/"+id+"/reactivate", user); status != http.StatusOK {
+		t.Fatalf("reactivating: got status %d; want %d", status, http.StatusOK)
+	}
+	permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permissions.Include("artifacts:write") {
+		t.Errorf("got permissions %v after reactivating; want artifacts:write back", permissions)
+	}
+}
+
+func TestLogoutUser(t *testing.T) {
+	app := newTestDBApplication(t)
+	admin := app.newTestUser(t)
+	user := app.newTestUser(t)
+	id := strconv.FormatInt(user.ID, 10)
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		if _, err := app.models.Tokens.NewSession(user.ID, time.Hour, scope, "192.0.2.1", "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := app.serveTest(t, app.logoutUserHandler, testRequest{
+		method: http.MethodDelete,
+		target: "/v1/admin/users/" + id + "/tokens",
+		params: httprouter.Params{{Key: "id", Value: id}},
+		user:   admin,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		sessions, err := app.models.Tokens.GetAllForUser(scope, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 0 {
+			t.Errorf("got %d %s tokens after logging out; want none", len(sessions), scope)
+		}
+	}
+
+	events, _, err := app.models.AuditEvents.GetAll(
+		data.AuditEventFilter{ActorID: admin.ID, Action: "user.logged_out", TargetID: user.ID},
+		data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("got %d user.logged_out events; want 1", len(events))
+	}
+}
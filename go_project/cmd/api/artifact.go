@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"goproject/internal/data"
 	"goproject/internal/validator"
@@ -48,13 +53,24 @@ func (app *application) createArtifactHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Unless they can write any researcher's artifacts, users can only create artifacts
+	// for the researcher profile that they're linked to.
+	if !app.requireOwnership(w, r, "artifacts", artifact.Researcher_id) {
+		return
+	}
+
 	// Call the Insert() method on our researchers model, passing in a pointer to the
 	// validated song struct. This will create a record in the database and update the
 	// song struct with the system-generated information.
-	err = app.models.Artifacts.Insert(artifact)
+	err = app.modelsFor(r).Artifacts.Insert(artifact)
 
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.unknownResearcherResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -97,6 +113,12 @@ func (app *application) showArtifactHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// With an as_of parameter, send the artifact as it was at that time instead.
+	if r.URL.Query().Has("as_of") {
+		app.showArtifactAsOf(w, r, id)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
@@ -159,6 +181,10 @@ func (app *application) updateArtifactHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Remember who the artifact belonged to before the update, because moving it to
+	// another researcher needs write access to both of them.
+	owner := artifact.Researcher_id
+
 	// Copy the values from the request body to the appropriate fields of the movie
 	// record.
 
@@ -179,10 +205,21 @@ func (app *application) updateArtifactHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !app.requireOwnership(w, r, "artifacts", owner, artifact.Researcher_id) {
+		return
+	}
+
 	// Pass the updated researcher record to our new Update() method.
-	err = app.models.Artifacts.Update(artifact)
+	err = app.modelsFor(r).Artifacts.Update(artifact)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.unknownResearcherResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -203,9 +240,25 @@ func (app *application) deleteArtifactHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Fetch the artifact first, so that we know which researcher it belongs to.
+	artifact, err := app.models.Artifacts.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireOwnership(w, r, "artifacts", artifact.Researcher_id) {
+		return
+	}
+
 	// Delete the researcher from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Artifacts.Delete(id)
+	err = app.modelsFor(r).Artifacts.Delete(id)
 
 	if err != nil {
 		switch {
@@ -261,12 +314,24 @@ func (app *application) listArtifactsHandler(w http.ResponseWriter, r *http.Requ
 	// input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 	input.Filters.SortSafelist = []string{"artifact_id", "title", "age", "location", "researcher_id", "-artifact_id", "-title", "-age", "-location", "-researcher_id"}
 
+	// Work out whether the client wants the usual paginated JSON envelope or a
+	// streamed CSV/NDJSON export of every matching row.
+	format := app.readFormat(r, v)
+
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+
+	// Exports ignore the page and page_size parameters, but go through exactly the same
+	// filter and sort validation as a normal page of results.
+	if format != formatJSON {
+		app.exportArtifacts(w, r, format, input.Title, input.Age, input.Filters)
+		return
+	}
+
 	// Call the GetAll() method to retrieve the researchers, passing in the various filter
 	// parameters.
 	// Accept the metadata struct as a return value.
@@ -356,3 +421,204 @@ func (app *application) getArtifactsByResearcherHandler(w http.ResponseWriter, r
 	}
 
 }
+
+// artifactImportRow holds a single row of a bulk import, whether it came from a JSON
+// array or from a CSV file.
+type artifactImportRow struct {
+	Title         string `json:"title"`
+	Age           int    `json:"age"`
+	Location      string `json:"location"`
+	Researcher_id int    `json:"researcher_id"`
+}
+
+// maxImportRows is the largest number of artifacts accepted in a single import.
+const maxImportRows = 10_000
+
+func (app *application) importArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	// A dry run validates every row and reports the result, but never touches the
+	// database.
+	dryRun, err := strconv.ParseBool(app.readString(r.URL.Query(), "dry_run", "false"))
+	if err != nil {
+		v.AddError("dry_run", "must be a boolean value")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Read the rows from the request body. Field teams usually send us spreadsheets, so
+	// we accept CSV as well as a plain JSON array.
+	var rows []artifactImportRow
+	rowErrors := make(map[int]map[string]string)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		rows, err = app.readArtifactCSV(w, r, rowErrors)
+	} else {
+		err = app.readJSON(w, r, &rows)
+	}
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case len(rows) == 0:
+		app.badRequestResponse(w, r, errors.New("body must contain at least one row"))
+		return
+	case len(rows) > maxImportRows:
+		app.badRequestResponse(w, r, fmt.Errorf("body must not contain more than %d rows", maxImportRows))
+		return
+	}
+
+	// Users without artifacts:write_any can only import artifacts for their own
+	// researcher profile. Rows for anyone else are reported like any other invalid row.
+	user := app.contextGetUser(r)
+	r, permissions, err := app.loadPermissions(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	access := writeAccess{user: user, permissions: permissions}
+
+	// Run exactly the same validation as createArtifactHandler on every row, and keep
+	// the errors for each row separately so that the client can fix them all at once.
+	artifacts := make([]*data.Artifact, len(rows))
+	for i, row := range rows {
+		artifacts[i] = &data.Artifact{
+			Title:         row.Title,
+			Age:           row.Age,
+			Location:      row.Location,
+			Researcher_id: row.Researcher_id,
+		}
+
+		rv := validator.New()
+		// Carry over any errors that were found while parsing the CSV row.
+		for key, message := range rowErrors[i+1] {
+			rv.AddError(key, message)
+		}
+
+		data.ValidateArtifact(rv, artifacts[i])
+		if !access.allows("artifacts", row.Researcher_id) {
+			rv.AddError("researcher_id", "must be your own researcher profile")
+		}
+
+		if !rv.Valid() {
+			rowErrors[i+1] = rv.Errors
+		}
+	}
+
+	if len(rowErrors) > 0 {
+		app.failedRowValidationResponse(w, r, rowErrors)
+		return
+	}
+
+	if dryRun {
+		err = app.writeJSON(w, http.StatusOK, envelope{"dry_run": true, "rows": len(artifacts)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A researcher can be deleted between validating the rows and copying them in, in
+	// which case the database rejects the whole import.
+	err = app.modelsFor(r).Artifacts.InsertMany(artifacts)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.unknownResearcherResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"imported": len(artifacts)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readArtifactCSV() helper reads an artifact import from a CSV request body. The
+// first line must be a header naming the columns, in any order. Values which can't be
+// parsed (such as a non-numeric age) are recorded in rowErrors against their row
+// number, rather than failing the whole request, so that they're reported together
+// with the normal validation errors.
+func (app *application) readArtifactCSV(w http.ResponseWriter, r *http.Request, rowErrors map[int]map[string]string) ([]artifactImportRow, error) {
+	// Use the same 1MB limit on the request body as readJSON().
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	reader := csv.NewReader(r.Body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, fmt.Errorf("body contains badly-formed CSV: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.In(name, "title", "age", "location", "researcher_id") {
+			return nil, fmt.Errorf("body contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	var rows []artifactImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("body contains badly-formed CSV: %w", err)
+		}
+
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("body must not contain more than %d rows", maxImportRows)
+		}
+
+		var row artifactImportRow
+		rowNumber := len(rows) + 1
+
+		if i, ok := columns["title"]; ok {
+			row.Title = record[i]
+		}
+		if i, ok := columns["location"]; ok {
+			row.Location = record[i]
+		}
+		if i, ok := columns["age"]; ok && record[i] != "" {
+			row.Age, err = strconv.Atoi(record[i])
+			if err != nil {
+				addRowError(rowErrors, rowNumber, "age", "must be an integer value")
+			}
+		}
+		if i, ok := columns["researcher_id"]; ok && record[i] != "" {
+			row.Researcher_id, err = strconv.Atoi(record[i])
+			if err != nil {
+				addRowError(rowErrors, rowNumber, "researcher_id", "must be an integer value")
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// addRowError records an error message for a specific row, creating the map for that
+// row if this is its first error.
+func addRowError(rowErrors map[int]map[string]string, row int, key, message string) {
+	if rowErrors[row] == nil {
+		rowErrors[row] = make(map[string]string)
+	}
+	if _, exists := rowErrors[row][key]; !exists {
+		rowErrors[row][key] = message
+	}
+}
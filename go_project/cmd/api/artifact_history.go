@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"goproject/internal/data"
+	"goproject/internal/validator"
+)
+
+// The artifactHistoryHandler() returns a page of the saved versions of an artifact,
+// newest first by default.
+func (app *application) artifactHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafelist = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	versions, metadata, err := app.models.Artifacts.GetHistory(id, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"versions": versions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showArtifactAsOf() helper is used by showArtifactHandler() when the request has
+// an as_of parameter. It sends the artifact as it was at that time, or a 404 Not Found
+// response if the artifact didn't exist yet or had been deleted by then.
+func (app *application) showArtifactAsOf(w http.ResponseWriter, r *http.Request, id int64) {
+	v := validator.New()
+
+	// readTime() treats an empty value as missing, but here an empty as_of= is a
+	// mistake rather than a request for the current version.
+	asOf := app.readTime(r.URL.Query(), "as_of", v)
+	v.Check(r.URL.Query().Get("as_of") != "", "as_of", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	version, err := app.models.Artifacts.GetAsOf(id, asOf)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if version.Deleted {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artifact": version.Artifact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The revertArtifactHandler() restores the content of an earlier version of an
+// artifact. The old version isn't brought back as it was: its content is saved as a
+// new version, in exactly the same way as an update, so the history is never rewritten
+// and the usual validation and ownership checks apply. This also works for an artifact
+// which has been deleted, in which case it's restored under its old ID.
+func (app *application) revertArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	toVersion := app.readInt(r.URL.Query(), "to_version", 0, v)
+	v.Check(toVersion > 0, "to_version", "must be provided and greater than 0")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The latest version is the artifact as it is now, or its tombstone if it has been
+	// deleted.
+	latest, err := app.models.Artifacts.GetLatestVersion(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	artifact := &latest.Artifact
+
+	version, err := app.models.Artifacts.GetVersion(id, toVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("to_version", "must refer to an existing version of the artifact")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// As in updateArtifactHandler(), moving the artifact back to another researcher
+	// needs write access to both of them.
+	owner := artifact.Researcher_id
+
+	artifact.Title = version.Artifact.Title
+	artifact.Age = version.Artifact.Age
+	artifact.Location = version.Artifact.Location
+	artifact.Researcher_id = version.Artifact.Researcher_id
+
+	// The rules may have changed since the old version was saved.
+	if data.ValidateArtifact(v, artifact); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.requireOwnership(w, r, "artifacts", owner, artifact.Researcher_id) {
+		return
+	}
+
+	if latest.Deleted {
+		err = app.modelsFor(r).Artifacts.Restore(artifact)
+	} else {
+		err = app.modelsFor(r).Artifacts.Update(artifact)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.unknownResearcherResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artifact": artifact}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
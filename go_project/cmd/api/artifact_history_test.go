@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"goproject/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestShowArtifactAsOfValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"empty", "/v1/artifacts/1?as_of=", http.StatusUnprocessableEntity},
+		{"not a timestamp", "/v1/artifacts/1?as_of=yesterday", http.StatusUnprocessableEntity},
+		// The mock doesn't keep times, so a valid timestamp finds nothing.
+		{"valid", "/v1/artifacts/1?as_of=2024-01-02T15:04:05Z", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			rr := app.serveTest(t, app.showArtifactHandler, testRequest{
+				method: http.MethodGet,
+				target: tc.target,
+				params: httprouter.Params{{Key: "id", Value: "1"}},
+				user:   testOwner,
+			})
+			if rr.Code != tc.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestRevertRestoresDeletedArtifact(t *testing.T) {
+	app := newTestApplication(t)
+	artifacts := app.models.Artifacts.(*mockArtifacts)
+
+	artifact := &data.Artifact{Title: "Phaistos disc", Age: 3700, Location: "Crete", Researcher_id: 1}
+	artifacts.Insert(artifact)
+	artifact.Location = "Heraklion"
+	artifacts.Update(artifact)
+	artifacts.Delete(int64(artifact.Id))
+
+	id := strconv.Itoa(artifact.Id)
+	rr := app.serveTest(t, app.revertArtifactHandler, testRequest{
+		method:      http.MethodPut,
+		target:      "/v1/artifacts/" + id + "/revert?to_version=1",
+		params:      httprouter.Params{{Key: "id", Value: id}},
+		user:        testOwner,
+		permissions: writePermissions,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	// Version 3 is the tombstone, so the restored artifact is version 4, with the
+	// content of version 1.
+	restored, err := artifacts.Get(int64(artifact.Id))
+	if err != nil {
+		t.Fatalf("artifact wasn't restored: %v", err)
+	}
+	if restored.Version != 4 || restored.Location != "Crete" {
+		t.Errorf("got %+v; want version 4 in Crete", restored)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportArtifacts(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		body        string
+		contentType string
+		wantStatus  int
+		wantErrors  map[string]map[string]string
+		wantRows    int
+	}{
+		{
+			name:       "JSON",
+			target:     "/v1/artifacts/import",
+			body:       `[{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}, {"title": "Phaistos disc", "age": 3700, "location": "Crete", "researcher_id": 1}]`,
+			wantStatus: http.StatusCreated,
+			wantRows:   2,
+		},
+		{
+			// The columns can come in any order, and the header isn't case-sensitive.
+			name:        "CSV",
+			target:      "/v1/artifacts/import",
+			body:        "Researcher_ID, title, location, age\n1, Snake goddess, Crete, 3600\n1, Phaistos disc, Crete, 3700\n",
+			contentType: "text/csv; charset=utf-8",
+			wantStatus:  http.StatusCreated,
+			wantRows:    2,
+		},
+		{
+			name:       "JSON row errors",
+			target:     "/v1/artifacts/import",
+			body:       `[{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}, {"age": -1, "location": "Crete", "researcher_id": 1}, {"title": "Linear B tablet", "age": 3400, "location": "Pylos", "researcher_id": 2}]`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErrors: map[string]map[string]string{
+				"2": {"name": "must be provided", "age": "must be greater than 0"},
+				"3": {"researcher_id": "must be your own researcher profile"},
+			},
+		},
+		{
+			// Values which can't be parsed are reported together with the validation
+			// errors for the same row.
+			name:        "CSV row errors",
+			target:      "/v1/artifacts/import",
+			body:        "title,age,location,researcher_id\nSnake goddess,old,Crete,1\nPhaistos disc,3700,,one\n",
+			contentType: "text/csv",
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantErrors: map[string]map[string]string{
+				"1": {"age": "must be an integer value"},
+				"2": {"location": "must be provided", "researcher_id": "must be an integer value"},
+			},
+		},
+		{
+			name:       "dry run",
+			target:     "/v1/artifacts/import?dry_run=true",
+			body:       `[{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}]`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "dry run with row errors",
+			target:     "/v1/artifacts/import?dry_run=true",
+			body:       `[{"title": "Snake goddess", "age": 3600, "researcher_id": 1}]`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErrors: map[string]map[string]string{
+				"1": {"location": "must be provided"},
+			},
+		},
+		{
+			name:       "invalid dry run",
+			target:     "/v1/artifacts/import?dry_run=maybe",
+			body:       `[{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}]`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{"empty JSON array", "/v1/artifacts/import", `[]`, "", http.StatusBadRequest, nil, 0},
+		{"badly-formed JSON", "/v1/artifacts/import", `[{"title": }]`, "", http.StatusBadRequest, nil, 0},
+		{"empty CSV", "/v1/artifacts/import", "", "text/csv", http.StatusBadRequest, nil, 0},
+		{"CSV header only", "/v1/artifacts/import", "title,age,location,researcher_id\n", "text/csv", http.StatusBadRequest, nil, 0},
+		{"unknown CSV column", "/v1/artifacts/import", "title,age,site\nSnake goddess,3600,Crete\n", "text/csv", http.StatusBadRequest, nil, 0},
+		{"badly-formed CSV", "/v1/artifacts/import", "title,age\nSnake goddess,3600,Crete\n", "text/csv", http.StatusBadRequest, nil, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			artifacts := app.models.Artifacts.(*mockArtifacts)
+			before := len(artifacts.artifacts)
+
+			rr := app.serveTest(t, app.importArtifactsHandler, testRequest{
+				method:      http.MethodPost,
+				target:      tc.target,
+				body:        tc.body,
+				contentType: tc.contentType,
+				user:        testOwner,
+				permissions: writePermissions,
+			})
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+
+			// Nothing is imported unless every row is valid and it isn't a dry run.
+			if got := len(artifacts.artifacts) - before; got != tc.wantRows {
+				t.Errorf("imported %d artifacts; want %d", got, tc.wantRows)
+			}
+
+			if tc.wantErrors != nil {
+				var body struct {
+					Error map[string]map[string]string `json:"error"`
+				}
+				decodeResponse(t, rr, &body)
+				if !reflect.DeepEqual(body.Error, tc.wantErrors) {
+					t.Errorf("got errors %v; want %v", body.Error, tc.wantErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestImportArtifactsDryRunResponse(t *testing.T) {
+	app := newTestApplication(t)
+
+	rr := app.serveTest(t, app.importArtifactsHandler, testRequest{
+		method:      http.MethodPost,
+		target:      "/v1/artifacts/import?dry_run=true",
+		body:        "title,age,location,researcher_id\nSnake goddess,3600,Crete,1\nPhaistos disc,3700,Crete,1\n",
+		contentType: "text/csv",
+		user:        testOwner,
+		permissions: writePermissions,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var body struct {
+		DryRun bool `json:"dry_run"`
+		Rows   int  `json:"rows"`
+	}
+	decodeResponse(t, rr, &body)
+	if !body.DryRun || body.Rows != 2 {
+		t.Errorf("got %+v; want a dry run of 2 rows", body)
+	}
+}
+
+// The import route is registered as a path of its own, so it goes through the same
+// middleware as every other route, and a POST to any other artifact is still a 405.
+func TestArtifactRoutes(t *testing.T) {
+	tests := []struct {
+		method     string
+		target     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{http.MethodPost, "/v1/artifacts/import", http.StatusUnauthorized, ""},
+		{http.MethodPut, "/v1/artifacts/1/revert", http.StatusUnauthorized, ""},
+		{http.MethodPost, "/v1/artifacts/1", http.StatusMethodNotAllowed, "GET"},
+		{http.MethodPost, "/v1/artifacts/1/revert", http.StatusMethodNotAllowed, "PUT"},
+	}
+
+	app := newTestApplication(t)
+	routes := app.routes()
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.target, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+			if allow := rr.Header().Get("Allow"); !strings.Contains(allow, tc.wantAllow) {
+				t.Errorf("got Allow %q; want it to include %q", allow, tc.wantAllow)
+			}
+		})
+	}
+}
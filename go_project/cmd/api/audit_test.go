@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+)
+
+func TestListAuditEventsValidation(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"negative actor", "/v1/audit?actor_id=-1"},
+		{"negative target", "/v1/audit?target_id=-1"},
+		{"invalid time", "/v1/audit?since=yesterday"},
+		{"backwards range", "/v1/audit?since=2024-02-01T00:00:00Z&until=2024-01-01T00:00:00Z"},
+		{"unknown sort", "/v1/audit?sort=action"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := app.serveTest(t, app.listAuditEventsHandler, testRequest{method: http.MethodGet, target: tc.target})
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+			}
+		})
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	app := newTestDBApplication(t)
+	requestID := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	models := app.models.As(&data.Actor{UserID: 42, RequestID: requestID, IP: "192.0.2.1"})
+
+	researcher := &data.Researcher{Name: "Arthur Evans", Specialization: "Minoan", Project: "Knossos"}
+	if err := models.Researchers.Insert(researcher); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Researchers.Delete(int64(researcher.Id)) })
+
+	researcher.Project = "Palace of Minos"
+	if err := models.Researchers.Update(researcher); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := app.serveTest(t, app.listAuditEventsHandler, testRequest{
+		method: http.MethodGet,
+		target: "/v1/audit?request_id=" + requestID,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var response struct {
+		AuditEvents []data.AuditEvent `json:"audit_events"`
+		Metadata    data.Metadata     `json:"metadata"`
+	}
+	decodeResponse(t, rr, &response)
+
+	// The newest events come first.
+	want := []string{"researcher.updated", "researcher.created"}
+	if len(response.AuditEvents) != len(want) {
+		t.Fatalf("got %d events; want %d", len(response.AuditEvents), len(want))
+	}
+	for i, event := range response.AuditEvents {
+		if event.Action != want[i] || event.ActorID != 42 || event.TargetID != int64(researcher.Id) {
+			t.Errorf("event %d: got %+v; want %s of the researcher by user 42", i, event, want[i])
+		}
+	}
+	if change := response.AuditEvents[0].Changes["project"]; change.From != "Knossos" || change.To != "Palace of Minos" {
+		t.Errorf("got project change %+v; want Knossos to Palace of Minos", change)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"goproject/internal/data"
+	"goproject/internal/validator"
+)
+
+// maxBatchOperations is the largest number of operations accepted in a single batch.
+const maxBatchOperations = 100
+
+// batchOperation is a single create, update or delete in a batch request. Data holds
+// the same JSON body that the equivalent POST or PUT endpoint would accept, and is
+// decoded once we know which resource the operation is for.
+type batchOperation struct {
+	Action   string          `json:"action"`
+	Resource string          `json:"resource"`
+	ID       int64           `json:"id"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// batchResult is the outcome of a single operation. Status is the HTTP status code that
+// the equivalent single-record request would have returned.
+type batchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Record interface{} `json:"record,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+
+	// err holds the underlying error for unexpected failures, so that it can be logged
+	// without being sent to the client.
+	err error
+}
+
+// errBatchServerError is reported in place of the real error message when an operation
+// fails unexpectedly, in the same way as serverErrorResponse().
+const errBatchServerError = "the server encountered a problem and could not process this operation"
+
+func (app *application) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ContinueOnError bool             `json:"continue_on_error"`
+		Operations      []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least one operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+	for i, op := range input.Operations {
+		v.Check(validator.In(op.Action, "create", "update", "delete"), fmt.Sprintf("operations[%d].action", i), "invalid action value")
+		v.Check(validator.In(op.Resource, "researchers", "expeditions", "artifacts"), fmt.Sprintf("operations[%d].resource", i), "invalid resource value")
+		if op.Action != "create" {
+			v.Check(op.ID > 0, fmt.Sprintf("operations[%d].id", i), "must be greater than zero")
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Load the user's permissions once up front, rather than once per operation.
+	user := app.contextGetUser(r)
+	r, permissions, err := app.loadPermissions(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tx, err := app.models.Begin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Rolling back after a successful commit is a no-op, so it's safe to always defer it.
+	defer tx.Rollback()
+
+	models := app.modelsFor(r).WithTx(tx)
+	access := writeAccess{user: user, permissions: permissions}
+
+	results := make([]batchResult, 0, len(input.Operations))
+	failed := false
+
+	for i, op := range input.Operations {
+		var result batchResult
+
+		if !permissions.Include(app.batchPermission(op)) {
+			result = batchResult{Status: http.StatusForbidden, Error: "your user account doesn't have the necessary permissions to perform this operation"}
+		} else {
+			result, err = app.runBatchOperation(tx, models, access, op, input.ContinueOnError)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		result.Index = i
+
+		if result.err != nil {
+			app.logError(r, result.err)
+		}
+
+		results = append(results, result)
+
+		if result.Status >= 300 {
+			failed = true
+			// Unless the client asked us to carry on, the first failure aborts the whole
+			// batch and nothing is written.
+			if !input.ContinueOnError {
+				break
+			}
+		}
+	}
+
+	if failed && !input.ContinueOnError {
+		err = tx.Rollback()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"committed": false, "results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"committed": true, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// batchPermission returns the permission code needed to carry out an operation. Every
+// operation in a batch changes data, so they all need write access to their resource.
+func (app *application) batchPermission(op batchOperation) string {
+	return op.Resource + ":write"
+}
+
+// runBatchOperation carries out a single operation using the transaction-bound models.
+// When continueOnError is set, each operation runs inside its own savepoint so that a
+// failed statement doesn't abort the transaction for the operations that follow it.
+// A non-nil error is only returned if the transaction itself can't be used any more.
+func (app *application) runBatchOperation(tx *sql.Tx, models data.Models, access writeAccess, op batchOperation, continueOnError bool) (batchResult, error) {
+	if continueOnError {
+		_, err := tx.Exec("SAVEPOINT batch_operation")
+		if err != nil {
+			return batchResult{}, err
+		}
+	}
+
+	result := app.applyBatchOperation(models, access, op)
+
+	if continueOnError {
+		statement := "RELEASE SAVEPOINT batch_operation"
+		if result.Status >= 300 {
+			statement = "ROLLBACK TO SAVEPOINT batch_operation"
+		}
+		_, err := tx.Exec(statement)
+		if err != nil {
+			return batchResult{}, err
+		}
+	}
+
+	return result, nil
+}
+
+func (app *application) applyBatchOperation(models data.Models, access writeAccess, op batchOperation) batchResult {
+	switch op.Resource {
+	case "researchers":
+		return app.applyResearcherOperation(models, op)
+	case "expeditions":
+		return app.applyExpeditionOperation(models, access, op)
+	default:
+		return app.applyArtifactOperation(models, access, op)
+	}
+}
+
+func (app *application) applyResearcherOperation(models data.Models, op batchOperation) batchResult {
+	if op.Action == "delete" {
+		return batchDeleteResult(models.Researchers.Delete(op.ID))
+	}
+
+	researcher := &data.Researcher{}
+	if op.Action == "update" {
+		existing, err := models.Researchers.Get(op.ID)
+		if err != nil {
+			return batchErrorResult(err)
+		}
+		researcher = existing
+	}
+
+	var input struct {
+		Name           string `json:"name"`
+		Specialization string `json:"specialization"`
+		Project        string `json:"project"`
+	}
+
+	err := decodeBatchData(op.Data, &input)
+	if err != nil {
+		return batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	researcher.Name = input.Name
+	researcher.Specialization = input.Specialization
+	researcher.Project = input.Project
+
+	v := validator.New()
+	if data.ValidateResearcher(v, researcher); !v.Valid() {
+		return batchResult{Status: http.StatusUnprocessableEntity, Error: v.Errors}
+	}
+
+	if op.Action == "create" {
+		err = models.Researchers.Insert(researcher)
+	} else {
+		err = models.Researchers.Update(researcher)
+	}
+	if err != nil {
+		return batchErrorResult(err)
+	}
+
+	return batchSavedResult(op, researcher)
+}
+
+func (app *application) applyExpeditionOperation(models data.Models, access writeAccess, op batchOperation) batchResult {
+	// Updates and deletes need the existing record, so that we can check who it
+	// belongs to.
+	expedition := &data.Expedition{}
+	if op.Action != "create" {
+		existing, err := models.Expeditions.Get(op.ID)
+		if err != nil {
+			return batchErrorResult(err)
+		}
+		if !access.allows("expeditions", existing.Researcher_id) {
+			return batchNotOwnerResult()
+		}
+		expedition = existing
+	}
+
+	if op.Action == "delete" {
+		return batchDeleteResult(models.Expeditions.Delete(op.ID))
+	}
+
+	var input struct {
+		Title          string `json:"title"`
+		ExpeditionYear int    `json:"expeditionYear"`
+		Researcher_id  int    `json:"researcher_id"`
+	}
+
+	err := decodeBatchData(op.Data, &input)
+	if err != nil {
+		return batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	expedition.Title = input.Title
+	expedition.ExpeditionYear = input.ExpeditionYear
+	expedition.Researcher_id = input.Researcher_id
+
+	v := validator.New()
+	if data.ValidateExpedition(v, expedition); !v.Valid() {
+		return batchResult{Status: http.StatusUnprocessableEntity, Error: v.Errors}
+	}
+
+	if !access.allows("expeditions", expedition.Researcher_id) {
+		return batchNotOwnerResult()
+	}
+
+	if op.Action == "create" {
+		err = models.Expeditions.Insert(expedition)
+	} else {
+		err = models.Expeditions.Update(expedition)
+	}
+	if err != nil {
+		return batchErrorResult(err)
+	}
+
+	return batchSavedResult(op, expedition)
+}
+
+func (app *application) applyArtifactOperation(models data.Models, access writeAccess, op batchOperation) batchResult {
+	// Updates and deletes need the existing record, so that we can check who it
+	// belongs to.
+	artifact := &data.Artifact{}
+	if op.Action != "create" {
+		existing, err := models.Artifacts.Get(op.ID)
+		if err != nil {
+			return batchErrorResult(err)
+		}
+		if !access.allows("artifacts", existing.Researcher_id) {
+			return batchNotOwnerResult()
+		}
+		artifact = existing
+	}
+
+	if op.Action == "delete" {
+		return batchDeleteResult(models.Artifacts.Delete(op.ID))
+	}
+
+	var input struct {
+		Title         string `json:"title"`
+		Age           int    `json:"age"`
+		Location      string `json:"location"`
+		Researcher_id int    `json:"researcher_id"`
+	}
+
+	err := decodeBatchData(op.Data, &input)
+	if err != nil {
+		return batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	artifact.Title = input.Title
+	artifact.Age = input.Age
+	artifact.Location = input.Location
+	artifact.Researcher_id = input.Researcher_id
+
+	v := validator.New()
+	if data.ValidateArtifact(v, artifact); !v.Valid() {
+		return batchResult{Status: http.StatusUnprocessableEntity, Error: v.Errors}
+	}
+
+	if !access.allows("artifacts", artifact.Researcher_id) {
+		return batchNotOwnerResult()
+	}
+
+	if op.Action == "create" {
+		err = models.Artifacts.Insert(artifact)
+	} else {
+		err = models.Artifacts.Update(artifact)
+	}
+	if err != nil {
+		return batchErrorResult(err)
+	}
+
+	return batchSavedResult(op, artifact)
+}
+
+// decodeBatchData decodes the data for a single operation, rejecting unknown fields in
+// the same way as readJSON() does for a normal request body.
+func decodeBatchData(raw json.RawMessage, dst interface{}) error {
+	if len(raw) == 0 {
+		return errors.New("data must be provided")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return fmt.Errorf("data is invalid: %w", err)
+	}
+	return nil
+}
+
+func batchSavedResult(op batchOperation, record interface{}) batchResult {
+	status := http.StatusOK
+	if op.Action == "create" {
+		status = http.StatusCreated
+	}
+	return batchResult{Status: status, Record: record}
+}
+
+func batchDeleteResult(err error) batchResult {
+	if errors.Is(err, data.ErrForeignKeyViolation) {
+		return batchResult{Status: http.StatusConflict, Error: "unable to delete the record because other records still refer to it"}
+	}
+	if err != nil {
+		return batchErrorResult(err)
+	}
+	return batchResult{Status: http.StatusOK}
+}
+
+// batchNotOwnerResult is the result for an operation on another researcher's records,
+// matching notOwnerResponse().
+func batchNotOwnerResult() batchResult {
+	return batchResult{Status: http.StatusForbidden, Error: "you can only change records belonging to your own researcher profile"}
+}
+
+// batchErrorResult converts an error returned by a model into the result that the
+// equivalent single-record endpoint would have sent.
+func batchErrorResult(err error) batchResult {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return batchResult{Status: http.StatusNotFound, Error: "the requested resource could not be found"}
+	case errors.Is(err, data.ErrEditConflict):
+		return batchResult{Status: http.StatusConflict, Error: "unable to update the record due to an edit conflict, please try again"}
+	case errors.Is(err, data.ErrForeignKeyViolation):
+		return batchResult{Status: http.StatusUnprocessableEntity, Error: map[string]string{"researcher_id": "must refer to an existing researcher"}}
+	default:
+		return batchResult{Status: http.StatusInternalServerError, Error: errBatchServerError, err: err}
+	}
+}
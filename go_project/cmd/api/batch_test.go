@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"goproject/internal/data"
+)
+
+func TestBatchValidation(t *testing.T) {
+	researcher := `{"name": "Arthur Evans", "specialization": "Minoan", "project": "Knossos"}`
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"badly-formed JSON", `{"operations": [}`, http.StatusBadRequest},
+		{"unknown field", `{"operations": [], "atomic": true}`, http.StatusBadRequest},
+		{"no operations", `{"operations": []}`, http.StatusUnprocessableEntity},
+		{"too many operations", `{"operations": [` + strings.Repeat(`{"action": "create", "resource": "researchers", "data": `+researcher+`},`, maxBatchOperations) + `{"action": "create", "resource": "researchers", "data": ` + researcher + `}]}`, http.StatusUnprocessableEntity},
+		{"unknown action", `{"operations": [{"action": "upsert", "resource": "researchers", "data": ` + researcher + `}]}`, http.StatusUnprocessableEntity},
+		{"unknown resource", `{"operations": [{"action": "create", "resource": "users", "data": {}}]}`, http.StatusUnprocessableEntity},
+		{"update without an id", `{"operations": [{"action": "update", "resource": "researchers", "data": ` + researcher + `}]}`, http.StatusUnprocessableEntity},
+		{"delete without an id", `{"operations": [{"action": "delete", "resource": "artifacts"}]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			// The whole batch is rejected before a transaction is started, so the test
+			// application doesn't need a database.
+			rr := app.serveTest(t, app.batchHandler, testRequest{
+				method:      http.MethodPost,
+				target:      "/v1/batch",
+				body:        tc.body,
+				user:        testAdmin,
+				permissions: writeAnyPermissions,
+			})
+			if rr.Code != tc.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestBatchErrorResult(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{data.ErrRecordNotFound, http.StatusNotFound},
+		{data.ErrEditConflict, http.StatusConflict},
+		{data.ErrForeignKeyViolation, http.StatusUnprocessableEntity},
+		{fmt.Errorf("update: %w", data.ErrEditConflict), http.StatusConflict},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			result := batchErrorResult(tc.err)
+			if result.Status != tc.want {
+				t.Errorf("got status %d; want %d", result.Status, tc.want)
+			}
+			// Only unexpected errors are kept for logging, and their message is never
+			// sent to the client.
+			if (result.err != nil) != (tc.want == http.StatusInternalServerError) {
+				t.Errorf("got err %v", result.err)
+			}
+			if result.err != nil && result.Error != errBatchServerError {
+				t.Errorf("got error %v; want %q", result.Error, errBatchServerError)
+			}
+		})
+	}
+
+	// Deleting a record which others still refer to is a conflict, rather than a
+	// validation error.
+	if result := batchDeleteResult(data.ErrForeignKeyViolation); result.Status != http.StatusConflict {
+		t.Errorf("got status %d for a delete; want %d", result.Status, http.StatusConflict)
+	}
+}
+
+func TestDecodeBatchData(t *testing.T) {
+	var dst struct {
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{"valid", `{"name": "Arthur Evans"}`, false},
+		{"missing", ``, true},
+		{"unknown field", `{"name": "Arthur Evans", "id": 1}`, true},
+		{"wrong type", `{"name": 1}`, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := decodeBatchData(json.RawMessage(tc.raw), &dst)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v; want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// batchResponse is the body sent back by batchHandler.
+type batchResponse struct {
+	Committed bool `json:"committed"`
+	Results   []struct {
+		Index  int `json:"index"`
+		Status int `json:"status"`
+		Record struct {
+			ID int64 `json:"id"`
+		} `json:"record"`
+	} `json:"results"`
+}
+
+func TestBatch(t *testing.T) {
+	app := newTestDBApplication(t)
+	user := app.newTestUser(t)
+	permissions := data.Permissions{"researchers:write", "artifacts:write", "artifacts:write_any", "expeditions:write", "expeditions:write_any"}
+
+	researcher := func(name string) string {
+		return fmt.Sprintf(`{"action": "create", "resource": "researchers", "data": {"name": %q, "specialization": "Minoan", "project": "Knossos"}}`, name)
+	}
+	// There's no researcher with this ID, so inserting the artifact fails in the
+	// database and aborts the transaction, unless it's inside a savepoint.
+	badArtifact := `{"action": "create", "resource": "artifacts", "data": {"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 2000000000}}`
+	missingArtifact := `{"action": "update", "resource": "artifacts", "id": 2000000000, "data": {"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}}`
+
+	tests := []struct {
+		name          string
+		body          string
+		permissions   data.Permissions
+		wantStatus    int
+		wantCommitted bool
+		wantResults   []int
+	}{
+		{
+			name:          "all succeed",
+			body:          `{"operations": [` + researcher("Arthur Evans") + `, ` + researcher("Harriet Boyd") + `]}`,
+			permissions:   permissions,
+			wantStatus:    http.StatusOK,
+			wantCommitted: true,
+			wantResults:   []int{http.StatusCreated, http.StatusCreated},
+		},
+		{
+			// The first failure stops the batch, so the last operation isn't attempted
+			// and the first one is rolled back.
+			name:        "rollback on the first failure",
+			body:        `{"operations": [` + researcher("Arthur Evans") + `, ` + missingArtifact + `, ` + researcher("Harriet Boyd") + `]}`,
+			permissions: permissions,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantResults: []int{http.StatusCreated, http.StatusNotFound},
+		},
+		{
+			name:        "rollback on a database error",
+			body:        `{"operations": [` + researcher("Arthur Evans") + `, ` + badArtifact + `]}`,
+			permissions: permissions,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantResults: []int{http.StatusCreated, http.StatusUnprocessableEntity},
+		},
+		{
+			// The savepoint around the failed insert keeps the transaction usable, so
+			// the operations either side of it are committed.
+			name:          "continue on error",
+			body:          `{"continue_on_error": true, "operations": [` + researcher("Arthur Evans") + `, ` + badArtifact + `, ` + missingArtifact + `, ` + researcher("Harriet Boyd") + `]}`,
+			permissions:   permissions,
+			wantStatus:    http.StatusOK,
+			wantCommitted: true,
+			wantResults:   []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusNotFound, http.StatusCreated},
+		},
+		{
+			name:        "permission checked for each operation",
+			body:        `{"operations": [` + missingArtifact + `, ` + researcher("Arthur Evans") + `]}`,
+			permissions: data.Permissions{"researchers:write"},
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantResults: []int{http.StatusForbidden},
+		},
+		{
+			name:          "permission denied with continue on error",
+			body:          `{"continue_on_error": true, "operations": [` + missingArtifact + `, ` + researcher("Arthur Evans") + `]}`,
+			permissions:   data.Permissions{"researchers:write"},
+			wantStatus:    http.StatusOK,
+			wantCommitted: true,
+			wantResults:   []int{http.StatusForbidden, http.StatusCreated},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := app.serveTest(t, app.batchHandler, testRequest{
+				method:      http.MethodPost,
+				target:      "/v1/batch",
+				body:        tc.body,
+				user:        user,
+				permissions: tc.permissions,
+			})
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tc.wantStatus, rr.Body)
+			}
+
+			var body batchResponse
+			decodeResponse(t, rr, &body)
+
+			for _, result := range body.Results {
+				if result.Status == http.StatusCreated {
+					id := result.Record.ID
+					t.Cleanup(func() { app.models.Researchers.Delete(id) })
+				}
+			}
+
+			if body.Committed != tc.wantCommitted {
+				t.Errorf("got committed %t; want %t", body.Committed, tc.wantCommitted)
+			}
+
+			var got []int
+			for i, result := range body.Results {
+				if result.Index != i {
+					t.Errorf("result %d has index %d", i, result.Index)
+				}
+				got = append(got, result.Status)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.wantResults) {
+				t.Errorf("got statuses %v; want %v", got, tc.wantResults)
+			}
+
+			// Records created by a successful operation exist only if the batch was
+			// committed.
+			for _, result := range body.Results {
+				if result.Status != http.StatusCreated {
+					continue
+				}
+				_, err := app.models.Researchers.Get(result.Record.ID)
+				switch {
+				case tc.wantCommitted && err != nil:
+					t.Errorf("researcher %d wasn't committed: %v", result.Record.ID, err)
+				case !tc.wantCommitted && !errors.Is(err, data.ErrRecordNotFound):
+					t.Errorf("researcher %d wasn't rolled back: %v", result.Record.ID, err)
+				}
+			}
+		})
+	}
+}
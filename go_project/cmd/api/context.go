@@ -10,6 +10,26 @@ type contextKey string
 // constant. We'll use this constant as the key for getting and setting user information
 // in the request context.
 const userContextKey = contextKey("user")
+
+// permissionsContextKey is the key for the user's permissions, which are loaded the first
+// time that a request needs them and then kept next to the user in the context.
+const permissionsContextKey = contextKey("permissions")
+
+// tokenHashContextKey is the key for the hash of the authentication token that the
+// request was made with, so that handlers can tell which session is the current one.
+const tokenHashContextKey = contextKey("tokenHash")
+
+// sessionIDContextKey is the key for the session ID claim of a JWT access token, which
+// is the ID of the refresh token that was issued alongside it.
+const sessionIDContextKey = contextKey("sessionID")
+
+// apiKeyContextKey is the key for the API key that the request was authenticated with,
+// if any. It limits the permissions that the request has.
+const apiKeyContextKey = contextKey("apiKey")
+
+// requestIDContextKey is the key for the ID of the request, which is sent back in the
+// X-Request-ID header and recorded against any changes that the request makes.
+const requestIDContextKey = contextKey("requestID")
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -27,4 +47,75 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 		panic("missing user value in request context")
 	}
 	return user
-}
\ No newline at end of file
+}
+
+// The contextSetPermissions() method returns a new copy of the request with the
+// provided permissions added to the context.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The contextGetPermissions() method retrieves the permissions from the request
+// context. Unlike the user, the permissions are only present once something has loaded
+// them, so the second return value reports whether they were found.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// The contextSetTokenHash() method returns a new copy of the request with the hash of
+// the current authentication token added to the context.
+func (app *application) contextSetTokenHash(r *http.Request, hash []byte) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenHashContextKey, hash)
+	return r.WithContext(ctx)
+}
+
+// The contextGetTokenHash() method retrieves the hash of the current authentication
+// token from the request context, or nil if the request wasn't authenticated with one.
+func (app *application) contextGetTokenHash(r *http.Request) []byte {
+	hash, _ := r.Context().Value(tokenHashContextKey).([]byte)
+	return hash
+}
+
+// The contextSetSessionID() method returns a new copy of the request with the session ID
+// from a JWT access token added to the context.
+func (app *application) contextSetSessionID(r *http.Request, id int64) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetSessionID() method retrieves the session ID from the request context, or
+// zero if the request wasn't authenticated with a JWT.
+func (app *application) contextGetSessionID(r *http.Request) int64 {
+	id, _ := r.Context().Value(sessionIDContextKey).(int64)
+	return id
+}
+
+// The contextSetAPIKey() method returns a new copy of the request with the provided API
+// key added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() method retrieves the API key from the request context, or nil
+// if the request wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// The contextSetRequestID() method returns a new copy of the request with the request
+// ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method retrieves the request ID from the request context, or
+// an empty string if there isn't one.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
@@ -46,13 +46,24 @@ func (app *application) createExpeditionHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// Unless they can write any researcher's expeditions, users can only create expeditions
+	// for the researcher profile that they're linked to.
+	if !app.requireOwnership(w, r, "expeditions", expedition.Researcher_id) {
+		return
+	}
+
 	// Call the Insert() method on our researchers model, passing in a pointer to the
 	// validated song struct. This will create a record in the database and update the
 	// song struct with the system-generated information.
-	err = app.models.Expeditions.Insert(expedition)
+	err = app.modelsFor(r).Expeditions.Insert(expedition)
 
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.unknownResearcherResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -156,6 +167,10 @@ func (app *application) updateExpeditionHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// Remember who the expedition belonged to before the update, because moving it to
+	// another researcher needs write access to both of them.
+	owner := expedition.Researcher_id
+
 	// Copy the values from the request body to the appropriate fields of the movie
 	// record.
 
@@ -175,10 +190,19 @@ func (app *application) updateExpeditionHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if !app.requireOwnership(w, r, "expeditions", owner, expedition.Researcher_id) {
+		return
+	}
+
 	// Pass the updated researcher record to our new Update() method.
-	err = app.models.Expeditions.Update(expedition)
+	err = app.modelsFor(r).Expeditions.Update(expedition)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.unknownResearcherResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -199,9 +223,25 @@ func (app *application) deleteExpeditionHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// Fetch the expedition first, so that we know which researcher it belongs to.
+	expedition, err := app.models.Expeditions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireOwnership(w, r, "expeditions", expedition.Researcher_id) {
+		return
+	}
+
 	// Delete the researcher from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Expeditions.Delete(id)
+	err = app.modelsFor(r).Expeditions.Delete(id)
 
 	if err != nil {
 		switch {
@@ -254,12 +294,24 @@ func (app *application) listExpeditionsHandler(w http.ResponseWriter, r *http.Re
 	// input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 	input.Filters.SortSafelist = []string{"expedition_id", "title", "expeditionYear", "researcher_id", "-expedition_id", "-title", "-expeditionYear", "-researcher_id"}
 
+	// Work out whether the client wants the usual paginated JSON envelope or a
+	// streamed CSV/NDJSON export of every matching row.
+	format := app.readFormat(r, v)
+
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+
+	// Exports ignore the page and page_size parameters, but go through exactly the same
+	// filter and sort validation as a normal page of results.
+	if format != formatJSON {
+		app.exportExpeditions(w, r, format, input.Title, input.ExpeditionYear, input.Filters)
+		return
+	}
+
 	// Call the GetAll() method to retrieve the researchers, passing in the various filter
 	// parameters.
 	// Accept the metadata struct as a return value.
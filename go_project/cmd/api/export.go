@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/validator"
+)
+
+// Define constants for the output formats supported by the list endpoints. "json" is
+// the normal paginated envelope; the other two stream every matching row.
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// The readFormat() helper works out which output format the client asked for. An
+// explicit ?format= query string parameter always wins; otherwise we look at the
+// media types in the Accept header, and fall back to JSON if none of them are ones
+// that we support. An unsupported ?format= value is recorded in the validator.
+func (app *application) readFormat(r *http.Request, v *validator.Validator) string {
+	format := app.readString(r.URL.Query(), "format", "")
+	if format != "" {
+		v.Check(validator.In(format, formatJSON, formatCSV, formatNDJSON), "format", "invalid format value")
+		return format
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "text/csv":
+			return formatCSV
+		case "application/x-ndjson":
+			return formatNDJSON
+		case "application/json":
+			return formatJSON
+		}
+	}
+
+	return formatJSON
+}
+
+// exportWriter streams records to the client one at a time in either CSV or NDJSON
+// format, flushing after every batch so that nothing is buffered in memory.
+type exportWriter struct {
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	flusher http.Flusher
+	written int
+}
+
+// The newExportWriter() helper sets the response headers for the given format, sends
+// the 200 OK status code and (for CSV) the header row. After this has been called it's
+// no longer possible to send an error response, so any problems while streaming can
+// only be logged.
+func (app *application) newExportWriter(w http.ResponseWriter, format, name string, header []string) (*exportWriter, error) {
+	// Exports can legitimately take longer than the server's WriteTimeout, so we lift
+	// the write deadline for this response only.
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &exportWriter{format: format}
+	ew.flusher, _ = w.(http.Flusher)
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+		ew.csv = csv.NewWriter(w)
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		ew.json = json.NewEncoder(w)
+	default:
+		panic("unsupported export format: " + format)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if ew.csv != nil {
+		err = ew.csv.Write(header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ew, nil
+}
+
+// write() sends a single record. The record itself is used for NDJSON output, and the
+// row (which must line up with the header passed to newExportWriter) for CSV output.
+func (ew *exportWriter) write(record interface{}, row []string) error {
+	var err error
+	if ew.csv != nil {
+		err = ew.csv.Write(row)
+	} else {
+		err = ew.json.Encode(record)
+	}
+	if err != nil {
+		return err
+	}
+
+	ew.written++
+	if ew.written%100 == 0 {
+		return ew.flush()
+	}
+	return nil
+}
+
+// flush() pushes anything buffered so far out to the client.
+func (ew *exportWriter) flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if ew.flusher != nil {
+		ew.flusher.Flush()
+	}
+	return nil
+}
+
+func (app *application) exportResearchers(w http.ResponseWriter, r *http.Request, format, name, specialization string, filters data.Filters) {
+	ew, err := app.newExportWriter(w, format, "researchers", []string{"id", "name", "specialization", "project"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Researchers.StreamAll(name, specialization, filters, func(researcher *data.Researcher) error {
+		row := []string{
+			strconv.Itoa(researcher.Id),
+			researcher.Name,
+			researcher.Specialization,
+			researcher.Project,
+		}
+		return ew.write(researcher, row)
+	})
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) exportExpeditions(w http.ResponseWriter, r *http.Request, format, title string, expeditionYear int, filters data.Filters) {
+	ew, err := app.newExportWriter(w, format, "expeditions", []string{"id", "title", "expeditionYear", "researcher_id"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Expeditions.StreamAll(title, expeditionYear, filters, func(expedition *data.Expedition) error {
+		row := []string{
+			strconv.Itoa(expedition.Id),
+			expedition.Title,
+			strconv.Itoa(expedition.ExpeditionYear),
+			strconv.Itoa(expedition.Researcher_id),
+		}
+		return ew.write(expedition, row)
+	})
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) exportArtifacts(w http.ResponseWriter, r *http.Request, format, title string, age int, filters data.Filters) {
+	ew, err := app.newExportWriter(w, format, "artifacts", []string{"id", "title", "age", "location", "researcher_id"})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Artifacts.StreamAll(title, age, filters, func(artifact *data.Artifact) error {
+		row := []string{
+			strconv.Itoa(artifact.Id),
+			artifact.Title,
+			strconv.Itoa(artifact.Age),
+			artifact.Location,
+			strconv.Itoa(artifact.Researcher_id),
+		}
+		return ew.write(artifact, row)
+	})
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/validator"
+)
+
+func TestReadFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		accept  string
+		want    string
+		wantErr bool
+	}{
+		{"default", "/v1/artifacts", "", formatJSON, false},
+		{"format csv", "/v1/artifacts?format=csv", "", formatCSV, false},
+		{"format ndjson", "/v1/artifacts?format=ndjson", "", formatNDJSON, false},
+		{"format json", "/v1/artifacts?format=json", "", formatJSON, false},
+		{"unknown format", "/v1/artifacts?format=xml", "", "xml", true},
+		// The query string always wins over the Accept header.
+		{"format over Accept", "/v1/artifacts?format=json", "text/csv", formatJSON, false},
+		{"Accept csv", "/v1/artifacts", "text/csv", formatCSV, false},
+		{"Accept ndjson", "/v1/artifacts", "application/x-ndjson", formatNDJSON, false},
+		{"Accept with parameters", "/v1/artifacts", "text/csv; charset=utf-8; q=0.9", formatCSV, false},
+		// The first supported media type in the header is used.
+		{"Accept list", "/v1/artifacts", "text/html, application/x-ndjson, text/csv", formatNDJSON, false},
+		{"Accept json first", "/v1/artifacts", "application/json, text/csv", formatJSON, false},
+		{"Accept unsupported", "/v1/artifacts", "application/xml", formatJSON, false},
+		{"Accept anything", "/v1/artifacts", "*/*", formatJSON, false},
+		{"Accept malformed", "/v1/artifacts", ";;, text/csv", formatCSV, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+
+			v := validator.New()
+			if got := app.readFormat(r, v); got != tc.want {
+				t.Errorf("got format %q; want %q", got, tc.want)
+			}
+			if v.Valid() == tc.wantErr {
+				t.Errorf("got errors %v; want error: %t", v.Errors, tc.wantErr)
+			}
+		})
+	}
+}
+
+// exportRecorder is a ResponseRecorder which, like the real http.ResponseWriter, lets
+// the export lift the write deadline.
+type exportRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (exportRecorder) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// serveExport sends a list request for artifacts and returns the recorded response.
+func serveExport(t *testing.T, app *application, target, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+
+	rr := exportRecorder{httptest.NewRecorder()}
+	app.listArtifactsHandler(rr, r)
+	return rr.ResponseRecorder
+}
+
+func TestExportArtifacts(t *testing.T) {
+	app := newTestApplication(t)
+	artifacts := app.models.Artifacts.(*mockArtifacts)
+	// Commas, quotes and new lines must all survive a round trip through the CSV.
+	artifacts.Insert(&data.Artifact{Title: `Tablet "A", fragment`, Age: 3700, Location: "Crete\nHeraklion", Researcher_id: 2})
+
+	t.Run("csv", func(t *testing.T) {
+		rr := serveExport(t, app, "/v1/artifacts?format=csv", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+			t.Errorf("got Content-Type %q", got)
+		}
+		if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="artifacts.csv"` {
+			t.Errorf("got Content-Disposition %q", got)
+		}
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{
+			{"id", "title", "age", "location", "researcher_id"},
+			{"1", "Bull-leaping fresco", "3500", "Crete", "1"},
+			{"2", `Tablet "A", fragment`, "3700", "Crete\nHeraklion", "2"},
+		}
+		if !reflect.DeepEqual(records, want) {
+			t.Errorf("got %q; want %q", records, want)
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		rr := serveExport(t, app, "/v1/artifacts", "application/x-ndjson")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("got Content-Type %q", got)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("got %d lines; want 2: %q", len(lines), rr.Body)
+		}
+		var artifact data.Artifact
+		if err := json.Unmarshal([]byte(lines[1]), &artifact); err != nil {
+			t.Fatal(err)
+		}
+		if artifact.Title != `Tablet "A", fragment` || artifact.Location != "Crete\nHeraklion" {
+			t.Errorf("got %+v", artifact)
+		}
+	})
+
+	// Exports go through the same validation as a page of results.
+	for _, target := range []string{"/v1/artifacts?format=xml", "/v1/artifacts?format=csv&sort=-secret"} {
+		t.Run(target, func(t *testing.T) {
+			rr := serveExport(t, app, target, "")
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+			}
+		})
+	}
+}
+
+func TestExportWriterFlushes(t *testing.T) {
+	app := newTestApplication(t)
+	rr := exportRecorder{httptest.NewRecorder()}
+
+	ew, err := app.newExportWriter(rr, formatNDJSON, "artifacts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records are flushed to the client in batches of 100.
+	for i := 1; i <= 100; i++ {
+		if err := ew.write(i, nil); err != nil {
+			t.Fatal(err)
+		}
+		if flushed := rr.Flushed; flushed != (i == 100) {
+			t.Fatalf("after %d records got flushed %t", i, flushed)
+		}
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"goproject/internal/data"
 	"goproject/internal/validator"
 
 	"github.com/julienschmidt/httprouter"
@@ -29,6 +32,13 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// The readParam() helper returns the value of a named URL parameter from the current
+// request context, or the empty string if there is no such parameter.
+func (app *application) readParam(r *http.Request, name string) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName(name)
+}
+
 // Define a writeJSON() helper for sending responses. This takes the destination
 // http.ResponseWriter, the HTTP status code to send, the data to encode to JSON, and a
 // header map containing any additional HTTP headers we want to include in the response.
@@ -184,3 +194,67 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// The readTime() helper reads an RFC 3339 timestamp from the query string, in the same
+// way as readInt(). If no matching key could be found it returns the zero time.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp, like 2006-01-02T15:04:05Z")
+		return time.Time{}
+	}
+	return t
+}
+
+// The background() helper accepts an arbitrary function as a parameter and runs it in
+// a background goroutine. Any panic in the function is recovered and logged, rather
+// than bringing down the whole application.
+func (app *application) background(fn func()) {
+	// Increment the WaitGroup counter, so that a graceful shutdown waits for the task.
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// The clientIP() helper returns the IP address of the client that made the request,
+// without the port number.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// The auditActor() helper describes who is making changes in the given request, for the
+// audit trail. The user ID is passed in rather than taken from the context because some
+// requests (like activation and password resets) act for a user who isn't logged in.
+func (app *application) auditActor(r *http.Request, userID int64) *data.Actor {
+	return &data.Actor{
+		UserID:    userID,
+		RequestID: app.contextGetRequestID(r),
+		IP:        app.clientIP(r),
+	}
+}
+
+// The modelsFor() helper returns a copy of the models which record every change that
+// they make in the audit trail against the current user and request. Handlers should
+// make their changes through these models rather than app.models.
+func (app *application) modelsFor(r *http.Request) data.Models {
+	return app.models.As(app.auditActor(r, app.contextGetUser(r).ID))
+}
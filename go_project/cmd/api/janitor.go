@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// cleanupTask is one of the jobs that the janitor does: deleting a kind of stale record
+// and reporting how many it deleted.
+type cleanupTask struct {
+	name string
+	run  func() (int64, error)
+}
+
+// The cleanupTasks() method returns the janitor's jobs. Deleting old webhook deliveries
+// can be turned off with the webhook-retention flag, and deleting unactivated users is
+// only included when it has been turned on with the cleanup-unactivated-days flag.
+func (app *application) cleanupTasks() []cleanupTask {
+	tasks := []cleanupTask{
+		{"expired_tokens", app.models.Tokens.DeleteExpired},
+		{"expired_idempotency_keys", app.models.IdempotencyKeys.DeleteExpired},
+		{"expired_oidc_states", app.models.OIDCStates.DeleteExpired},
+		// Failed logins are forgotten once the lockout period has passed anyway, so
+		// there's no point keeping them any longer.
+		{"stale_login_failures", func() (int64, error) {
+			return app.models.LoginFailures.DeleteStale(app.config.login.lockout)
+		}},
+		{"expired_cached_permissions", func() (int64, error) {
+			return app.models.Permissions.Cache.Sweep(), nil
+		}},
+	}
+
+	if retention := app.config.webhooks.retention; retention > 0 {
+		tasks = append(tasks, cleanupTask{"finished_webhook_deliveries", func() (int64, error) {
+			return app.models.Deliveries.DeleteFinished(retention)
+		}})
+	}
+
+	if days := app.config.cleanup.unactivatedDays; days > 0 {
+		tasks = append(tasks, cleanupTask{"unactivated_users", func() (int64, error) {
+			return app.models.Users.DeleteUnactivated(time.Duration(days) * 24 * time.Hour)
+		}})
+	}
+
+	return tasks
+}
+
+// The cleanup() method runs each of the janitor's jobs once and logs how many records
+// each one deleted. A failed job doesn't stop the others from running; all of the
+// errors are returned together at the end.
+func (app *application) cleanup() error {
+	start := time.Now()
+	properties := make(map[string]string)
+
+	var errs []error
+	for _, task := range app.cleanupTasks() {
+		deleted, err := task.run()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cleanup %s: %w", task.name, err))
+			continue
+		}
+		properties[task.name] = strconv.FormatInt(deleted, 10)
+	}
+
+	properties["duration"] = time.Since(start).String()
+	app.logger.PrintInfo("cleanup completed", properties)
+
+	return errors.Join(errs...)
+}
+
+// The startJanitor() method runs cleanup() every cleanup-interval in a background
+// goroutine, until the stop channel is closed. A clean-up that's already running when
+// the server shuts down is allowed to finish, because the goroutine is tracked by
+// app.wg.
+func (app *application) startJanitor(stop <-chan struct{}) {
+	interval := app.config.cleanup.interval
+	if interval <= 0 {
+		return
+	}
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				app.runCleanup()
+			}
+		}
+	}()
+}
+
+// The runCleanup() method runs cleanup() from the janitor, logging any error and
+// recovering from any panic so that one bad run doesn't stop the janitor for good.
+func (app *application) runCleanup() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	err := app.cleanup()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"goproject/internal/jsonlog"
+)
+
+func TestCleanupTasks(t *testing.T) {
+	always := []string{"expired_tokens", "expired_idempotency_keys", "expired_oidc_states", "stale_login_failures", "expired_cached_permissions"}
+
+	tests := []struct {
+		name            string
+		retention       time.Duration
+		unactivatedDays int
+		want            []string
+	}{
+		{"defaults", 0, 0, always},
+		{"webhook retention", 30 * 24 * time.Hour, 0, append(always[:len(always):len(always)], "finished_webhook_deliveries")},
+		{"unactivated users", 0, 7, append(always[:len(always):len(always)], "unactivated_users")},
+		{"everything", time.Hour, 1, append(always[:len(always):len(always)], "finished_webhook_deliveries", "unactivated_users")},
+		// Negative values are treated like zero, rather than deleting everything.
+		{"negative", -time.Hour, -1, always},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.webhooks.retention = tc.retention
+			app.config.cleanup.unactivatedDays = tc.unactivatedDays
+
+			var got []string
+			for _, task := range app.cleanupTasks() {
+				got = append(got, task.name)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got tasks %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStartJanitorStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"turned off", 0},
+		{"running", time.Hour},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.cleanup.interval = tc.interval
+
+			stop := make(chan struct{})
+			app.startJanitor(stop)
+			close(stop)
+
+			// Shutting down waits for app.wg, so the janitor has to finish once it's
+			// told to stop.
+			done := make(chan struct{})
+			go func() {
+				app.wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the janitor didn't stop")
+			}
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	app := newTestDBApplication(t)
+	app.config.webhooks.retention = time.Hour
+	app.config.cleanup.unactivatedDays = 30
+
+	var buf bytes.Buffer
+	app.logger = jsonlog.NewLogger(&buf, jsonlog.LevelInfo)
+
+	if err := app.cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every task reports how many records it deleted.
+	var entry struct {
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties"`
+	}
+	if err := json.NewDecoder(&buf).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Message != "cleanup completed" {
+		t.Fatalf("got log message %q; want %q", entry.Message, "cleanup completed")
+	}
+	for _, task := range app.cleanupTasks() {
+		if _, ok := entry.Properties[task.name]; !ok {
+			t.Errorf("no count was logged for %s: %v", task.name, entry.Properties)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/jwt"
+)
+
+// The API can hand out two kinds of authentication token. In "opaque" mode (the
+// default) every token is a random string which is looked up in the tokens table on
+// each request. In "jwt" mode, logging in returns a short-lived signed JWT, which is
+// checked without touching the database, together with a long-lived refresh token
+// which is stored in the database and can be exchanged for a new pair.
+const (
+	authModeOpaque = "opaque"
+	authModeJWT    = "jwt"
+)
+
+// jwtIssuer is the "iss" claim of the access tokens that we issue.
+const jwtIssuer = "goproject"
+
+// accessClaims are the claims in a JWT access token. They carry everything that
+// authenticate() needs to rebuild the user, so that no database lookup is needed.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Activated    bool   `json:"activated"`
+	ResearcherID *int64 `json:"researcher_id,omitempty"`
+
+	// SessionID is the ID of the refresh token that was issued alongside the access
+	// token, which lets the user log out of this particular session.
+	SessionID int64 `json:"sid"`
+}
+
+// The newJWTSigner() function creates the signer for the configured algorithm. The
+// Ed25519 key is read from a PEM-encoded PKCS #8 file.
+func newJWTSigner(cfg config) (jwt.Signer, error) {
+	switch cfg.jwt.alg {
+	case "HS256":
+		if len(cfg.jwt.secret) < 32 {
+			return nil, errors.New("jwt-secret must be at least 32 bytes long")
+		}
+		return jwt.NewHS256([]byte(cfg.jwt.secret)), nil
+	case "EdDSA":
+		pemBytes, err := os.ReadFile(cfg.jwt.keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM-encoded key", cfg.jwt.keyFile)
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s does not contain an Ed25519 private key", cfg.jwt.keyFile)
+		}
+		return jwt.NewEdDSA(privateKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported jwt-alg %q", cfg.jwt.alg)
+	}
+}
+
+// The issueAuthentication() helper creates the tokens for a user who has just proved
+// who they are, in whichever form the configured auth mode calls for, and returns the
+// envelope to send to the client.
+func (app *application) issueAuthentication(r *http.Request, user *data.User) (envelope, error) {
+	if app.config.auth.mode != authModeJWT {
+		token, err := app.models.Tokens.NewSession(user.ID, 24*time.Hour, data.ScopeAuthentication, app.clientIP(r), r.UserAgent())
+		if err != nil {
+			return nil, err
+		}
+		return envelope{"authentication_token": token}, nil
+	}
+
+	refreshToken, err := app.models.Tokens.NewSession(user.ID, app.config.jwt.refreshTTL, data.ScopeRefresh, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.jwt.ttl)
+
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expiry.Unix(),
+		},
+		Name:         user.Name,
+		Email:        user.Email,
+		Activated:    user.Activated,
+		ResearcherID: user.ResearcherID,
+		SessionID:    refreshToken.ID,
+	}
+
+	accessToken, err := jwt.Sign(app.jwtSigner, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{
+		"authentication_token": envelope{"token": accessToken, "expiry": expiry},
+		"refresh_token":        refreshToken,
+	}, nil
+}
+
+// The userFromJWT() helper verifies a JWT access token and rebuilds the user from its
+// claims. The user's Version isn't known, so handlers which update the user must fetch
+// a fresh copy from the database first.
+func (app *application) userFromJWT(token string) (*data.User, int64, error) {
+	if app.jwtSigner == nil {
+		return nil, 0, jwt.ErrAlgorithm
+	}
+
+	var claims accessClaims
+	err := jwt.Parse(token, app.jwtSigner, &claims)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = claims.Validate(time.Now(), jwtIssuer, "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, 0, jwt.ErrMalformed
+	}
+
+	user := &data.User{
+		ID:           id,
+		Name:         claims.Name,
+		Email:        claims.Email,
+		Activated:    claims.Activated,
+		ResearcherID: claims.ResearcherID,
+	}
+	return user, claims.SessionID, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/jwt"
+)
+
+func TestRefreshAuthenticationToken(t *testing.T) {
+	app := newTestDBApplication(t)
+	app.config.auth.mode = authModeJWT
+	app.config.jwt.ttl = time.Minute
+	app.config.jwt.refreshTTL = time.Hour
+	app.jwtSigner = jwt.NewHS256([]byte("a secret which is at least 32 bytes long"))
+
+	user := app.newTestUser(t)
+	first, err := app.models.Tokens.NewSession(user.ID, time.Hour, data.ScopeRefresh, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type refreshResponse struct {
+		AuthenticationToken struct {
+			Token string `json:"token"`
+		} `json:"authentication_token"`
+		RefreshToken data.Token `json:"refresh_token"`
+	}
+
+	refresh := func(plaintext string) (int, refreshResponse) {
+		t.Helper()
+		rr := app.serveTest(t, app.refreshAuthenticationTokenHandler, testRequest{
+			method: http.MethodPost,
+			target: "/v1/tokens/refresh",
+			body:   fmt.Sprintf(`{"refresh_token": %q}`, plaintext),
+			user:   data.AnonymousUser,
+		})
+		var response refreshResponse
+		if rr.Code == http.StatusCreated {
+			decodeResponse(t, rr, &response)
+		}
+		return rr.Code, response
+	}
+
+	status, second := refresh(first.Plaintext)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d; want %d", status, http.StatusCreated)
+	}
+	if second.RefreshToken.Plaintext == "" || second.RefreshToken.Plaintext == first.Plaintext {
+		t.Errorf("got refresh token %q; want a new one", second.RefreshToken.Plaintext)
+	}
+
+	// The access token belongs to the user, and to the session of the new refresh
+	// token.
+	got, sessionID, err := app.userFromJWT(second.AuthenticationToken.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID || sessionID != second.RefreshToken.ID {
+		t.Errorf("got user %d in session %d; want user %d in session %d", got.ID, sessionID, user.ID, second.RefreshToken.ID)
+	}
+
+	// The first refresh token has been used up, but the new one works.
+	if status, _ := refresh(first.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("reusing a refresh token: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+	if status, _ := refresh(second.RefreshToken.Plaintext); status != http.StatusCreated {
+		t.Errorf("using the new refresh token: got status %d; want %d", status, http.StatusCreated)
+	}
+}
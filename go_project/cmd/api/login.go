@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"goproject/internal/data"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginAttempt identifies who is trying to log in, for counting failed logins. Email
+// addresses are lower-cased so that changing their case doesn't reset the count.
+type loginAttempt struct {
+	email string
+	ip    string
+}
+
+func (app *application) newLoginAttempt(r *http.Request, email string) loginAttempt {
+	return loginAttempt{email: strings.ToLower(email), ip: app.clientIP(r)}
+}
+
+// The loginThrottle() helper returns the throttling rules for a kind of key. An IP
+// address can be shared by many people (an office or a lab, say), so it's allowed more
+// failures than a single email address before it's locked out.
+func (app *application) loginThrottle(kind string) data.LoginThrottle {
+	throttle := data.LoginThrottle{
+		FreeAttempts: app.config.login.freeAttempts,
+		MaxFailures:  app.config.login.maxFailures,
+		BaseDelay:    app.config.login.baseDelay,
+		MaxDelay:     app.config.login.maxDelay,
+		Lockout:      app.config.login.lockout,
+	}
+	if kind == data.LoginFailureIP {
+		throttle.MaxFailures = app.config.login.maxIPFailures
+	}
+	return throttle
+}
+
+// The checkLoginThrottle() helper checks the attempt against the failed logins
+// recorded for its email address and its IP address. If the client has to wait before
+// trying again, it sends a 423 Locked response (when the email address is locked out)
+// or a 429 Too Many Requests response itself and returns false, and the attempt isn't
+// counted. Otherwise the attempt is counted as a failed login for both addresses up
+// front, so that a burst of attempts sent at the same time can't all get in before any
+// of them has been recorded. Once the credentials have been checked, the handler must
+// call refundLoginAttempt() or resetLoginFailures() if they were right; wrong
+// credentials need nothing more, as they've already been counted.
+func (app *application) checkLoginThrottle(w http.ResponseWriter, r *http.Request, attempt loginAttempt) bool {
+	waits, err := app.models.LoginFailures.Attempt(
+		data.LoginKey{Kind: data.LoginFailureEmail, Key: attempt.email, Throttle: app.loginThrottle(data.LoginFailureEmail)},
+		data.LoginKey{Kind: data.LoginFailureIP, Key: attempt.ip, Throttle: app.loginThrottle(data.LoginFailureIP)},
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	email, ip := waits[0], waits[1]
+
+	switch {
+	case email.Locked:
+		app.accountLockedResponse(w, r, email.Wait)
+		return false
+	case email.Wait > 0 || ip.Wait > 0:
+		app.tooManyLoginAttemptsResponse(w, r, max(email.Wait, ip.Wait))
+		return false
+	}
+	return true
+}
+
+// The refundLoginAttempt() helper takes back the failure that checkLoginThrottle()
+// counted for an attempt whose credentials were right, without forgetting any earlier
+// failures. Errors are only logged, because they don't change the response.
+func (app *application) refundLoginAttempt(r *http.Request, attempt loginAttempt) {
+	err := app.models.LoginFailures.Refund(data.LoginFailureEmail, attempt.email)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.models.LoginFailures.Refund(data.LoginFailureIP, attempt.ip)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The resetLoginFailures() helper is called after a successful login. It forgets the
+// failed logins for the email address, but only takes back the attempt itself from the
+// IP address: otherwise someone guessing the passwords of other accounts could clear
+// their IP address's count by logging in to their own account now and then.
+func (app *application) resetLoginFailures(r *http.Request, attempt loginAttempt) {
+	err := app.models.LoginFailures.Reset(data.LoginFailureEmail, attempt.email)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.models.LoginFailures.Refund(data.LoginFailureIP, attempt.ip)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The rehashPassword() helper upgrades the user's password hash to the configured
+// algorithm and parameters, if it isn't already using them. It's called after a
+// successful login, which is the only time that we have the plaintext password. A
+// failure here is logged but doesn't stop the user logging in, as the old hash still
+// works and we'll try again next time.
+func (app *application) rehashPassword(r *http.Request, user *data.User, plaintext string) {
+	if !user.Password.NeedsRehash() {
+		return
+	}
+
+	err := user.Password.Set(plaintext)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	err = app.models.As(app.auditActor(r, user.ID)).Users.Update(user)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The newPasswordHasher() function returns the password hasher chosen by the
+// password-hasher flag.
+func newPasswordHasher(cfg config) (data.PasswordHasher, error) {
+	switch cfg.passwords.hasher {
+	case "bcrypt":
+		if cfg.passwords.bcryptCost < bcrypt.MinCost || cfg.passwords.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return data.BcryptHasher{Cost: cfg.passwords.bcryptCost}, nil
+	case "argon2id":
+		if cfg.passwords.argon2Memory < 8*uint(cfg.passwords.argon2Parallelism) || cfg.passwords.argon2Iterations < 1 || cfg.passwords.argon2Parallelism < 1 || cfg.passwords.argon2Parallelism > 255 {
+			return nil, fmt.Errorf("invalid argon2id parameters")
+		}
+		return data.Argon2idHasher{
+			Memory:      uint32(cfg.passwords.argon2Memory),
+			Iterations:  uint32(cfg.passwords.argon2Iterations),
+			Parallelism: uint8(cfg.passwords.argon2Parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported password-hasher %q", cfg.passwords.hasher)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"goproject/internal/data"
+)
+
+func TestLoginRehashesPassword(t *testing.T) {
+	app := newTestDBApplication(t)
+
+	previous := data.DefaultPasswordHasher
+	t.Cleanup(func() { data.DefaultPasswordHasher = previous })
+
+	// The user's password is hashed with bcrypt, and then the default changes.
+	data.DefaultPasswordHasher = data.BcryptHasher{Cost: 4}
+	user := app.newTestUser(t)
+	data.DefaultPasswordHasher = data.Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	login := func(password string) int {
+		t.Helper()
+		rr := app.serveTest(t, app.createAuthenticationTokenHandler, testRequest{
+			method: http.MethodPost,
+			target: "/v1/tokens/login",
+			body:   fmt.Sprintf(`{"email": %q, "password": %q}`, user.Email, password),
+			user:   data.AnonymousUser,
+		})
+		return rr.Code
+	}
+	needsRehash := func() bool {
+		t.Helper()
+		saved, err := app.models.Users.Get(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saved.Password.NeedsRehash()
+	}
+
+	// A failed login doesn't touch the hash.
+	if status := login("not the password"); status != http.StatusUnauthorized {
+		t.Fatalf("logging in with the wrong password: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+	if !needsRehash() {
+		t.Fatal("the hash was changed by a failed login")
+	}
+
+	if status := login(testPassword); status != http.StatusCreated {
+		t.Fatalf("logging in: got status %d; want %d", status, http.StatusCreated)
+	}
+	if needsRehash() {
+		t.Error("the hash wasn't upgraded when the user logged in")
+	}
+
+	// The new hash works.
+	if status := login(testPassword); status != http.StatusCreated {
+		t.Errorf("logging in with the new hash: got status %d; want %d", status, http.StatusCreated)
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	tests := []struct {
+		name        string
+		hasher      string
+		bcryptCost  int
+		memory      uint
+		iterations  uint
+		parallelism uint
+		want        data.PasswordHasher
+	}{
+		{name: "bcrypt", hasher: "bcrypt", bcryptCost: 12, want: data.BcryptHasher{Cost: 12}},
+		{name: "bcrypt cost too low", hasher: "bcrypt", bcryptCost: 3},
+		{name: "bcrypt cost too high", hasher: "bcrypt", bcryptCost: 32},
+		{
+			name: "argon2id", hasher: "argon2id", memory: 65536, iterations: 3, parallelism: 2,
+			want: data.Argon2idHasher{Memory: 65536, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		},
+		{name: "argon2id too little memory", hasher: "argon2id", memory: 15, iterations: 3, parallelism: 2},
+		{name: "argon2id no iterations", hasher: "argon2id", memory: 65536, iterations: 0, parallelism: 2},
+		{name: "argon2id no parallelism", hasher: "argon2id", memory: 65536, iterations: 3, parallelism: 0},
+		{name: "argon2id too much parallelism", hasher: "argon2id", memory: 65536, iterations: 3, parallelism: 256},
+		{name: "scrypt", hasher: "scrypt"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg config
+			cfg.passwords.hasher = tc.hasher
+			cfg.passwords.bcryptCost = tc.bcryptCost
+			cfg.passwords.argon2Memory = tc.memory
+			cfg.passwords.argon2Iterations = tc.iterations
+			cfg.passwords.argon2Parallelism = tc.parallelism
+
+			hasher, err := newPasswordHasher(cfg)
+			if tc.want == nil {
+				if err == nil {
+					t.Errorf("got %#v; want an error", hasher)
+				}
+				return
+			}
+			if err != nil || hasher != tc.want {
+				t.Errorf("got %#v, %v; want %#v", hasher, err, tc.want)
+			}
+		})
+	}
+}
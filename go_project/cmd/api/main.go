@@ -6,13 +6,16 @@ import (
 	"flag"
 	"fmt"
 	"goproject/internal/data"
-	"log"
+	"goproject/internal/jsonlog"
+	"goproject/internal/jwt"
+	"goproject/internal/mailer"
+	"goproject/internal/oidc"
+	"goproject/internal/webhook"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
-	// "goproject/internal/jsonlog"
-
 	// Import the pq driver so that it can register itself with the database/sql
 	// package. Note that we alias this import to the blank identifier, to stop the Go
 	// compiler complaining that the package isn't being used.
@@ -44,6 +47,58 @@ type config struct {
 		password string
 		sender   string
 	}
+	stats struct {
+		maxAge time.Duration
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	auth struct {
+		mode string
+	}
+	login struct {
+		freeAttempts  int
+		maxFailures   int
+		maxIPFailures int
+		baseDelay     time.Duration
+		maxDelay      time.Duration
+		lockout       time.Duration
+	}
+	passwords struct {
+		minScore          int
+		breachedDir       string
+		hasher            string
+		bcryptCost        int
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+	}
+	cleanup struct {
+		interval        time.Duration
+		unactivatedDays int
+	}
+	webhooks struct {
+		pollInterval time.Duration
+		timeout      time.Duration
+		maxAttempts  int
+		retention    time.Duration
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	jwt struct {
+		alg        string
+		secret     string
+		keyFile    string
+		ttl        time.Duration
+		refreshTTL time.Duration
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
@@ -51,8 +106,27 @@ type config struct {
 // logger, but it will grow to include a lot more as our build progresses.
 type application struct {
 	config config
-	logger *log.Logger
+	logger *jsonlog.Logger
 	models data.Models
+	mailer mailer.Mailer
+
+	// jwtSigner signs and verifies JWT access tokens. It's nil unless the auth mode is
+	// "jwt".
+	jwtSigner jwt.Signer
+
+	// passwordPolicy is checked whenever a user chooses a new password.
+	passwordPolicy *data.PasswordPolicy
+
+	// oidc is the identity provider that users can log in with. It's nil unless an
+	// issuer has been configured.
+	oidc *oidc.Provider
+
+	// webhookSender makes the requests for webhook deliveries.
+	webhookSender *webhook.Sender
+
+	// wg tracks the goroutines started by background() and the janitor, so that a
+	// graceful shutdown can wait for them to finish.
+	wg sync.WaitGroup
 }
 
 func main() {
@@ -76,18 +150,59 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "e89654b1c53c45", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
 
+	flag.DurationVar(&cfg.stats.maxAge, "stats-max-age", time.Minute, "How long clients may cache the statistics endpoints (0 to disable)")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key values are remembered")
+
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", 0, "How long to cache each user's permissions in memory (0 to disable)")
+
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeOpaque, "Kind of authentication token issued at login (opaque|jwt)")
+	flag.StringVar(&cfg.jwt.alg, "jwt-alg", "HS256", "JWT signing algorithm (HS256|EdDSA)")
+	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT signing secret for HS256 (at least 32 bytes)")
+	flag.StringVar(&cfg.jwt.keyFile, "jwt-key-file", "", "PEM-encoded PKCS #8 Ed25519 private key for EdDSA")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", 15*time.Minute, "How long JWT access tokens are valid for")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "How long refresh tokens are valid for")
+
+	flag.IntVar(&cfg.login.freeAttempts, "login-free-attempts", 3, "Failed logins allowed before backoff starts")
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 10, "Failed logins for one email address before it is locked out")
+	flag.IntVar(&cfg.login.maxIPFailures, "login-max-ip-failures", 50, "Failed logins from one IP address before it is locked out")
+	flag.DurationVar(&cfg.login.baseDelay, "login-base-delay", time.Second, "Backoff after the first throttled failed login")
+	flag.DurationVar(&cfg.login.maxDelay, "login-max-delay", time.Minute, "Longest backoff between failed logins")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "How long a lockout lasts, and how long failed logins are remembered")
+
+	flag.IntVar(&cfg.passwords.minScore, "password-min-score", 3, "Lowest strength score (0-4) allowed for new passwords")
+	flag.StringVar(&cfg.passwords.breachedDir, "password-breached-dir", "", "Directory of Pwned Passwords range files of breached passwords to reject (empty to disable)")
+	flag.StringVar(&cfg.passwords.hasher, "password-hasher", "bcrypt", "Algorithm for hashing new passwords (bcrypt|argon2id)")
+	flag.IntVar(&cfg.passwords.bcryptCost, "bcrypt-cost", 12, "bcrypt cost for new password hashes")
+	flag.UintVar(&cfg.passwords.argon2Memory, "argon2-memory", 64*1024, "argon2id memory for new password hashes, in KiB")
+	flag.UintVar(&cfg.passwords.argon2Iterations, "argon2-iterations", 3, "argon2id iterations for new password hashes")
+	flag.UintVar(&cfg.passwords.argon2Parallelism, "argon2-parallelism", 2, "argon2id parallelism for new password hashes")
+
+	flag.DurationVar(&cfg.cleanup.interval, "cleanup-interval", time.Hour, "How often to delete expired tokens and other stale records (0 to disable)")
+	flag.IntVar(&cfg.cleanup.unactivatedDays, "cleanup-unactivated-days", 0, "Delete users who haven't activated their account this many days after registering (0 to disable)")
+
+	flag.DurationVar(&cfg.webhooks.pollInterval, "webhook-poll-interval", 5*time.Second, "How often to look for webhook deliveries to send (0 to disable)")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "How long to wait for a webhook receiver to respond")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 10, "Attempts to make at a webhook delivery before giving up on it")
+	flag.DurationVar(&cfg.webhooks.retention, "webhook-retention", 30*24*time.Hour, "How long to keep the log of finished webhook deliveries (0 to keep it forever)")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL (empty to disable logging in with an identity provider)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret (empty for a public client)")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "", "OpenID Connect redirect URL, pointing at /v1/oidc/callback")
+
 	flag.Parse()
 
-	// Initialize a new logger which writes messages to the standard out stream,
-	// prefixed with the current date and time.
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	// Initialize a new jsonlog.Logger which writes any messages *at or above* the INFO
+	// severity level to the standard out stream.
+	logger := jsonlog.NewLogger(os.Stdout, jsonlog.LevelInfo)
 
 	// Call the openDB() helper function (see below) to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately.
 	db, err := openDB(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		logger.PrintFatal(err, nil)
 	}
 
 	// Defer a call to db.Close() so that the connection pool is closed before the
@@ -96,31 +211,102 @@ func main() {
 
 	// Also log a message to say that the connection pool has been successfully
 	// established.
-	logger.Printf("database connection pool established")
+	logger.PrintInfo("database connection pool established", nil)
+
+	models := data.NewModels(db)
+
+	// Optionally keep each user's permissions in memory for a short while, so that
+	// requirePermission() doesn't need to query the database on every request.
+	if cfg.permissions.cacheTTL > 0 {
+		models.Permissions.Cache = data.NewPermissionCache(cfg.permissions.cacheTTL)
+	}
+
+	// The "cleanup" command runs the janitor once and exits, instead of starting the
+	// server. It's meant for running from cron, with -cleanup-interval=0 on the server.
+	switch flag.Arg(0) {
+	case "":
+	case "cleanup":
+		app := &application{config: cfg, logger: logger, models: models}
+		err = app.cleanup()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown command %q", flag.Arg(0)), nil)
+	}
+
+	var jwtSigner jwt.Signer
+	switch cfg.auth.mode {
+	case authModeOpaque:
+	case authModeJWT:
+		jwtSigner, err = newJWTSigner(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("unsupported auth-mode %q", cfg.auth.mode), nil)
+	}
+
+	// Existing hashes made with another algorithm or other parameters are upgraded to
+	// this hasher as their users log in.
+	data.DefaultPasswordHasher, err = newPasswordHasher(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	passwordPolicy := &data.PasswordPolicy{MinScore: cfg.passwords.minScore}
+	if cfg.passwords.breachedDir != "" {
+		passwordPolicy.Breached, err = data.LoadBreachedPasswords(cfg.passwords.breachedDir)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("breached password list opened", nil)
+	}
+
+	var oidcProvider *oidc.Provider
+	if cfg.oidc.issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		oidcProvider, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		})
+		cancel()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
 
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
-	}
-
-	// Declare a HTTP server with some sensible timeout settings, which listens on the
-	// port provided in the config struct and uses the servemux we created above as the
-	// handler.
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}
-
-	// Start the HTTP server.
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	// Because the err variable is now already declared in the code above, we need
-	// to use the = operator here, instead of the := operator.
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
+		models: models,
+		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		jwtSigner:      jwtSigner,
+		passwordPolicy: passwordPolicy,
+		oidc:           oidcProvider,
+		webhookSender: &webhook.Sender{
+			Client: &http.Client{
+				Timeout:   cfg.webhooks.timeout,
+				Transport: webhook.NewTransport(),
+				// A redirect is treated as a failed delivery rather than followed, so
+				// that the signed payload only ever goes to the registered URL.
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+			UserAgent: "goproject-webhooks/" + version,
+		},
+	}
+
+	// Call app.serve() to start the server, which runs until it's shut down.
+	err = app.serve()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 }
 
 // The openDB() function returns a sql.DB connection pool.
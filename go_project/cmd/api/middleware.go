@@ -1,19 +1,64 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
 	"goproject/internal/data"
+	"goproject/internal/jwt"
 	"goproject/internal/validator"
 )
 
+// requestIDRX matches the request IDs which we'll accept from clients. Anything else is
+// replaced, so that the IDs are always safe to log and to echo back in a header.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// The requestID() middleware gives every request an ID, which is sent back in the
+// X-Request-ID response header and recorded in the audit trail against any changes that
+// the request makes. If the client (or a proxy in front of us) already sent a sensible
+// X-Request-ID header we use that, so that the ID can be followed from end to end.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any caches
 		// that the response may vary based on the value of the Authorization header in the request.
 		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// Machine clients authenticate with an API key instead of a token, sent either
+		// in its own header or as "Authorization: ApiKey <key>".
+		if key := app.readAPIKey(r); key != "" {
+			app.authenticateAPIKey(w, r, next, key)
+			return
+		}
 
 		// Retrieve the value of the Authorization header from teh request. This will return the
 		// empty string "" if there is no such header found.
@@ -41,6 +86,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication toekn from the header parts
 		token := headerParts[1]
 
+		// JWT access tokens are checked locally, without a database lookup. Anything
+		// that doesn't look like a JWT carries on down the opaque-token path below, so
+		// existing opaque tokens keep working in jwt mode. A disabled user can't refresh
+		// their session, so any JWT that they still hold stops working once it expires.
+		if jwt.LooksLikeJWT(token) {
+			user, sessionID, err := app.userFromJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetSessionID(r, sessionID)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -52,7 +114,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Retrieve the details of the user associated with the authentication token.
-		// call invalidAuthenticationTokenResponse if no matching record was found.
+		// call invalidAuthenticationTokenResponse if no matching record was found. Only
+		// tokens with the authentication scope match, so a 2fa-pending token (or an
+		// activation, password reset or refresh token) can't be used to make requests.
 		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
@@ -67,6 +131,20 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Call the contextSetUser healer to add the user information to the request context.
 		r = app.contextSetUser(r, user)
 
+		// Keep the token's hash in the context too, so that handlers can tell which of
+		// the user's sessions this request belongs to, and record that the session has
+		// been used. The update doesn't affect the response, so it's made in the
+		// background.
+		tokenHash := sha256.Sum256([]byte(token))
+		r = app.contextSetTokenHash(r, tokenHash[:])
+
+		app.background(func() {
+			err := app.models.Tokens.Touch(tokenHash[:], time.Minute)
+			if err != nil {
+				app.logger.PrintError(fmt.Errorf("failed to record token use for user %d: %w", user.ID, err), nil)
+			}
+		})
+
 		// Call next handler in chain
 		next.ServeHTTP(w, r)
 	})
@@ -100,13 +178,29 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 }
 	
 
+// The rejectAPIKey() middleware refuses requests made with an API key. It guards the
+// routes which manage a user's credentials (their password, email address, sessions,
+// two-factor authentication and API keys themselves), so that a leaked key, which may
+// only have been meant for reading artifacts, can't be used to take over the account.
+func (app *application) rejectAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
 
-		// Get the slice of permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Get the slice of permissions for the user. These are stored in the request
+		// context, so any further checks made by the handler don't need to load them
+		// again.
+		r, permissions, err := app.loadPermissions(r, user)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -125,4 +219,176 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 
 	// Wrap this with the requireActivatedUser middleware before returning
 	return app.requireActivatedUser(fn)
-}
\ No newline at end of file
+}
+
+// The loadPermissions() helper returns the permissions for the user making the request.
+// If they are already in the request context they are returned from there; otherwise
+// they are loaded from the PermissionModel (which may answer from its cache) and a copy
+// of the request with the permissions added to its context is returned.
+func (app *application) loadPermissions(r *http.Request, user *data.User) (*http.Request, data.Permissions, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return r, permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return r, nil, err
+	}
+	// A request made with an API key only gets the permissions listed on the key, and
+	// only while the user still has them.
+	if key := app.contextGetAPIKey(r); key != nil {
+		permissions = permissions.Intersect(key.Permissions)
+	}
+	// Store an empty (rather than nil) slice for a user without any permissions, so
+	// that contextGetPermissions() can tell it apart from "not loaded yet".
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	return app.contextSetPermissions(r, permissions), permissions, nil
+}
+
+// idempotencyResponseRecorder wraps a http.ResponseWriter and keeps a copy of the
+// status code and body that are written through it, so that the response can be stored
+// against an Idempotency-Key.
+type idempotencyResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyResponseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyResponseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes a POST handler safe to retry. If the client sends an Idempotency-Key
+// header, the first request with that key is processed normally and its response is
+// stored. Retries with the same key and the same request body get the stored response
+// replayed instead of being processed again, while reusing the key for a different
+// request body is rejected. Requests without the header are passed straight through.
+//
+// Keys are scoped to the user, so anonymous requests are passed straight through too:
+// otherwise every anonymous client would share the same keys, and could replay or block
+// each other's requests. Responses marked "Cache-Control: no-store" (such as ones which
+// contain a secret) are never stored either. Between them, those two rules are why
+// registering a user isn't wrapped: it's anonymous, and its response carries the new
+// user's activation token. Retrying a registration is safe anyway, as the retry is
+// refused because the email address is already taken.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || app.contextGetUser(r).IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+
+		// Read the whole body so that we can hash it, then put it back for the handler.
+		// The 1MB limit is the same one that readJSON() applies.
+		r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("body must not be larger than 1048576 bytes"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestHash := idempotencyRequestHash(r, body)
+
+		// Keys are scoped to the user, so two users can't collide or read each other's
+		// responses.
+		user := app.contextGetUser(r)
+
+		existing, err := app.models.IdempotencyKeys.Reserve(user.ID, key, requestHash, app.config.idempotency.ttl)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case !bytes.Equal(existing.RequestHash, requestHash):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case existing.Status == 0:
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				for name, values := range existing.Headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// Unless the response ends up stored against the key, let the key go again once
+		// the request is over, so that the client can retry with it rather than being
+		// told that it's in use until it expires. This is deferred so that it also
+		// happens if the handler panics.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			err := app.models.IdempotencyKeys.Release(user.ID, key)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		rec := &idempotencyResponseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// Don't hold on to server errors: the request didn't succeed, so the client
+		// should be able to retry it with the same key. Responses which mustn't be
+		// stored are let go in the same way.
+		noStore := strings.Contains(w.Header().Get("Cache-Control"), "no-store")
+		if rec.status == 0 || rec.status >= 500 || noStore {
+			return
+		}
+
+		headers := make(map[string][]string)
+		for _, name := range []string{"Content-Type", "Location"} {
+			if values := w.Header().Values(name); len(values) > 0 {
+				headers[name] = values
+			}
+		}
+
+		err = app.models.IdempotencyKeys.Complete(&data.IdempotencyKey{
+			UserID:  user.ID,
+			Key:     key,
+			Status:  rec.status,
+			Headers: headers,
+			Body:    rec.body.Bytes(),
+		})
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// idempotencyRequestHash returns the hash that identifies a request sent with an
+// Idempotency-Key. The hash covers the method and path as well as the body, so that the
+// same key can't be replayed against a different endpoint.
+func idempotencyRequestHash(r *http.Request, body []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hash.Sum(nil)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+)
+
+func TestRejectAPIKey(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	tests := []struct {
+		name string
+		key  *data.APIKey
+		want int
+	}{
+		{"session", nil, http.StatusNoContent},
+		{"api key", &data.APIKey{ID: 1, Permissions: data.Permissions{"artifacts:read"}}, http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/me/password", nil)
+			r = app.contextSetUser(r, testOwner)
+			if tc.key != nil {
+				r = app.contextSetAPIKey(r, tc.key)
+			}
+
+			rr := httptest.NewRecorder()
+			app.requireAuthenticatedUser(app.rejectAPIKey(next))(rr, r)
+
+			if rr.Code != tc.want {
+				t.Errorf("got status %d; want %d", rr.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	app := newTestDBApplication(t)
+	app.config.idempotency.ttl = time.Hour
+	user := app.newTestUser(t)
+
+	// The handler creates a "thing" numbered by how many times it has been called,
+	// unless it's told to fail.
+	calls := 0
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		switch string(body) {
+		case "fail":
+			app.serverErrorResponse(w, r, errors.New("failed"))
+		case "panic":
+			panic("handler panicked")
+		default:
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "thing %d", calls)
+		}
+	})
+
+	key := fmt.Sprintf("key-%d", time.Now().UnixNano())
+	send := func(key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return app.serveTest(t, handler, testRequest{
+			method: http.MethodPost,
+			target: "/v1/things",
+			body:   body,
+			header: http.Header{"Idempotency-Key": {key}},
+			user:   user,
+		})
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		first := send(key, "a")
+		second := send(key, "a")
+		if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+			t.Fatalf("got statuses %d and %d; want %d", first.Code, second.Code, http.StatusCreated)
+		}
+		if second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("got %q; want %q replayed", second.Body, first.Body)
+		}
+		if calls != 1 {
+			t.Errorf("handler was called %d times; want once", calls)
+		}
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		if rr := send(key, "b"); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("in use", func(t *testing.T) {
+		// Reserve the key as if another request with it was still being handled.
+		inUse := key + "-in-use"
+		r := httptest.NewRequest(http.MethodPost, "/v1/things", nil)
+		_, err := app.models.IdempotencyKeys.Reserve(user.ID, inUse, idempotencyRequestHash(r, []byte("a")), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { app.models.IdempotencyKeys.Release(user.ID, inUse) })
+
+		if rr := send(inUse, "a"); rr.Code != http.StatusConflict {
+			t.Errorf("got status %d; want %d", rr.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("released on server error", func(t *testing.T) {
+		failed := key + "-failed"
+		if rr := send(failed, "fail"); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+		}
+		before := calls
+		send(failed, "fail")
+		if calls != before+1 {
+			t.Error("retrying after a server error didn't call the handler again")
+		}
+	})
+
+	t.Run("released on panic", func(t *testing.T) {
+		panicked := key + "-panicked"
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("handler didn't panic")
+				}
+			}()
+			send(panicked, "panic")
+		}()
+
+		before := calls
+		func() {
+			defer func() { recover() }()
+			if rr := send(panicked, "panic"); rr.Code == http.StatusConflict {
+				t.Error("key is still in use after the handler panicked")
+			}
+		}()
+		if calls != before+1 {
+			t.Error("retrying after a panic didn't call the handler again")
+		}
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		before := calls
+		for i := 0; i < 2; i++ {
+			app.serveTest(t, handler, testRequest{
+				method: http.MethodPost,
+				target: "/v1/things",
+				body:   "a",
+				header: http.Header{"Idempotency-Key": {key}},
+				user:   data.AnonymousUser,
+			})
+		}
+		if calls != before+2 {
+			t.Errorf("handler was called %d times for anonymous requests; want twice", calls-before)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/oidc"
+	"goproject/internal/validator"
+)
+
+// oidcLoginTTL is how long a user has to log in at the identity provider and come back
+// to the callback.
+const oidcLoginTTL = 10 * time.Minute
+
+// The oidcLoginHandler() starts a login with the identity provider. It remembers a
+// random state, nonce and PKCE code verifier, and returns the URL that the client should
+// send the user to.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	loginState := &data.OIDCLoginState{Expiry: time.Now().Add(oidcLoginTTL)}
+
+	for _, value := range []*string{&loginState.State, &loginState.Nonce, &loginState.CodeVerifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		*value = random
+	}
+
+	err := app.models.OIDCStates.Insert(loginState)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authURL := app.oidc.AuthCodeURL(loginState.State, loginState.Nonce, loginState.CodeVerifier)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The oidcCallbackHandler() finishes a login with the identity provider. It swaps the
+// authorization code for an ID token, finds (or creates) the matching user, and then
+// issues our own authentication tokens exactly like a password login does.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// The provider reports problems (such as the user refusing consent) with an error
+	// parameter instead of a code.
+	if providerError := qs.Get("error"); providerError != "" {
+		app.errorResponse(w, r, http.StatusUnauthorized, "the identity provider refused the login: "+providerError)
+		return
+	}
+
+	v := validator.New()
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loginState, err := app.models.OIDCStates.Consume(state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		app.logError(r, err)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, ok := app.userForOIDCClaims(w, r, claims)
+	if !ok {
+		return
+	}
+	if user.Disabled {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	// Logging in through the identity provider doesn't get around our own two-factor
+	// authentication.
+	twoFactor, err := app.models.TwoFactor.Enabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor {
+		app.createTwoFactorPendingToken(w, r, user)
+		return
+	}
+
+	env, err := app.issueAuthentication(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The userForOIDCClaims() helper finds the user for an identity at the provider. An
+// identity that we've seen before maps straight to its user. Otherwise, as long as the
+// provider has verified the email address, the identity is linked to the existing user
+// with that address, or to a new activated user with the same default role as a normal
+// registration. If that isn't possible it sends the response itself and returns false.
+//
+// An existing user is only linked once they've activated their account. Anyone can
+// register an account with someone else's email address without activating it, so
+// linking one would give whoever chose its password a way into the account that the
+// address's real owner goes on to use through the identity provider.
+func (app *application) userForOIDCClaims(w http.ResponseWriter, r *http.Request, claims *oidc.Claims) (*data.User, bool) {
+	user, err := app.models.Identities.GetUser(app.oidc.Issuer(), claims.Subject)
+	switch {
+	case err == nil:
+		return user, true
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	// Without a verified email address we can't tell whether the identity belongs to an
+	// existing user, so we don't link or create anything.
+	v := validator.New()
+	v.Check(claims.Email != "" && claims.EmailVerified, "email", "the identity provider must supply a verified email address")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.provisionOIDCUser(r, claims)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateEmail):
+				v.AddError("email", "a user with this email address already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, errInvalidOIDCUser):
+				v.AddError("email", "the identity provider supplied details which aren't valid for a user")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return nil, false
+		}
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	case !user.Activated:
+		v.AddError("email", "a user with this email address already exists but hasn't been activated; activate it before logging in with the identity provider")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	err = app.models.Identities.Link(app.oidc.Issuer(), claims.Subject, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+var errInvalidOIDCUser = errors.New("invalid user details from identity provider")
+
+// The provisionOIDCUser() helper creates an activated user for a new identity. The user
+// gets a random password which nobody knows; they can set a real one through the
+// password reset flow if they ever want to log in without the identity provider.
+func (app *application) provisionOIDCUser(r *http.Request, claims *oidc.Claims) (*data.User, error) {
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, errInvalidOIDCUser
+	}
+
+	err = app.insertUser(r, user, "viewer")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
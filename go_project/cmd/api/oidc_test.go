@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/oidc"
+	"goproject/internal/oidc/oidctest"
+)
+
+// oidcTest is a login through a stub identity provider, against an application backed
+// by the test database.
+type oidcTest struct {
+	app     *application
+	issuer  *oidctest.Issuer
+	subject string
+	email   string
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	app := newTestDBApplication(t)
+
+	issuer, err := oidctest.NewIssuer("test-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	app.oidc, err = oidc.Discover(context.Background(), issuer.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nanos := time.Now().UnixNano()
+	subject := fmt.Sprintf("subject-%d", nanos)
+	email := fmt.Sprintf("oidc-%d@example.com", nanos)
+	t.Cleanup(func() {
+		user, err := app.models.Users.GetByEmail(email)
+		if err == nil {
+			app.models.Users.Delete(user.ID)
+		}
+	})
+
+	return &oidcTest{app: app, issuer: issuer, subject: subject, email: email}
+}
+
+// callback starts a login, has the identity provider issue an ID token with the given
+// claims (signed with the given key), and then sends the request that the provider
+// would redirect the user back with.
+func (ot *oidcTest) callback(t *testing.T, kid string, change func(claims *oidc.Claims)) (int, string) {
+	t.Helper()
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ot.app.models.OIDCStates.Insert(&data.OIDCLoginState{
+		State:        state,
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		Expiry:       time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := ot.issuer.Claims(ot.subject, ot.email, "nonce")
+	if change != nil {
+		change(claims)
+	}
+
+	idToken, err := ot.issuer.Sign(kid, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ot.issuer.Issue(state, idToken)
+
+	rr := ot.app.serveTest(t, ot.app.oidcCallbackHandler, testRequest{
+		method: http.MethodGet,
+		target: "/v1/oidc/callback?" + url.Values{"code": {state}, "state": {state}}.Encode(),
+		user:   data.AnonymousUser,
+	})
+	return rr.Code, rr.Body.String()
+}
+
+func TestOIDCCallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		kid    string
+		change func(claims *oidc.Claims)
+		want   int
+	}{
+		{"valid", oidctest.KeyID, nil, http.StatusCreated},
+		{"bad nonce", oidctest.KeyID, func(claims *oidc.Claims) { claims.Nonce = "another nonce" }, http.StatusUnauthorized},
+		{"wrong audience", oidctest.KeyID, func(claims *oidc.Claims) { claims.Audience = []string{"another-client"} }, http.StatusUnauthorized},
+		{"unknown key", "another-key", nil, http.StatusUnauthorized},
+		{"unverified email", oidctest.KeyID, func(claims *oidc.Claims) { claims.EmailVerified = false }, http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ot := newOIDCTest(t)
+
+			status, body := ot.callback(t, tc.kid, tc.change)
+			if status != tc.want {
+				t.Fatalf("got status %d; want %d: %s", status, tc.want, body)
+			}
+
+			// Only the valid login creates a user.
+			_, err := ot.app.models.Users.GetByEmail(ot.email)
+			if created := err == nil; created != (tc.want == http.StatusCreated) {
+				t.Errorf("user created: %t (%v)", created, err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRefusesUnactivatedUser(t *testing.T) {
+	ot := newOIDCTest(t)
+
+	// Someone registers the address first, without being able to activate it.
+	user := &data.User{Name: "Mallory", Email: ot.email}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ot.app.models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := ot.callback(t, oidctest.KeyID, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d: %s", status, http.StatusUnprocessableEntity, body)
+	}
+
+	_, err := ot.app.models.Identities.GetUser(ot.issuer.URL, ot.subject)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("identity was linked: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"goproject/internal/data"
+)
+
+// writeAccess decides whether a user may write expeditions and artifacts belonging to
+// particular researchers. A user can always write the records of the researcher
+// profile that they're linked to; writing anyone else's needs the elevated
+// "<resource>:write_any" permission.
+type writeAccess struct {
+	user        *data.User
+	permissions data.Permissions
+}
+
+// allows reports whether the records of every one of the given researchers can be
+// written. resource is the permission prefix, such as "artifacts".
+func (a writeAccess) allows(resource string, researcherIDs ...int) bool {
+	if a.permissions.Include(resource + ":write_any") {
+		return true
+	}
+
+	for _, id := range researcherIDs {
+		if !a.user.OwnsResearcher(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// The requireOwnership() helper checks that the current user may write records
+// belonging to the given researchers, for use in handlers once they know which
+// researchers a write affects. If not, it sends a 403 Forbidden response itself and
+// returns false, in which case the handler should simply return.
+func (app *application) requireOwnership(w http.ResponseWriter, r *http.Request, resource string, researcherIDs ...int) bool {
+	user := app.contextGetUser(r)
+
+	r, permissions, err := app.loadPermissions(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !(writeAccess{user: user, permissions: permissions}).allows(resource, researcherIDs...) {
+		app.notOwnerResponse(w, r)
+		return false
+	}
+	return true
+}
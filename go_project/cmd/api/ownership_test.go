@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"goproject/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The users that the ownership tests make requests as. Every record in the mocks
+// belongs to researcher 1, unless a test moves it.
+var (
+	testOwner = &data.User{ID: 1, Activated: true, ResearcherID: int64Ptr(1)}
+	testOther = &data.User{ID: 2, Activated: true, ResearcherID: int64Ptr(2)}
+	testAdmin = &data.User{ID: 3, Activated: true}
+
+	writePermissions    = data.Permissions{"artifacts:read", "artifacts:write", "expeditions:read", "expeditions:write"}
+	writeAnyPermissions = data.Permissions{"artifacts:read", "artifacts:write", "artifacts:write_any", "expeditions:read", "expeditions:write", "expeditions:write_any"}
+)
+
+// ownershipCase is a write route together with the status that each kind of user should
+// get back from it.
+type ownershipCase struct {
+	name        string
+	handler     func(app *application) http.HandlerFunc
+	method      string
+	target      string
+	body        string
+	contentType string
+	params      httprouter.Params
+	owner       int
+	other       int
+	writeAny    int
+}
+
+func TestRequireOwnership(t *testing.T) {
+	artifact := `{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}`
+	movedArtifact := `{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 2}`
+	expedition := `{"title": "Phaistos", "expeditionYear": 1908, "researcher_id": 1}`
+	movedExpedition := `{"title": "Phaistos", "expeditionYear": 1908, "researcher_id": 2}`
+	id := httprouter.Params{{Key: "id", Value: "1"}}
+
+	tests := []ownershipCase{
+		{
+			name:    "create artifact",
+			handler: func(app *application) http.HandlerFunc { return app.createArtifactHandler },
+			method:  http.MethodPost, target: "/v1/artifacts", body: artifact,
+			owner: http.StatusCreated, other: http.StatusForbidden, writeAny: http.StatusCreated,
+		},
+		{
+			name:    "update artifact",
+			handler: func(app *application) http.HandlerFunc { return app.updateArtifactHandler },
+			method:  http.MethodPut, target: "/v1/artifacts/1", body: artifact, params: id,
+			owner: http.StatusOK, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			name:    "move artifact to another researcher",
+			handler: func(app *application) http.HandlerFunc { return app.updateArtifactHandler },
+			method:  http.MethodPut, target: "/v1/artifacts/1", body: movedArtifact, params: id,
+			owner: http.StatusForbidden, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			name:    "delete artifact",
+			handler: func(app *application) http.HandlerFunc { return app.deleteArtifactHandler },
+			method:  http.MethodDelete, target: "/v1/artifacts/1", params: id,
+			owner: http.StatusOK, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			name:    "import artifacts",
+			handler: func(app *application) http.HandlerFunc { return app.importArtifactsHandler },
+			method:  http.MethodPost, target: "/v1/artifacts/import", body: "[" + artifact + "]",
+			owner: http.StatusCreated, other: http.StatusUnprocessableEntity, writeAny: http.StatusCreated,
+		},
+		{
+			name:    "import artifacts from CSV",
+			handler: func(app *application) http.HandlerFunc { return app.importArtifactsHandler },
+			method:  http.MethodPost, target: "/v1/artifacts/import", contentType: "text/csv",
+			body:  "title,age,location,researcher_id\nSnake goddess,3600,Crete,1\n",
+			owner: http.StatusCreated, other: http.StatusUnprocessableEntity, writeAny: http.StatusCreated,
+		},
+		{
+			name:    "revert artifact",
+			handler: func(app *application) http.HandlerFunc { return app.revertArtifactHandler },
+			method:  http.MethodPut, target: "/v1/artifacts/1/revert?to_version=1", params: id,
+			owner: http.StatusOK, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			// Artifact 2 was created for researcher 2 and then moved to researcher 1, so
+			// reverting it moves it back.
+			name:    "revert artifact to another researcher",
+			handler: func(app *application) http.HandlerFunc { return app.revertArtifactHandler },
+			method:  http.MethodPut, target: "/v1/artifacts/2/revert?to_version=1",
+			params: httprouter.Params{{Key: "id", Value: "2"}},
+			owner:  http.StatusForbidden, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			// Artifact 3 has been deleted, so reverting it restores it.
+			name:    "restore deleted artifact",
+			handler: func(app *application) http.HandlerFunc { return app.revertArtifactHandler },
+			method:  http.MethodPut, target: "/v1/artifacts/3/revert?to_version=1",
+			params: httprouter.Params{{Key: "id", Value: "3"}},
+			owner:  http.StatusOK, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			name:    "create expedition",
+			handler: func(app *application) http.HandlerFunc { return app.createExpeditionHandler },
+			method:  http.MethodPost, target: "/v1/expeditions", body: expedition,
+			owner: http.StatusCreated, other: http.StatusForbidden, writeAny: http.StatusCreated,
+		},
+		{
+			name:    "update expedition",
+			handler: func(app *application) http.HandlerFunc { return app.updateExpeditionHandler },
+			method:  http.MethodPut, target: "/v1/expeditions/1", body: expedition, params: id,
+			owner: http.StatusOK, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			name:    "move expedition to another researcher",
+			handler: func(app *application) http.HandlerFunc { return app.updateExpeditionHandler },
+			method:  http.MethodPut, target: "/v1/expeditions/1", body: movedExpedition, params: id,
+			owner: http.StatusForbidden, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+		{
+			name:    "delete expedition",
+			handler: func(app *application) http.HandlerFunc { return app.deleteExpeditionHandler },
+			method:  http.MethodDelete, target: "/v1/expeditions/1", params: id,
+			owner: http.StatusOK, other: http.StatusForbidden, writeAny: http.StatusOK,
+		},
+	}
+
+	users := []struct {
+		name        string
+		user        *data.User
+		permissions data.Permissions
+		want        func(tc ownershipCase) int
+	}{
+		{"owner", testOwner, writePermissions, func(tc ownershipCase) int { return tc.owner }},
+		{"non-owner", testOther, writePermissions, func(tc ownershipCase) int { return tc.other }},
+		{"write_any", testAdmin, writeAnyPermissions, func(tc ownershipCase) int { return tc.writeAny }},
+	}
+
+	for _, tc := range tests {
+		for _, u := range users {
+			t.Run(tc.name+"/"+u.name, func(t *testing.T) {
+				app := newTestApplication(t)
+
+				artifacts := app.models.Artifacts.(*mockArtifacts)
+				moved := &data.Artifact{Title: "Linear A tablet", Age: 3700, Location: "Crete", Researcher_id: 2}
+				artifacts.Insert(moved)
+				moved.Researcher_id = 1
+				artifacts.Update(moved)
+				deleted := &data.Artifact{Title: "Phaistos disc", Age: 3700, Location: "Crete", Researcher_id: 1}
+				artifacts.Insert(deleted)
+				artifacts.Delete(int64(deleted.Id))
+
+				rr := app.serveTest(t, tc.handler(app), testRequest{
+					method:      tc.method,
+					target:      tc.target,
+					body:        tc.body,
+					contentType: tc.contentType,
+					params:      tc.params,
+					user:        u.user,
+					permissions: u.permissions,
+				})
+
+				if want := u.want(tc); rr.Code != want {
+					t.Errorf("got status %d; want %d: %s", rr.Code, want, rr.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestRequireOwnershipLeavesRecordsAlone(t *testing.T) {
+	app := newTestApplication(t)
+
+	rr := app.serveTest(t, app.deleteArtifactHandler, testRequest{
+		method:      http.MethodDelete,
+		target:      "/v1/artifacts/1",
+		params:      httprouter.Params{{Key: "id", Value: "1"}},
+		user:        testOther,
+		permissions: writePermissions,
+	})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	if _, err := app.models.Artifacts.Get(1); err != nil {
+		t.Fatalf("artifact was deleted by a non-owner: %v", err)
+	}
+}
+
+func TestBatchOwnership(t *testing.T) {
+	artifact := json.RawMessage(`{"title": "Snake goddess", "age": 3600, "location": "Crete", "researcher_id": 1}`)
+	expedition := json.RawMessage(`{"title": "Phaistos", "expeditionYear": 1908, "researcher_id": 1}`)
+
+	tests := []struct {
+		op       batchOperation
+		owner    int
+		other    int
+		writeAny int
+	}{
+		{batchOperation{Action: "create", Resource: "artifacts", Data: artifact}, http.StatusCreated, http.StatusForbidden, http.StatusCreated},
+		{batchOperation{Action: "update", Resource: "artifacts", ID: 1, Data: artifact}, http.StatusOK, http.StatusForbidden, http.StatusOK},
+		{batchOperation{Action: "delete", Resource: "artifacts", ID: 1}, http.StatusOK, http.StatusForbidden, http.StatusOK},
+		{batchOperation{Action: "create", Resource: "expeditions", Data: expedition}, http.StatusCreated, http.StatusForbidden, http.StatusCreated},
+		{batchOperation{Action: "update", Resource: "expeditions", ID: 1, Data: expedition}, http.StatusOK, http.StatusForbidden, http.StatusOK},
+		{batchOperation{Action: "delete", Resource: "expeditions", ID: 1}, http.StatusOK, http.StatusForbidden, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		for _, u := range []struct {
+			name   string
+			access writeAccess
+			want   int
+		}{
+			{"owner", writeAccess{user: testOwner, permissions: writePermissions}, tc.owner},
+			{"non-owner", writeAccess{user: testOther, permissions: writePermissions}, tc.other},
+			{"write_any", writeAccess{user: testAdmin, permissions: writeAnyPermissions}, tc.writeAny},
+		} {
+			t.Run(tc.op.Action+" "+tc.op.Resource+"/"+u.name, func(t *testing.T) {
+				app := newTestApplication(t)
+
+				result := app.applyBatchOperation(app.models, u.access, tc.op)
+				if result.Status != u.want {
+					t.Errorf("got status %d; want %d: %v", result.Status, u.want, result.Error)
+				}
+			})
+		}
+	}
+}
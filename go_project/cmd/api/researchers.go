@@ -49,7 +49,7 @@ func (app *application) createResearcherHandler(w http.ResponseWriter, r *http.R
 	// Call the Insert() method on our researchers model, passing in a pointer to the
 	// validated song struct. This will create a record in the database and update the
 	// song struct with the system-generated information.
-	err = app.models.Researchers.Insert(researcher)
+	err = app.modelsFor(r).Researchers.Insert(researcher)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -176,7 +176,7 @@ func (app *application) updateResearcherHandler(w http.ResponseWriter, r *http.R
 	}
 
 	// Pass the updated researcher record to our new Update() method.
-	err = app.models.Researchers.Update(researcher)
+	err = app.modelsFor(r).Researchers.Update(researcher)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -201,12 +201,15 @@ func (app *application) deleteResearcherHandler(w http.ResponseWriter, r *http.R
 
 	// Delete the researcher from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Researchers.Delete(id)
+	err = app.modelsFor(r).Researchers.Delete(id)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		// The researcher's expeditions and artifacts have to be deleted first.
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.recordInUseResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -257,12 +260,24 @@ func (app *application) listResearchersHandler(w http.ResponseWriter, r *http.Re
 	// input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 	input.Filters.SortSafelist = []string{"researcher_id", "name", "specialization", "project", "-researcher_id", "-name", "-specialization", "-project"}
 
+	// Work out whether the client wants the usual paginated JSON envelope or a
+	// streamed CSV/NDJSON export of every matching row.
+	format := app.readFormat(r, v)
+
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+
+	// Exports ignore the page and page_size parameters, but go through exactly the same
+	// filter and sort validation as a normal page of results.
+	if format != formatJSON {
+		app.exportResearchers(w, r, format, input.Name, input.Specialization, input.Filters)
+		return
+	}
+
 	// Call the GetAll() method to retrieve the researchers, passing in the various filter
 	// parameters.
 	// Accept the metadata struct as a return value.
@@ -25,32 +25,91 @@ func (app *application) routes() http.Handler {
 	// respectively.
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/researchers", app.requirePermission("read", app.listResearchersHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/researchers", app.requirePermission("write", app.createResearcherHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/researchers/:id", app.requirePermission("read", app.showResearcherHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/researchers/:id", app.requirePermission("write", app.updateResearcherHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/researchers/:id", app.requirePermission("write", app.deleteResearcherHandler))
-
-	router.HandlerFunc(http.MethodGet, "/v1/expeditions", app.requirePermission("read", app.listExpeditionsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/expeditions", app.requirePermission("write", app.createExpeditionHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/expeditions/:id", app.requirePermission("read", app.showExpeditionHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/expeditions/:id", app.requirePermission("write", app.updateExpeditionHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/expeditions/:id", app.requirePermission("write", app.deleteExpeditionHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/researchers/:id/expeditions", app.requirePermission("read", app.getExpeditionsByResearcherHandler))
-
-	router.HandlerFunc(http.MethodGet, "/v1/artifacts", app.requirePermission("read", app.listArtifactsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/artifacts", app.requirePermission("write", app.createArtifactHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/artifacts/:id", app.requirePermission("read", app.showArtifactHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/artifacts/:id", app.requirePermission("write", app.updateArtifactHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/artifacts/:id", app.requirePermission("write", app.deleteArtifactHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/researchers/:id/artifacts", app.requirePermission("read", app.getArtifactsByResearcherHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/researchers", app.requirePermission("researchers:read", app.listResearchersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/researchers", app.requirePermission("researchers:write", app.idempotent(app.createResearcherHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/researchers/:id", app.requirePermission("researchers:read", app.showResearcherHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/researchers/:id", app.requirePermission("researchers:write", app.updateResearcherHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/researchers/:id", app.requirePermission("researchers:write", app.deleteResearcherHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/expeditions", app.requirePermission("expeditions:read", app.listExpeditionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/expeditions", app.requirePermission("expeditions:write", app.idempotent(app.createExpeditionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/expeditions/:id", app.requirePermission("expeditions:read", app.showExpeditionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/expeditions/:id", app.requirePermission("expeditions:write", app.updateExpeditionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/expeditions/:id", app.requirePermission("expeditions:write", app.deleteExpeditionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/researchers/:id/expeditions", app.requirePermission("expeditions:read", app.getExpeditionsByResearcherHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/artifacts", app.requirePermission("artifacts:read", app.listArtifactsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/artifacts", app.requirePermission("artifacts:write", app.idempotent(app.createArtifactHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/artifacts/import", app.requirePermission("artifacts:write", app.idempotent(app.importArtifactsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/artifacts/:id", app.requirePermission("artifacts:read", app.showArtifactHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/artifacts/:id/history", app.requirePermission("artifacts:read", app.artifactHistoryHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/artifacts/:id", app.requirePermission("artifacts:write", app.updateArtifactHandler))
+	// Revert is a PUT, like deactivate and reactivate, so that no POST route needs a
+	// ":id" wildcard alongside "/v1/artifacts/import".
+	router.HandlerFunc(http.MethodPut, "/v1/artifacts/:id/revert", app.requirePermission("artifacts:write", app.revertArtifactHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/artifacts/:id", app.requirePermission("artifacts:write", app.deleteArtifactHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/researchers/:id/artifacts", app.requirePermission("artifacts:read", app.getArtifactsByResearcherHandler))
+
+	// Each operation in a batch has its permissions checked individually by the handler,
+	// so the route itself only requires an activated user.
+	router.HandlerFunc(http.MethodPost, "/v1/batch", app.requireActivatedUser(app.batchHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/stats/artifacts", app.requirePermission("artifacts:read", app.artifactStatsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/stats/expeditions", app.requirePermission("expeditions:read", app.expeditionStatsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.revokeUserRoleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/deactivate", app.requirePermission("users:admin", app.deactivateUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/reactivate", app.requirePermission("users:admin", app.reactivateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.logoutUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/researcher", app.requirePermission("users:admin", app.linkUserResearcherHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:manage", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:manage", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:manage", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries/:delivery_id", app.requirePermission("webhooks:manage", app.showWebhookDeliveryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requirePermission("webhooks:manage", app.redeliverWebhookHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission("users:admin", app.listAuditEventsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/permissions/:code", app.requirePermission("users:admin", app.updatePermissionHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	// Requests made with an API key can't change the user's credentials, see rejectAPIKey().
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.rejectAPIKey(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.rejectAPIKey(app.deleteCurrentUserHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/email", app.requireAuthenticatedUser(app.rejectAPIKey(app.updateCurrentUserEmailHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireAuthenticatedUser(app.rejectAPIKey(app.updateCurrentUserPasswordHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/researcher", app.requireActivatedUser(app.showMyResearcherHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa", app.requireActivatedUser(app.rejectAPIKey(app.enrolTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/confirm", app.requireActivatedUser(app.rejectAPIKey(app.confirmTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/recovery-codes", app.requireActivatedUser(app.rejectAPIKey(app.regenerateRecoveryCodesHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/2fa", app.requireActivatedUser(app.rejectAPIKey(app.disableTwoFactorHandler)))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/login", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", app.requireActivatedUser(app.rejectAPIKey(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", app.requireActivatedUser(app.rejectAPIKey(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requireActivatedUser(app.rejectAPIKey(app.deleteAPIKeyHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/tokens", app.requireAuthenticatedUser(app.rejectAPIKey(app.listAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.rejectAPIKey(app.deleteAuthenticationTokenHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.rejectAPIKey(app.deleteAllAuthenticationTokensHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
 	// Return the httprouter instance.
 	// return router
-	return app.authenticate(router)
+	return app.requestID(app.authenticate(router))
 
 }
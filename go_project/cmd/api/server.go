@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The serve() method runs the HTTP server, together with the janitor and the webhook
+// worker, until the process receives a SIGINT or SIGTERM signal. It then shuts down
+// gracefully: the server stops accepting new connections and waits for in-flight
+// requests, and then we wait for the janitor, the webhook worker and any background
+// tasks (such as sending emails) to finish.
+func (app *application) serve() error {
+	// Declare a HTTP server with some sensible timeout settings, which listens on the
+	// port provided in the config struct and uses the servemux we created above as the
+	// handler. Any errors that the server logs itself are written through our logger.
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		ErrorLog:     log.New(app.logger, "", 0),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	// shutdownError receives any error from the graceful shutdown, and stopWorkers is
+	// closed to tell the janitor and the webhook worker to stop.
+	shutdownError := make(chan error)
+	stopWorkers := make(chan struct{})
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.PrintInfo("shutting down server", map[string]string{
+			"signal": s.String(),
+		})
+
+		// Give in-flight requests up to 20 seconds to complete.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		close(stopWorkers)
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
+	app.startJanitor(stopWorkers)
+	app.startWebhookWorker(stopWorkers)
+
+	app.logger.PrintInfo("starting server", map[string]string{
+		"addr": srv.Addr,
+		"env":  app.config.env,
+	})
+
+	// Shutdown() makes ListenAndServe() return http.ErrServerClosed straight away, so
+	// that's the signal to wait for the shutdown to finish. Any other error means that
+	// the server couldn't start.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("stopped server", map[string]string{
+		"addr": srv.Addr,
+	})
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"goproject/internal/data"
+	"goproject/internal/validator"
+)
+
+func (app *application) artifactStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title string
+		Age   int
+		data.StatsFilters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// Read the same filters as listArtifactsHandler so that the numbers on a dashboard
+	// always match what the list endpoint would return for the same query string.
+	input.Title = app.readString(qs, "title", "")
+	input.Age = app.readInt(qs, "age", 1, v)
+
+	input.StatsFilters.GroupBy = app.readString(qs, "group_by", "")
+	input.StatsFilters.BucketSize = app.readInt(qs, "bucket_size", 100, v)
+	input.StatsFilters.GroupBySafelist = []string{"location", "researcher_id", "age_bucket"}
+
+	if data.ValidateStatsFilters(v, input.StatsFilters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.models.Artifacts.GetStats(input.Title, input.Age, input.StatsFilters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"group_by": input.StatsFilters.GroupBy, "stats": stats}, app.statsCacheHeaders())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) expeditionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title          string
+		ExpeditionYear int
+		data.StatsFilters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.ExpeditionYear = app.readInt(qs, "expeditionYear", 1, v)
+
+	input.StatsFilters.GroupBy = app.readString(qs, "group_by", "")
+	// Bucketing isn't supported for expeditions, but the validator still expects a
+	// sensible value.
+	input.StatsFilters.BucketSize = 1
+	input.StatsFilters.GroupBySafelist = []string{"year"}
+
+	if data.ValidateStatsFilters(v, input.StatsFilters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.models.Expeditions.GetStats(input.Title, input.ExpeditionYear, input.StatsFilters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"group_by": input.StatsFilters.GroupBy, "stats": stats}, app.statsCacheHeaders())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The statsCacheHeaders() helper returns the Cache-Control header for the aggregate
+// endpoints. The responses depend on the caller's permissions, so they are only ever
+// cacheable by the client itself ("private"), and only for the configured interval.
+func (app *application) statsCacheHeaders() http.Header {
+	headers := make(http.Header)
+
+	maxAge := int(app.config.stats.maxAge.Seconds())
+	if maxAge <= 0 {
+		headers.Set("Cache-Control", "no-store")
+		return headers
+	}
+
+	headers.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	return headers
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatsHandlersValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(app *application, w http.ResponseWriter, r *http.Request)
+		target  string
+		want    int
+	}{
+		{"artifacts by location", (*application).artifactStatsHandler, "/v1/stats/artifacts?group_by=location", http.StatusOK},
+		{"artifacts by age bucket", (*application).artifactStatsHandler, "/v1/stats/artifacts?group_by=age_bucket&bucket_size=50", http.StatusOK},
+		{"artifacts without group_by", (*application).artifactStatsHandler, "/v1/stats/artifacts", http.StatusUnprocessableEntity},
+		{"artifacts by title", (*application).artifactStatsHandler, "/v1/stats/artifacts?group_by=title", http.StatusUnprocessableEntity},
+		{"artifacts by year", (*application).artifactStatsHandler, "/v1/stats/artifacts?group_by=year", http.StatusUnprocessableEntity},
+		{"zero bucket size", (*application).artifactStatsHandler, "/v1/stats/artifacts?group_by=age_bucket&bucket_size=0", http.StatusUnprocessableEntity},
+		{"bucket size not a number", (*application).artifactStatsHandler, "/v1/stats/artifacts?group_by=age_bucket&bucket_size=ten", http.StatusUnprocessableEntity},
+		{"expeditions by year", (*application).expeditionStatsHandler, "/v1/stats/expeditions?group_by=year", http.StatusOK},
+		{"expeditions by location", (*application).expeditionStatsHandler, "/v1/stats/expeditions?group_by=location", http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			handler := func(w http.ResponseWriter, r *http.Request) { tc.handler(app, w, r) }
+
+			rr := app.serveTest(t, handler, testRequest{
+				method: http.MethodGet,
+				target: tc.target,
+				user:   testOwner,
+			})
+			if rr.Code != tc.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestStatsCacheHeaders(t *testing.T) {
+	app := newTestApplication(t)
+
+	if got := app.statsCacheHeaders().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("got Cache-Control %q with caching turned off; want %q", got, "no-store")
+	}
+
+	app.config.stats.maxAge = 30 * time.Second
+	if got := app.statsCacheHeaders().Get("Cache-Control"); got != "private, max-age=30" {
+		t.Errorf("got Cache-Control %q; want %q", got, "private, max-age=30")
+	}
+}
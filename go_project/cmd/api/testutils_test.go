@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/jsonlog"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// newTestApplication returns an application whose expedition and artifact models are
+// in-memory mocks, so that handlers which only use those can be tested without a
+// database.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	return &application{
+		logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelOff),
+		models: data.Models{
+			Expeditions: newMockExpeditions(),
+			Artifacts:   newMockArtifacts(),
+		},
+	}
+}
+
+// newTestDBApplication returns an application whose models use the PostgreSQL database
+// in the TEST_DB_DSN environment variable, which must have all of the migrations
+// applied. The test is skipped when it isn't set.
+func newTestDBApplication(t *testing.T) *application {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelOff),
+		models: data.NewModels(db),
+	}
+}
+
+// newTestUser inserts an activated user, whose password is testPassword, into the test
+// database. The user is deleted again when the test finishes.
+func (app *application) newTestUser(t *testing.T) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: "Test User", Email: fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()), Activated: true}
+	if err := user.Password.Set(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Users.Delete(user.ID) })
+	return user
+}
+
+const testPassword = "pa55word1234"
+
+// testRequest describes a request to send straight to a handler, as if it had already
+// been routed and authenticated: params are the URL parameters that httprouter would
+// have set, and user, session and permissions are put in the context as the
+// authenticate middleware and loadPermissions() would have put them. session is the
+// opaque authentication token that the request was made with, if any.
+type testRequest struct {
+	method      string
+	target      string
+	body        string
+	contentType string
+	header      http.Header
+	params      httprouter.Params
+	user        *data.User
+	permissions data.Permissions
+	session     *data.Token
+}
+
+// serveTest sends the request to the handler and returns the recorded response.
+func (app *application) serveTest(t *testing.T, handler http.HandlerFunc, tr testRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(tr.method, tr.target, strings.NewReader(tr.body))
+	if tr.contentType != "" {
+		r.Header.Set("Content-Type", tr.contentType)
+	}
+	for name, values := range tr.header {
+		r.Header[name] = values
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, tr.params))
+	r = app.contextSetUser(r, tr.user)
+	if tr.session != nil {
+		r = app.contextSetTokenHash(r, tr.session.Hash)
+	}
+	if tr.permissions != nil {
+		r = app.contextSetPermissions(r, tr.permissions)
+	}
+
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+	return rr
+}
+
+// decodeResponse decodes a JSON response body into dst.
+func decodeResponse(t *testing.T, rr *httptest.ResponseRecorder, dst interface{}) {
+	t.Helper()
+
+	err := json.NewDecoder(rr.Body).Decode(dst)
+	if err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+// mockExpeditions keeps expeditions in a map, keyed by ID.
+type mockExpeditions struct {
+	expeditions map[int64]*data.Expedition
+	nextID      int
+}
+
+func newMockExpeditions() *mockExpeditions {
+	return &mockExpeditions{
+		expeditions: map[int64]*data.Expedition{
+			1: {Id: 1, Title: "Knossos", ExpeditionYear: 1900, Researcher_id: 1},
+		},
+		nextID: 2,
+	}
+}
+
+func (m *mockExpeditions) Insert(expedition *data.Expedition) error {
+	expedition.Id = m.nextID
+	m.nextID++
+	m.expeditions[int64(expedition.Id)] = expedition
+	return nil
+}
+
+func (m *mockExpeditions) Get(id int64) (*data.Expedition, error) {
+	expedition, ok := m.expeditions[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	copied := *expedition
+	return &copied, nil
+}
+
+func (m *mockExpeditions) GetAll(title string, expeditionYear int, filters data.Filters) ([]*data.Expedition, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+func (m *mockExpeditions) StreamAll(title string, expeditionYear int, filters data.Filters, fn func(expedition *data.Expedition) error) error {
+	return nil
+}
+
+func (m *mockExpeditions) Update(expedition *data.Expedition) error {
+	if _, ok := m.expeditions[int64(expedition.Id)]; !ok {
+		return data.ErrEditConflict
+	}
+	m.expeditions[int64(expedition.Id)] = expedition
+	return nil
+}
+
+func (m *mockExpeditions) Delete(id int64) error {
+	if _, ok := m.expeditions[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+	delete(m.expeditions, id)
+	return nil
+}
+
+func (m *mockExpeditions) GetExpeditionsByResearcher(researcher_id int64, title string, expeditionYear int, filters data.Filters) ([]*data.Expedition, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+func (m *mockExpeditions) GetStats(title string, expeditionYear int, filters data.StatsFilters) ([]*data.Stat, error) {
+	return nil, nil
+}
+
+// mockArtifacts keeps artifacts in a map, keyed by ID, together with every version of
+// each one (including the tombstones of deleted artifacts).
+type mockArtifacts struct {
+	artifacts map[int64]*data.Artifact
+	versions  map[int64][]data.ArtifactVersion
+	nextID    int
+}
+
+func newMockArtifacts() *mockArtifacts {
+	m := &mockArtifacts{
+		artifacts: make(map[int64]*data.Artifact),
+		versions:  make(map[int64][]data.ArtifactVersion),
+		nextID:    1,
+	}
+	m.Insert(&data.Artifact{Title: "Bull-leaping fresco", Age: 3500, Location: "Crete", Researcher_id: 1})
+	return m
+}
+
+func (m *mockArtifacts) Insert(artifact *data.Artifact) error {
+	artifact.Id = m.nextID
+	artifact.Version = 1
+	m.nextID++
+	m.artifacts[int64(artifact.Id)] = artifact
+	m.versions[int64(artifact.Id)] = []data.ArtifactVersion{{Artifact: *artifact, CreatedAt: time.Now()}}
+	return nil
+}
+
+func (m *mockArtifacts) InsertMany(artifacts []*data.Artifact) error {
+	for _, artifact := range artifacts {
+		m.Insert(artifact)
+	}
+	return nil
+}
+
+func (m *mockArtifacts) Get(id int64) (*data.Artifact, error) {
+	artifact, ok := m.artifacts[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	copied := *artifact
+	return &copied, nil
+}
+
+func (m *mockArtifacts) GetAll(title string, age int, filters data.Filters) ([]*data.Artifact, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+// StreamAll calls fn for every artifact in ID order, ignoring the filters.
+func (m *mockArtifacts) StreamAll(title string, age int, filters data.Filters, fn func(artifact *data.Artifact) error) error {
+	for id := int64(1); id < int64(m.nextID); id++ {
+		if artifact, ok := m.artifacts[id]; ok {
+			if err := fn(artifact); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mockArtifacts) Update(artifact *data.Artifact) error {
+	existing, ok := m.artifacts[int64(artifact.Id)]
+	if !ok || existing.Version != artifact.Version {
+		return data.ErrEditConflict
+	}
+	artifact.Version++
+	m.artifacts[int64(artifact.Id)] = artifact
+	m.versions[int64(artifact.Id)] = append(m.versions[int64(artifact.Id)], data.ArtifactVersion{Artifact: *artifact, CreatedAt: time.Now()})
+	return nil
+}
+
+func (m *mockArtifacts) Delete(id int64) error {
+	artifact, ok := m.artifacts[id]
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+	tombstone := *artifact
+	tombstone.Version++
+	m.versions[id] = append(m.versions[id], data.ArtifactVersion{Artifact: tombstone, Deleted: true, CreatedAt: time.Now()})
+	delete(m.artifacts, id)
+	return nil
+}
+
+func (m *mockArtifacts) Restore(artifact *data.Artifact) error {
+	versions := m.versions[int64(artifact.Id)]
+	if len(versions) == 0 || !versions[len(versions)-1].Deleted || versions[len(versions)-1].Artifact.Version != artifact.Version {
+		return data.ErrEditConflict
+	}
+	artifact.Version++
+	m.artifacts[int64(artifact.Id)] = artifact
+	m.versions[int64(artifact.Id)] = append(versions, data.ArtifactVersion{Artifact: *artifact, CreatedAt: time.Now()})
+	return nil
+}
+
+func (m *mockArtifacts) GetArtifactsByResearcher(researcher_id int64, title string, age int, filters data.Filters) ([]*data.Artifact, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+func (m *mockArtifacts) GetStats(title string, age int, filters data.StatsFilters) ([]*data.Stat, error) {
+	return nil, nil
+}
+
+func (m *mockArtifacts) GetHistory(id int64, filters data.Filters) ([]*data.ArtifactVersion, data.Metadata, error) {
+	return nil, data.Metadata{}, nil
+}
+
+func (m *mockArtifacts) GetVersion(id int64, version int) (*data.ArtifactVersion, error) {
+	versions := m.versions[id]
+	if version < 1 || version > len(versions) {
+		return nil, data.ErrRecordNotFound
+	}
+	copied := versions[version-1]
+	return &copied, nil
+}
+
+func (m *mockArtifacts) GetLatestVersion(id int64) (*data.ArtifactVersion, error) {
+	return m.GetVersion(id, len(m.versions[id]))
+}
+
+func (m *mockArtifacts) GetAsOf(id int64, t time.Time) (*data.ArtifactVersion, error) {
+	return nil, data.ErrRecordNotFound
+}
@@ -1,6 +1,8 @@
 package main
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 	"goproject/internal/data"
@@ -25,13 +27,22 @@ var input struct {
 	app.failedValidationResponse(w, r, v.Errors)
 	return
 	}
+	// Refuse to check the password at all if there have been too many recent failed
+	// logins for this email address or from this IP address.
+	attempt := app.newLoginAttempt(r, input.Email)
+	if !app.checkLoginThrottle(w, r, attempt) {
+	return
+	}
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
+	// We still compare the password against a dummy hash first, so that the response
+	// takes just as long as it would for a registered email address.
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 	switch {
 	case errors.Is(err, data.ErrRecordNotFound):
+	data.CompareDummyPassword(input.Password)
 	app.invalidCredentialsResponse(w, r)
 	default:
 	app.serverErrorResponse(w, r, err)
@@ -50,17 +61,341 @@ var input struct {
 	app.invalidCredentialsResponse(w, r)
 	return
 	}
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// A disabled account can't log in at all. This is only said once the password has
+	// been checked, so that it doesn't give away anything to someone guessing.
+	if user.Disabled {
+	app.accountDisabledResponse(w, r)
+	return
+	}
+	// Now that we have the right plaintext password, upgrade the stored hash if it was
+	// made with an older algorithm or cost.
+	app.rehashPassword(r, user, input.Password)
+	// Users with two-factor authentication must also send a code from their
+	// authenticator app to POST /v1/tokens/2fa. For now they only get a short-lived
+	// token which proves that the password was right. Only this attempt is taken
+	// back: earlier failures aren't forgotten until the code has been checked too, so
+	// that guessing codes is throttled in the same way as guessing passwords.
+	twoFactor, err := app.models.TwoFactor.Enabled(user.ID)
+	if err != nil {
+	app.serverErrorResponse(w, r, err)
+	return
+	}
+	if twoFactor {
+	app.refundLoginAttempt(r, attempt)
+	app.createTwoFactorPendingToken(w, r, user)
+	return
+	}
+	// The login worked, so forget about any earlier failures.
+	app.resetLoginFailures(r, attempt)
+	// Otherwise, if the password is correct, we issue the tokens for the configured
+	// auth mode: an opaque 'authentication' token with a 24-hour expiry time, or a
+	// JWT together with a refresh token.
+	env, err := app.issueAuthentication(r, user)
 	if err != nil {
 	app.serverErrorResponse(w, r, err)
 	return
 	}
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
 	if err != nil {
 	app.serverErrorResponse(w, r, err)
 	}
-	}
\ No newline at end of file
+	}
+
+// The createPasswordResetTokenHandler() emails a password reset token to the user with
+// the given email address. The response is the same whether or not such a user exists,
+// so that this endpoint can't be used to find out which addresses are registered, and
+// the token itself is only ever sent by email. Looking up the user and making the token
+// both happen in the background after the response has been sent, so that how long the
+// response takes doesn't give the answer away either.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.background(func() {
+		err := app.sendPasswordResetToken(input.Email)
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("failed to send password reset token: %w", err), nil)
+		}
+	})
+
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The sendPasswordResetToken() helper makes a new password reset token for the user
+// with the given email address and emails it to them. Nothing is sent if there's no
+// such user, or if their account has been disabled.
+func (app *application) sendPasswordResetToken(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if user.Disabled {
+		return nil
+	}
+
+	// Only the most recently requested token should work, so throw away any older ones.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	templateData := map[string]interface{}{
+		"passwordResetToken": token.Plaintext,
+	}
+
+	err = app.mailer.Send(user.Email, "token_password_reset.tmpl", templateData)
+	if err != nil {
+		return fmt.Errorf("user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+// The createActivationTokenHandler() emails a fresh activation token to a user who
+// hasn't activated their account yet, for when the original token has expired or been
+// lost. As with password resets, the response never says whether the email address
+// belongs to an account, or whether that account is already activated, and the token
+// is made in the background so that the response time doesn't say so either.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.background(func() {
+		err := app.sendActivationToken(input.Email)
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("failed to send activation token: %w", err), nil)
+		}
+	})
+
+	env := envelope{"message": "if an unactivated account with that email address exists, an email will be sent to it containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The sendActivationToken() helper makes a new activation token for the user with the
+// given email address and emails it to them, as long as their account hasn't been
+// activated yet. Disabled accounts don't get a token either, as activating the account
+// wouldn't turn it back on.
+func (app *application) sendActivationToken(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if user.Activated || user.Disabled {
+		return nil
+	}
+
+	// Old tokens stop working as soon as a new one is issued.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	templateData := map[string]interface{}{
+		"activationToken": token.Plaintext,
+	}
+
+	err = app.mailer.Send(user.Email, "token_activation.tmpl", templateData)
+	if err != nil {
+		return fmt.Errorf("user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+// session is an authentication token as shown in the list of a user's sessions, marked
+// with whether it's the token that the list was requested with.
+type session struct {
+	*data.Token
+	Scope   string `json:"scope"`
+	Current bool   `json:"current"`
+}
+
+// The isCurrentSession() helper reports whether a token is the one that the request was
+// authenticated with: the opaque token itself, or the refresh token issued alongside
+// the request's JWT.
+func (app *application) isCurrentSession(r *http.Request, token *data.Token) bool {
+	if token.Scope == data.ScopeRefresh {
+		return token.ID == app.contextGetSessionID(r)
+	}
+	return bytes.Equal(token.Hash, app.contextGetTokenHash(r))
+}
+
+// The listAuthenticationTokensHandler() lists the current user's active sessions (that
+// is, their unexpired authentication tokens).
+func (app *application) listAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// In jwt mode each session is represented by its refresh token, so list both kinds.
+	sessions := []session{}
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		tokens, err := app.models.Tokens.GetAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, token := range tokens {
+			sessions = append(sessions, session{Token: token, Scope: scope, Current: app.isCurrentSession(r, token)})
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"tokens": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAuthenticationTokenHandler() logs the user out by deleting the token that
+// the request was made with.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// A JWT can't be revoked, but deleting the refresh token that was issued with it
+	// means that the session ends as soon as the JWT expires.
+	if hash := app.contextGetTokenHash(r); hash != nil {
+		err = app.models.Tokens.Delete(hash)
+	} else {
+		err = app.models.Tokens.DeleteByID(data.ScopeRefresh, app.contextGetUser(r).ID, app.contextGetSessionID(r))
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteAllAuthenticationTokensHandler() logs the user out of every session,
+// including the current one.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The refreshAuthenticationTokenHandler() exchanges a refresh token for a new access
+// token and refresh token. Refresh tokens are single-use: the old one is deleted as it's
+// looked up, so a stolen refresh token stops working as soon as either party uses it,
+// and two requests racing with the same token can't both succeed.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := app.models.Tokens.Consume(data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Disabling an account deletes its refresh tokens, but one could still be used in
+	// the moment before that happens.
+	if user.Disabled {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	env, err := app.issueAuthentication(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+)
+
+func TestIsCurrentSession(t *testing.T) {
+	app := newTestApplication(t)
+
+	opaque := &data.Token{ID: 1, Hash: []byte("current"), Scope: data.ScopeAuthentication}
+	refresh := &data.Token{ID: 2, Hash: []byte("refresh"), Scope: data.ScopeRefresh}
+
+	tests := []struct {
+		name      string
+		hash      []byte
+		sessionID int64
+		token     *data.Token
+		want      bool
+	}{
+		{"same opaque token", []byte("current"), 0, opaque, true},
+		{"other opaque token", []byte("other"), 0, opaque, false},
+		{"refresh token of the JWT", nil, 2, refresh, true},
+		{"other refresh token", nil, 3, refresh, false},
+		// A refresh token is never matched by hash, as that's never what the request
+		// was made with.
+		{"refresh token by hash", []byte("refresh"), 0, refresh, false},
+		{"opaque token by ID", nil, 1, opaque, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/tokens", nil)
+			if tc.hash != nil {
+				r = app.contextSetTokenHash(r, tc.hash)
+			}
+			if tc.sessionID != 0 {
+				r = app.contextSetSessionID(r, tc.sessionID)
+			}
+
+			if got := app.isCurrentSession(r, tc.token); got != tc.want {
+				t.Errorf("got %t; want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	app := newTestDBApplication(t)
+	user := app.newTestUser(t)
+
+	newSession := func(ttl time.Duration, scope string) *data.Token {
+		t.Helper()
+		token, err := app.models.Tokens.NewSession(user.ID, ttl, scope, "192.0.2.1", "curl/8.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	current := newSession(time.Hour, data.ScopeAuthentication)
+	other := newSession(time.Hour, data.ScopeAuthentication)
+	refresh := newSession(time.Hour, data.ScopeRefresh)
+	newSession(-time.Minute, data.ScopeAuthentication)
+	newSession(time.Hour, data.ScopeActivation)
+
+	t.Run("list", func(t *testing.T) {
+		rr := app.serveTest(t, app.listAuthenticationTokensHandler, testRequest{
+			method:  http.MethodGet,
+			target:  "/v1/tokens",
+			user:    user,
+			session: current,
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var body struct {
+			Tokens []map[string]interface{} `json:"tokens"`
+		}
+		decodeResponse(t, rr, &body)
+
+		// Expired tokens and tokens which aren't sessions aren't listed.
+		got := map[float64]map[string]interface{}{}
+		for _, token := range body.Tokens {
+			got[token["id"].(float64)] = token
+		}
+		if len(got) != 3 || got[float64(current.ID)] == nil || got[float64(other.ID)] == nil || got[float64(refresh.ID)] == nil {
+			t.Fatalf("got sessions %v; want %d, %d and %d", body.Tokens, current.ID, other.ID, refresh.ID)
+		}
+
+		for id, token := range got {
+			if want := id == float64(current.ID); token["current"] != want {
+				t.Errorf("session %v: got current %v; want %t", id, token["current"], want)
+			}
+			if token["ip"] != "192.0.2.1" || token["user_agent"] != "curl/8.0" || token["created_at"] == nil {
+				t.Errorf("session %v: got %v; want the client details", id, token)
+			}
+			// The token itself can never be read back.
+			if _, ok := token["token"]; ok {
+				t.Errorf("session %v: the token was included", id)
+			}
+		}
+		if got[float64(refresh.ID)]["scope"] != data.ScopeRefresh {
+			t.Errorf("got scope %v for the refresh token", got[float64(refresh.ID)]["scope"])
+		}
+	})
+
+	sessions := func() int {
+		t.Helper()
+		count := 0
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			tokens, err := app.models.Tokens.GetAllForUser(scope, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count += len(tokens)
+		}
+		return count
+	}
+
+	t.Run("delete current opaque token", func(t *testing.T) {
+		rr := app.serveTest(t, app.deleteAuthenticationTokenHandler, testRequest{
+			method:  http.MethodDelete,
+			target:  "/v1/tokens/authentication",
+			user:    user,
+			session: current,
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := sessions(); got != 2 {
+			t.Errorf("got %d sessions left; want 2", got)
+		}
+	})
+
+	t.Run("delete current JWT session", func(t *testing.T) {
+		// A request made with a JWT has no token hash, only the ID of the refresh
+		// token that was issued with it.
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			app.deleteAuthenticationTokenHandler(w, app.contextSetSessionID(r, refresh.ID))
+		}
+		rr := app.serveTest(t, handler, testRequest{
+			method: http.MethodDelete,
+			target: "/v1/tokens/authentication",
+			user:   user,
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := sessions(); got != 1 {
+			t.Errorf("got %d sessions left; want 1", got)
+		}
+	})
+
+	t.Run("delete all", func(t *testing.T) {
+		newSession(time.Hour, data.ScopeRefresh)
+
+		rr := app.serveTest(t, app.deleteAllAuthenticationTokensHandler, testRequest{
+			method:  http.MethodDelete,
+			target:  "/v1/tokens/authentication/all",
+			user:    user,
+			session: other,
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := sessions(); got != 0 {
+			t.Errorf("got %d sessions left; want 0", got)
+		}
+
+		activation, err := app.models.Tokens.GetAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(activation) != 1 {
+			t.Errorf("got %d activation tokens; want the one to be left alone", len(activation))
+		}
+	})
+}
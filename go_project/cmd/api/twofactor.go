@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/totp"
+	"goproject/internal/validator"
+)
+
+// totpIssuer is the account issuer shown by authenticator apps.
+const totpIssuer = "goproject"
+
+// The enrolTwoFactorHandler() starts two-factor enrolment by generating a new TOTP
+// secret. Nothing changes for the user until they confirm it with a code.
+func (app *application) enrolTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Enrol(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			v := validator.New()
+			v.AddError("2fa", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmTwoFactorHandler() turns two-factor authentication on once the user has
+// sent a valid code for their new secret, and returns their recovery codes.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(!twoFactor.Confirmed, "2fa", "two-factor authentication is already enabled")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	valid, err := app.checkTOTP(twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !valid {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Permissions which require two-factor authentication are granted to every one of
+	// the user's sessions once it's enabled, but their other sessions were only opened
+	// with a password, so log them out first. They'll need a code to log in again. The
+	// session that sent this request has just shown a code, so it's kept. This happens
+	// before two-factor authentication is turned on, so that a failure can't leave the
+	// other sessions with those permissions.
+	err = app.models.Tokens.DeleteOtherSessionsForUser(user.ID, app.contextGetTokenHash(r), app.contextGetSessionID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Confirm(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Permissions which require two-factor authentication count from now on.
+	app.models.Permissions.Cache.Invalidate(user.ID)
+
+	codes, err := app.models.TwoFactor.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The regenerateRecoveryCodesHandler() replaces the user's recovery codes, for example
+// after they've used some of them. It needs a current code from the authenticator app.
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.requireTwoFactorCode(w, r, user, input.Code, "") {
+		return
+	}
+
+	codes, err := app.models.TwoFactor.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The disableTwoFactorHandler() turns two-factor authentication off. It needs either a
+// current code from the authenticator app or one of the recovery codes.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.requireTwoFactorCode(w, r, user, input.Code, input.RecoveryCode) {
+		return
+	}
+
+	err = app.models.TwoFactor.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Permissions which require two-factor authentication stop counting.
+	app.models.Permissions.Cache.Invalidate(user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createTwoFactorPendingToken() helper is the first step of logging in with
+// two-factor authentication. It's called once the password has been checked, and sends
+// the client a short-lived token to exchange, together with a code, at
+// POST /v1/tokens/2fa. The token can't be used to authenticate any other request.
+func (app *application) createTwoFactorPendingToken(w http.ResponseWriter, r *http.Request, user *data.User) {
+	token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactorPending)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"two_factor_required": true, "two_factor_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createTwoFactorAuthenticationTokenHandler() is the second step of logging in with
+// two-factor authentication. It exchanges a 2fa-pending token and a code from the
+// authenticator app (or a recovery code) for a full authentication token.
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactorPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Wrong codes count as failed logins for the user's email address, so guessing
+	// them is throttled in the same way as guessing passwords.
+	attempt := app.newLoginAttempt(r, user.Email)
+	if !app.checkLoginThrottle(w, r, attempt) {
+		return
+	}
+
+	valid, err := app.checkTwoFactorCode(user, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !valid {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.resetLoginFailures(r, attempt)
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactorPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env, err := app.issueAuthentication(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The requireTwoFactorCode() helper checks a code from the user's authenticator app, or
+// a recovery code if recoveryCode isn't empty. If the user doesn't have two-factor
+// authentication enabled, or the code is wrong, it sends the response itself and returns
+// false.
+func (app *application) requireTwoFactorCode(w http.ResponseWriter, r *http.Request, user *data.User, code, recoveryCode string) bool {
+	enabled, err := app.models.TwoFactor.Enabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+	if v.Check(enabled, "2fa", "two-factor authentication is not enabled"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	valid, err := app.checkTwoFactorCode(user, code, recoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !valid {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
+
+// The checkTwoFactorCode() helper checks either a recovery code (which is used up) or a
+// code from the authenticator app for a user with two-factor authentication enabled.
+func (app *application) checkTwoFactorCode(user *data.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return app.models.TwoFactor.UseRecoveryCode(user.ID, recoveryCode)
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !twoFactor.Confirmed {
+		return false, nil
+	}
+
+	return app.checkTOTP(twoFactor, code)
+}
+
+// The checkTOTP() helper checks a code against a TOTP secret, allowing for one time
+// step of clock drift, and makes sure that the same code can't be used twice.
+func (app *application) checkTOTP(twoFactor *data.TwoFactor, code string) (bool, error) {
+	counter, ok := totp.Validate(twoFactor.Secret, code, time.Now(), 1)
+	if !ok || counter <= twoFactor.LastCounter {
+		return false, nil
+	}
+
+	return app.models.TwoFactor.UseCounter(twoFactor.UserID, counter)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/totp"
+)
+
+func TestTwoFactorFlow(t *testing.T) {
+	app := newTestDBApplication(t)
+	user := app.newTestUser(t)
+
+	// The session that enrols, and another one opened elsewhere with only a password.
+	current, err := app.models.Tokens.NewSession(user.ID, time.Hour, data.ScopeAuthentication, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := app.models.Tokens.NewSession(user.ID, time.Hour, data.ScopeAuthentication, "192.0.2.2", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method string, handler http.HandlerFunc, target, body string, user *data.User) (int, map[string]interface{}) {
+		t.Helper()
+		rr := app.serveTest(t, handler, testRequest{method: method, target: target, body: body, user: user, session: current})
+		var response map[string]interface{}
+		decodeResponse(t, rr, &response)
+		return rr.Code, response
+	}
+
+	// Enrol.
+	status, response := send(http.MethodPost, app.enrolTwoFactorHandler, "/v1/users/me/2fa", "", user)
+	if status != http.StatusCreated {
+		t.Fatalf("enrolling: got status %d; want %d: %v", status, http.StatusCreated, response)
+	}
+	secret, _ := response["secret"].(string)
+
+	code := func(offset int64) string {
+		t.Helper()
+		c, err := totp.Code(secret, totp.Counter(time.Now())+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// Confirm, first with a wrong code.
+	status, _ = send(http.MethodPost, app.confirmTwoFactorHandler, "/v1/users/me/2fa/confirm", `{"code": "000000"}`, user)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("confirming with a wrong code: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	status, response = send(http.MethodPost, app.confirmTwoFactorHandler, "/v1/users/me/2fa/confirm", fmt.Sprintf(`{"code": %q}`, code(0)), user)
+	if status != http.StatusOK {
+		t.Fatalf("confirming: got status %d; want %d: %v", status, http.StatusOK, response)
+	}
+	recoveryCodes, _ := response["recovery_codes"].([]interface{})
+	if len(recoveryCodes) == 0 {
+		t.Fatal("got no recovery codes")
+	}
+
+	// The other session was only opened with a password, so it's logged out; this one
+	// is kept.
+	sessions, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !bytes.Equal(sessions[0].Hash, current.Hash) {
+		t.Errorf("got %d sessions; want only the current one, not %d", len(sessions), other.ID)
+	}
+
+	// A code can't be used twice.
+	status, _ = send(http.MethodPost, app.regenerateRecoveryCodesHandler, "/v1/users/me/2fa/recovery-codes", fmt.Sprintf(`{"code": %q}`, code(0)), user)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("reusing a code: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	// Logging in now takes the password and then a code.
+	status, response = send(http.MethodPost, app.createAuthenticationTokenHandler, "/v1/tokens/authentication",
+		fmt.Sprintf(`{"email": %q, "password": %q}`, user.Email, testPassword), data.AnonymousUser)
+	if status != http.StatusCreated || response["two_factor_required"] != true {
+		t.Fatalf("logging in: got status %d and %v; want a 2fa-pending token", status, response)
+	}
+	pending, _ := response["two_factor_token"].(map[string]interface{})
+
+	status, _ = send(http.MethodPost, app.createTwoFactorAuthenticationTokenHandler, "/v1/tokens/2fa",
+		fmt.Sprintf(`{"token": %q, "code": "000000"}`, pending["token"]), data.AnonymousUser)
+	if status != http.StatusUnauthorized {
+		t.Errorf("logging in with a wrong code: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	status, response = send(http.MethodPost, app.createTwoFactorAuthenticationTokenHandler, "/v1/tokens/2fa",
+		fmt.Sprintf(`{"token": %q, "code": %q}`, pending["token"], code(1)), data.AnonymousUser)
+	if status != http.StatusCreated || response["authentication_token"] == nil {
+		t.Fatalf("logging in with a code: got status %d and %v; want an authentication token", status, response)
+	}
+
+	// The pending token has been used up.
+	status, _ = send(http.MethodPost, app.createTwoFactorAuthenticationTokenHandler, "/v1/tokens/2fa",
+		fmt.Sprintf(`{"token": %q, "recovery_code": %q}`, pending["token"], recoveryCodes[0]), data.AnonymousUser)
+	if status != http.StatusUnauthorized {
+		t.Errorf("reusing the pending token: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	// Disable it again with a recovery code.
+	status, response = send(http.MethodDelete, app.disableTwoFactorHandler, "/v1/users/me/2fa", fmt.Sprintf(`{"recovery_code": %q}`, recoveryCodes[0]), user)
+	if status != http.StatusOK {
+		t.Fatalf("disabling: got status %d; want %d: %v", status, http.StatusOK, response)
+	}
+	enabled, err := app.models.TwoFactor.Enabled(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Error("two-factor authentication is still enabled")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"goproject/internal/data"
 	"goproject/internal/validator"
@@ -40,14 +41,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 	// Validate the user struct and return the error messages to the client if any of
-	// the checks fail.
-	if data.ValidateUser(v, user); !v.Valid() {
+	// the checks fail. The password also has to meet the password policy.
+	data.ValidateUser(v, user)
+	err = app.passwordPolicy.Validate(v, "password", input.Password, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	// Insert the user data into the database, and give the new user the "viewer" role,
+	// which lets them read (but not change) the researchers, expeditions and artifacts.
+	err = app.insertUser(r, user, "viewer")
 	if err != nil {
 		switch {
 			// If we get a ErrDuplicateEmail error, use the v.AddError() method to manually
@@ -62,13 +70,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	// Call the Send() method on our Mailer, passing in the user's email address,
 	// name of the template file, and the User struct containing the new user's data.
@@ -87,7 +88,12 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	res.Token = &token.Plaintext
 	res.User = user
 
-	app.writeJSON(w, http.StatusCreated, envelope{"user": res}, nil)
+	// The response contains the plaintext activation token, so it mustn't be cached (or
+	// stored against an Idempotency-Key).
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": res}, headers)
 	
 	// Write a JSON response containing the user data along with a 201 Created status
 	// code.
@@ -98,6 +104,31 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 }
 
 
+// The insertUser() helper creates a user with the given roles. Both happen in one
+// transaction, so that a failure can't leave behind a user without any roles.
+func (app *application) insertUser(r *http.Request, user *data.User, roles ...string) error {
+	tx, err := app.models.Begin()
+	if err != nil {
+		return err
+	}
+	// Rolling back after a successful commit is a no-op, so it's safe to always defer it.
+	defer tx.Rollback()
+
+	models := app.modelsFor(r).WithTx(tx)
+
+	err = models.Users.Insert(user)
+	if err != nil {
+		return err
+	}
+
+	err = models.Permissions.AddRolesForUser(user.ID, roles...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the plaintext activation token from the request body.
 	var input struct {
@@ -137,7 +168,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	
 	// Save the updated user record in our database, checking for any edit conflicts in
 	// the same way that we did for our movie records.
-	err = app.models.Users.Update(user)
+	err = app.models.As(app.auditActor(r, user.ID)).Users.Update(user)
 	if err != nil {
 		switch {
 			case errors.Is(err, data.ErrEditConflict):
@@ -160,4 +191,442 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// The showMyResearcherHandler() returns the researcher profile that the current user is
+// linked to, or a 404 Not Found response if they aren't linked to one.
+func (app *application) showMyResearcherHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if user.ResearcherID == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	researcher, err := app.models.Researchers.Get(*user.ResearcherID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"researcher": researcher}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateUserPasswordHandler() sets a new password for the user who owns a password
+// reset token. Changing the password also signs the user out everywhere, by deleting all
+// of their authentication tokens.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The password policy needs the user's details, so it can only be checked once we
+	// know whose token this is.
+	err = app.passwordPolicy.Validate(v, "password", input.Password, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.As(app.auditActor(r, user.ID)).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The reset token is single-use, and any existing sessions may belong to whoever
+	// the user is trying to lock out, so delete both.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The currentUserRecord() helper fetches a fresh copy of the current user from the
+// database. Handlers which update the user need it because the user in the request
+// context came from a JWT when the API is in jwt mode, and so has no password hash or
+// version number. If it can't get the user it sends the response itself and returns
+// false.
+func (app *application) currentUserRecord(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		// A JWT carries on working until it expires, even if the account has since been
+		// deleted.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// The checkCurrentPassword() helper adds a validation error unless the plaintext
+// password matches the user's current password. Sensitive changes to an account require
+// it, so that someone who gets hold of a session can't take the account over. Each check
+// counts as a login attempt for the user's email address and the client's IP address, so
+// that the session can't be used to guess the password without limit either. If the
+// client is throttled or something goes wrong, the response has already been sent and
+// it returns false.
+func (app *application) checkCurrentPassword(w http.ResponseWriter, r *http.Request, v *validator.Validator, user *data.User, plaintext string) bool {
+	if plaintext == "" {
+		v.AddError("current_password", "must be provided")
+		return true
+	}
+
+	attempt := app.newLoginAttempt(r, user.Email)
+	if !app.checkLoginThrottle(w, r, attempt) {
+		return false
+	}
+
+	match, err := user.Password.Matches(plaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	// A right password only takes back this attempt. Earlier failures are still
+	// counted, since they may have been someone guessing at the login endpoint (perhaps
+	// to get past two-factor authentication) and this isn't a login.
+	if match {
+		app.refundLoginAttempt(r, attempt)
+	}
+
+	v.Check(match, "current_password", "is incorrect")
+	return true
+}
+
+// The showCurrentUserHandler() returns the current user's own account details.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUserRecord(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCurrentUserHandler() lets the current user change their name. Email
+// addresses and passwords have their own endpoints because changing them needs the
+// current password.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUserRecord(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCurrentUserEmailHandler() changes the current user's email address. The
+// new address hasn't been proven to belong to the user yet, so the account goes back to
+// being unactivated and a new activation token is sent to the new address.
+func (app *application) updateCurrentUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUserRecord(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Email != user.Email, "email", "must be different from the current email address")
+
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Email = input.Email
+	user.Activated = false
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Any activation tokens that are still around were sent to the old address.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		templateData := map[string]interface{}{
+			"activationToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", templateData)
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("failed to send activation email to user %d: %w", user.ID, err), nil)
+		}
+	})
+
+	env := envelope{
+		"user":    user,
+		"message": "an email will be sent to the new address containing activation instructions",
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateCurrentUserPasswordHandler() changes the current user's password, given
+// their current one. The user stays logged in on the session that made the change, but
+// every other session is logged out.
+func (app *application) updateCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUserRecord(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+	// ValidatePasswordPlaintext() reports problems under "password", but the field is
+	// called "new_password" here.
+	if message, exists := v.Errors["password"]; exists {
+		delete(v.Errors, "password")
+		v.AddError("new_password", message)
+	}
+	err = app.passwordPolicy.Validate(v, "new_password", input.NewPassword, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v.Check(input.NewPassword != input.CurrentPassword, "new_password", "must be different from the current password")
+
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Outstanding password reset tokens would let someone undo the change, so they go
+	// along with the other sessions.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteOtherSessionsForUser(user.ID, app.contextGetTokenHash(r), app.contextGetSessionID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteCurrentUserHandler() deletes the current user's account, given their
+// current password. Everything that belongs to the account (tokens, API keys, roles and
+// so on) is deleted with it, but any researcher profile it was linked to is kept.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUserRecord(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Users.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account was successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+)
+
+// newTestSelfServiceApplication returns a database-backed application with the default
+// password policy, and waits for any emails it tries to send when the test finishes.
+func newTestSelfServiceApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestDBApplication(t)
+	app.passwordPolicy = &data.PasswordPolicy{}
+	t.Cleanup(app.wg.Wait)
+	return app
+}
+
+func TestCurrentUser(t *testing.T) {
+	app := newTestSelfServiceApplication(t)
+	user := app.newTestUser(t)
+
+	rr := app.serveTest(t, app.showCurrentUserHandler, testRequest{method: http.MethodGet, target: "/v1/users/me", user: user})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var body struct {
+		User map[string]interface{} `json:"user"`
+	}
+	decodeResponse(t, rr, &body)
+	if body.User["email"] != user.Email {
+		t.Errorf("got %v; want the user's own details", body.User)
+	}
+	if _, ok := body.User["password"]; ok {
+		t.Error("the password hash was included")
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		want     int
+		wantName string
+	}{
+		{"change name", `{"name": "Harriet Boyd"}`, http.StatusOK, "Harriet Boyd"},
+		{"no changes", `{}`, http.StatusOK, "Harriet Boyd"},
+		{"empty name", `{"name": ""}`, http.StatusUnprocessableEntity, "Harriet Boyd"},
+		// Email addresses have their own endpoint.
+		{"change email", `{"email": "other@example.com"}`, http.StatusBadRequest, "Harriet Boyd"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := app.serveTest(t, app.updateCurrentUserHandler, testRequest{method: http.MethodPatch, target: "/v1/users/me", body: tc.body, user: user})
+			if rr.Code != tc.want {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+
+			saved, err := app.models.Users.Get(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Name != tc.wantName || saved.Email != user.Email {
+				t.Errorf("got name %q and email %q; want %q and %q", saved.Name, saved.Email, tc.wantName, user.Email)
+			}
+		})
+	}
+}
+
+func TestUpdateCurrentUserEmail(t *testing.T) {
+	app := newTestSelfServiceApplication(t)
+	user := app.newTestUser(t)
+	taken := app.newTestUser(t)
+	newEmail := fmt.Sprintf("new-%d@example.com", time.Now().UnixNano())
+
+	tests := []struct {
+		name       string
+		body       string
+		want       int
+		wantErrors []string
+	}{
+		{"no password", fmt.Sprintf(`{"email": %q}`, newEmail), http.StatusUnprocessableEntity, []string{"current_password"}},
+		{"wrong password", fmt.Sprintf(`{"email": %q, "current_password": "not the password"}`, newEmail), http.StatusUnprocessableEntity, []string{"current_password"}},
+		{"invalid email", fmt.Sprintf(`{"email": "not an email", "current_password": %q}`, testPassword), http.StatusUnprocessableEntity, []string{"email"}},
+		{"same email", fmt.Sprintf(`{"email": %q, "current_password": %q}`, user.Email, testPassword), http.StatusUnprocessableEntity, []string{"email"}},
+		{"taken email", fmt.Sprintf(`{"email": %q, "current_password": %q}`, taken.Email, testPassword), http.StatusUnprocessableEntity, []string{"email"}},
+		{"new email", fmt.Sprintf(`{"email": %q, "current_password": %q}`, newEmail, testPassword), http.StatusAccepted, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := app.serveTest(t, app.updateCurrentUserEmailHandler, testRequest{method: http.MethodPut, target: "/v1/users/me/email", body: tc.body, user: user})
+			if rr.Code != tc.want {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+
+			if tc.wantErrors != nil {
+				var body struct {
+					Error map[string]string `json:"error"`
+				}
+				decodeResponse(t, rr, &body)
+				for _, key := range tc.wantErrors {
+					if _, ok := body.Error[key]; !ok {
+						t.Errorf("got errors %v; want one for %q", body.Error, key)
+					}
+				}
+			}
+		})
+	}
+
+	// The new address has to be activated before the account can be used again.
+	saved, err := app.models.Users.Get(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Email != newEmail || saved.Activated {
+		t.Errorf("got email %q, activated %t; want %q, not activated", saved.Email, saved.Activated, newEmail)
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 {
+		t.Errorf("got %d activation tokens; want 1", len(tokens))
+	}
+}
+
+func TestUpdateCurrentUserPassword(t *testing.T) {
+	app := newTestSelfServiceApplication(t)
+	user := app.newTestUser(t)
+	const newPassword = "anemone-lighthouse-77"
+
+	current, err := app.models.Tokens.NewSession(user.ID, time.Hour, data.ScopeAuthentication, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset} {
+		if _, err := app.models.Tokens.New(user.ID, time.Hour, scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no current password", fmt.Sprintf(`{"new_password": %q}`, newPassword), http.StatusUnprocessableEntity},
+		{"wrong current password", fmt.Sprintf(`{"current_password": "not the password", "new_password": %q}`, newPassword), http.StatusUnprocessableEntity},
+		{"too short", fmt.Sprintf(`{"current_password": %q, "new_password": "short"}`, testPassword), http.StatusUnprocessableEntity},
+		{"unchanged", fmt.Sprintf(`{"current_password": %q, "new_password": %q}`, testPassword, testPassword), http.StatusUnprocessableEntity},
+		{"contains the email address", fmt.Sprintf(`{"current_password": %q, "new_password": %q}`, testPassword, user.Email), http.StatusUnprocessableEntity},
+		{"changed", fmt.Sprintf(`{"current_password": %q, "new_password": %q}`, testPassword, newPassword), http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := app.serveTest(t, app.updateCurrentUserPasswordHandler, testRequest{method: http.MethodPut, target: "/v1/users/me/password", body: tc.body, user: user, session: current})
+			if rr.Code != tc.want {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+		})
+	}
+
+	saved, err := app.models.Users.Get(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, err := saved.Password.Matches(newPassword); err != nil || !match {
+		t.Errorf("the new password doesn't match: %v", err)
+	}
+
+	// Every other session is logged out, and so are outstanding password resets, but
+	// the session which made the change is kept.
+	counts := map[string]int{data.ScopeAuthentication: 1, data.ScopeRefresh: 0, data.ScopePasswordReset: 0}
+	for scope, want := range counts {
+		tokens, err := app.models.Tokens.GetAllForUser(scope, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != want {
+			t.Errorf("got %d %s tokens; want %d", len(tokens), scope, want)
+		}
+		if scope == data.ScopeAuthentication && len(tokens) == 1 && tokens[0].ID != current.ID {
+			t.Errorf("kept session %d; want the current one, %d", tokens[0].ID, current.ID)
+		}
+	}
+}
+
+func TestDeleteCurrentUser(t *testing.T) {
+	app := newTestSelfServiceApplication(t)
+	user := app.newTestUser(t)
+
+	send := func(body string) int {
+		t.Helper()
+		rr := app.serveTest(t, app.deleteCurrentUserHandler, testRequest{method: http.MethodDelete, target: "/v1/users/me", body: body, user: user})
+		return rr.Code
+	}
+
+	if status := send(`{}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("without a password: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+	if status := send(`{"current_password": "not the password"}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("with the wrong password: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+	if _, err := app.models.Users.Get(user.ID); err != nil {
+		t.Fatalf("the account was deleted without the password: %v", err)
+	}
+
+	if status := send(fmt.Sprintf(`{"current_password": %q}`, testPassword)); status != http.StatusOK {
+		t.Fatalf("got status %d; want %d", status, http.StatusOK)
+	}
+	if _, err := app.models.Users.Get(user.ID); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("getting the deleted user: got %v; want %v", err, data.ErrRecordNotFound)
+	}
+
+	// A JWT for the account keeps working until it expires, but the account is gone.
+	rr := app.serveTest(t, app.showCurrentUserHandler, testRequest{method: http.MethodGet, target: "/v1/users/me", user: user})
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("showing the deleted user: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
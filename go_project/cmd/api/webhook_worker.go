@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/webhook"
+)
+
+const (
+	// webhookBatchSize is how many deliveries the worker claims at a time.
+	webhookBatchSize = 20
+
+	// A delivery which fails is retried after webhookBackoffBase, then after twice as
+	// long each time, up to webhookBackoffMax between attempts.
+	webhookBackoffBase = 30 * time.Second
+	webhookBackoffMax  = 6 * time.Hour
+)
+
+// The startWebhookWorker() method sends queued webhook deliveries every
+// webhook-poll-interval in a background goroutine, until the stop channel is closed.
+// Like the janitor, the goroutine is tracked by app.wg so that a graceful shutdown waits
+// for the delivery in progress to finish.
+func (app *application) startWebhookWorker(stop <-chan struct{}) {
+	interval := app.config.webhooks.pollInterval
+	if interval <= 0 {
+		return
+	}
+
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				app.runWebhookDeliveries(stop)
+			}
+		}
+	}()
+}
+
+// The runWebhookDeliveries() method sends deliveries until there are none left which are
+// due, or until the stop channel is closed. Deliveries that were claimed but not sent
+// before stopping are picked up again once their lease runs out. Any panic is recovered
+// so that one bad delivery doesn't stop the worker for good.
+func (app *application) runWebhookDeliveries(stop <-chan struct{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	// The lease has to outlast an attempt, so that no other worker can claim the
+	// delivery while it's being sent.
+	lease := app.config.webhooks.timeout + time.Minute
+
+	for {
+		deliveries, err := app.models.Deliveries.ClaimDue(webhookBatchSize, lease)
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("claim webhook deliveries: %w", err), nil)
+			return
+		}
+
+		for _, delivery := range deliveries {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			app.deliverWebhook(delivery)
+		}
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// The deliverWebhook() method makes one attempt to send a delivery, and records the
+// outcome. A delivery which fails is retried with exponential backoff until it has
+// been tried webhook-max-attempts times, after which it's marked as failed and has to be
+// redelivered by hand.
+func (app *application) deliverWebhook(delivery *data.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.webhooks.timeout)
+	defer cancel()
+
+	status, err := app.webhookSender.Send(ctx, webhook.Delivery{
+		ID:     delivery.ID,
+		URL:    delivery.URL,
+		Secret: delivery.Secret,
+		Event:  delivery.EventType,
+		Body:   delivery.Payload,
+	})
+
+	delivery.ResponseStatus = nil
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+	delivery.LastError = ""
+	delivery.NextAttemptAt = time.Now()
+
+	attempts := delivery.Attempts + 1
+
+	switch {
+	case err == nil:
+		delivery.Status = data.DeliverySucceeded
+	case attempts >= app.config.webhooks.maxAttempts:
+		delivery.Status = data.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = data.DeliveryPending
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(webhook.Backoff(attempts, webhookBackoffBase, webhookBackoffMax))
+	}
+
+	err = app.models.Deliveries.RecordAttempt(delivery)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logger.PrintError(fmt.Errorf("record attempt for webhook delivery %d: %w", delivery.ID, err), nil)
+	}
+}
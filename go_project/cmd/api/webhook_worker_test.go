@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"goproject/internal/data"
+	"goproject/internal/webhook"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// webhookTest is a webhook, subscribed to expedition.created, whose receiver responds
+// with whatever status is set, against an application backed by the test database.
+type webhookTest struct {
+	app     *application
+	user    *data.User
+	webhook *data.Webhook
+	status  int
+}
+
+func newWebhookTest(t *testing.T) *webhookTest {
+	t.Helper()
+
+	wt := &webhookTest{app: newTestDBApplication(t), status: http.StatusOK}
+	app := wt.app
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(wt.status)
+		fmt.Fprint(w, "receiver internals")
+	}))
+	t.Cleanup(ts.Close)
+
+	app.config.webhooks.timeout = 5 * time.Second
+	app.config.webhooks.maxAttempts = 3
+	app.webhookSender = &webhook.Sender{Client: ts.Client(), UserAgent: "test"}
+
+	wt.user = &data.User{Name: "Hook Owner", Email: fmt.Sprintf("webhook-%d@example.com", time.Now().UnixNano()), Activated: true}
+	if err := wt.user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(wt.user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.models.Users.Delete(wt.user.ID) })
+
+	err := app.models.Permissions.AddForUser(wt.user.ID, "webhooks:manage", "expeditions:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wt.webhook = &data.Webhook{UserID: wt.user.ID, URL: ts.URL, EventTypes: []string{"expedition.created"}, Active: true}
+	if err := app.models.Webhooks.Insert(wt.webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	return wt
+}
+
+// trigger creates an expedition, which queues a delivery to the webhook.
+func (wt *webhookTest) trigger(t *testing.T) {
+	t.Helper()
+
+	researcher := &data.Researcher{Name: "Arthur Evans", Specialization: "Minoan", Project: "Knossos"}
+	if err := wt.app.models.Researchers.Insert(researcher); err != nil {
+		t.Fatal(err)
+	}
+
+	expedition := &data.Expedition{Title: "Knossos", ExpeditionYear: 1900, Researcher_id: researcher.Id}
+	if err := wt.app.models.Expeditions.Insert(expedition); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		wt.app.models.Expeditions.Delete(int64(expedition.Id))
+		wt.app.models.Researchers.Delete(int64(researcher.Id))
+	})
+}
+
+// run sends every delivery which is due, and returns the webhook's deliveries, oldest
+// first.
+func (wt *webhookTest) run(t *testing.T) []*data.WebhookDelivery {
+	t.Helper()
+
+	wt.app.runWebhookDeliveries(make(chan struct{}))
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+	deliveries, _, err := wt.app.models.Deliveries.GetAllForWebhook(wt.webhook.ID, "", filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func TestWebhookWorkerRetries(t *testing.T) {
+	wt := newWebhookTest(t)
+	wt.status = http.StatusInternalServerError
+	wt.trigger(t)
+
+	deliveries := wt.run(t)
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries; want 1", len(deliveries))
+	}
+
+	d := deliveries[0]
+	if d.Status != data.DeliveryPending || d.Attempts != 1 {
+		t.Errorf("got status %q after %d attempts; want %q after 1", d.Status, d.Attempts, data.DeliveryPending)
+	}
+	if d.ResponseStatus == nil || *d.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("got response status %v; want %d", d.ResponseStatus, http.StatusInternalServerError)
+	}
+	if !d.NextAttemptAt.After(time.Now()) {
+		t.Errorf("got next attempt at %s; want it backed off", d.NextAttemptAt)
+	}
+	if d.LastError == "" || strings.Contains(d.LastError, "receiver internals") {
+		t.Errorf("got last error %q; want one without the response body", d.LastError)
+	}
+}
+
+func TestWebhookWorkerMarksFailed(t *testing.T) {
+	wt := newWebhookTest(t)
+	wt.app.config.webhooks.maxAttempts = 1
+	wt.status = http.StatusBadGateway
+	wt.trigger(t)
+
+	deliveries := wt.run(t)
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries; want 1", len(deliveries))
+	}
+	if d := deliveries[0]; d.Status != data.DeliveryFailed || d.Attempts != 1 {
+		t.Errorf("got status %q after %d attempts; want %q after 1", d.Status, d.Attempts, data.DeliveryFailed)
+	}
+}
+
+func TestWebhookWorkerRedelivers(t *testing.T) {
+	wt := newWebhookTest(t)
+	wt.app.config.webhooks.maxAttempts = 1
+	wt.status = http.StatusServiceUnavailable
+	wt.trigger(t)
+
+	deliveries := wt.run(t)
+	if len(deliveries) != 1 || deliveries[0].Status != data.DeliveryFailed {
+		t.Fatalf("got deliveries %+v; want one which failed", deliveries)
+	}
+	original := deliveries[0]
+
+	// The receiver is fixed, and the owner asks for the delivery to be sent again.
+	wt.status = http.StatusOK
+
+	webhookID := strconv.FormatInt(wt.webhook.ID, 10)
+	deliveryID := strconv.FormatInt(original.ID, 10)
+	rr := wt.app.serveTest(t, wt.app.redeliverWebhookHandler, testRequest{
+		method: http.MethodPost,
+		target: "/v1/webhooks/" + webhookID + "/deliveries/" + deliveryID + "/redeliver",
+		params: httprouter.Params{{Key: "id", Value: webhookID}, {Key: "delivery_id", Value: deliveryID}},
+		user:   wt.user,
+	})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusAccepted, rr.Body)
+	}
+
+	deliveries = wt.run(t)
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d deliveries; want 2", len(deliveries))
+	}
+
+	if deliveries[0].Status != data.DeliveryFailed {
+		t.Errorf("the original delivery changed to %q", deliveries[0].Status)
+	}
+
+	redelivery := deliveries[1]
+	if redelivery.Status != data.DeliverySucceeded || redelivery.RedeliveryOf == nil || *redelivery.RedeliveryOf != original.ID {
+		t.Errorf("got redelivery %+v; want a successful redelivery of %d", redelivery, original.ID)
+	}
+	if string(redelivery.Payload) != string(original.Payload) {
+		t.Errorf("got payload %s; want %s", redelivery.Payload, original.Payload)
+	}
+}
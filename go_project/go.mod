@@ -8,3 +8,5 @@ require (
 )
 
 require golang.org/x/crypto v0.22.0 // direct
+
+require golang.org/x/sys v0.19.0 // indirect
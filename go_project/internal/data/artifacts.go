@@ -7,7 +7,8 @@ import (
 	"fmt"
 	"goproject/internal/validator"
 	"time"
-	//"github.com/lib/pq"
+
+	"github.com/lib/pq"
 )
 
 type Artifact struct {
@@ -16,6 +17,24 @@ type Artifact struct {
 	Age           int    `json:"age"`
 	Location      string `json:"location"`
 	Researcher_id int    `json:"researcher_id"`
+	// Version starts at 1 and goes up by one every time the artifact is updated. Every
+	// version is kept in the artifact_versions table, see GetHistory().
+	Version int `json:"version"`
+}
+
+// ArtifactVersion is a snapshot of an artifact as it was saved at some point, along
+// with who saved it.
+type ArtifactVersion struct {
+	Artifact Artifact `json:"artifact"`
+	ActorID  int64    `json:"actor_id"`
+	// Deleted is set on the tombstone version saved when the artifact was deleted. It
+	// holds the artifact as it was just before.
+	Deleted bool `json:"deleted"`
+	// Approximate is set on the versions saved for artifacts which already existed when
+	// versions were introduced. They were saved then, so CreatedAt is only the latest
+	// time at which the artifact looked like this, not when it was created.
+	Approximate bool      `json:"approximate"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 func ValidateArtifact(v *validator.Validator, artifact *Artifact) {
@@ -46,21 +65,74 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 type ArtifactModel struct {
 	DB *sql.DB
+	// tx is set on copies of the model returned by Models.WithTx(), in which case all
+	// queries run inside that transaction rather than directly on the pool.
+	tx *sql.Tx
+	// actor is set on copies of the model returned by Models.As(), and is who changes
+	// are recorded against in the audit trail. Without one, they're recorded against
+	// the application itself.
+	actor *Actor
+}
+
+// conn() returns the transaction that the model is bound to, if any, or the connection
+// pool otherwise.
+func (s ArtifactModel) conn() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+// lock() fetches a record and locks it until the end of the transaction, so that the
+// audit trail can record what it looked like before it was changed. It returns
+// ErrRecordNotFound if there's no such record.
+func (s ArtifactModel) lock(q queryer, id int64) (*Artifact, error) {
+	query := `
+		SELECT artifact_id, title, age, location, researcher_id, version
+		FROM artifact
+		WHERE artifact_id = $1
+		FOR UPDATE`
+
+	var artifact Artifact
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := q.QueryRowContext(ctx, query, id).Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id, &artifact.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &artifact, nil
 }
 
 // Add a placeholder method for inserting a new record in the researchers table.
 func (s ArtifactModel) Insert(artifact *Artifact) error {
 	// Define the SQL query for inserting a new record in the researchers table and returning
 	// the system-generated data.
+	//
+	// The first version of the artifact is saved in the same statement, so that there's
+	// never an artifact without its history (even if the model isn't in a transaction).
 	query := `
-		INSERT INTO artifact(title, age, location, researcher_id)
-		VALUES ($1, $2, $3, $4)
-		RETURNING artifact_id, title, age, location, researcher_id;`
+		WITH inserted AS (
+			INSERT INTO artifact(title, age, location, researcher_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING artifact_id, title, age, location, researcher_id, version
+		), saved AS (
+			INSERT INTO artifact_versions (artifact_id, version, title, age, location, researcher_id, actor_id)
+			SELECT artifact_id, version, title, age, location, researcher_id, $5
+			FROM inserted
+		)
+		SELECT artifact_id, title, age, location, researcher_id, version
+		FROM inserted;`
 
 	// Create an args slice containing the values for the placeholder parameters from
 	// the reseracher struct. Declaring this slice immediately next to our SQL query helps to
 	// make it nice and clear *what values are being used where* in the query.
-	args := []interface{}{artifact.Title, artifact.Age, artifact.Location, artifact.Researcher_id}
+	args := []interface{}{artifact.Title, artifact.Age, artifact.Location, artifact.Researcher_id, s.actor.id()}
 
 	// Use the QueryRow() method to execute the SQL query on our connection pool,
 	// passing in the args slice as a variadic parameter and scanning the system-
@@ -68,7 +140,13 @@ func (s ArtifactModel) Insert(artifact *Artifact) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id)
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		err := q.QueryRowContext(ctx, query, args...).Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id, &artifact.Version)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("artifact.created", "artifact", int64(artifact.Id), nil, artifact)
+	})
 }
 
 // Add a placeholder method for fetching a specific record from the researchers table.
@@ -79,7 +157,7 @@ func (s ArtifactModel) Get(id int64) (*Artifact, error) {
 
 	// Retrieve a specific menu item based on its ID.
 	query := `
-		SELECT artifact_id, title, age, location, researcher_id
+		SELECT artifact_id, title, age, location, researcher_id, version
 		FROM artifact
 		WHERE artifact_id = $1;`
 
@@ -87,8 +165,8 @@ func (s ArtifactModel) Get(id int64) (*Artifact, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := s.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id)
+	row := s.conn().QueryRowContext(ctx, query, id)
+	err := row.Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id, &artifact.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -100,22 +178,157 @@ func (s ArtifactModel) Get(id int64) (*Artifact, error) {
 	return &artifact, nil
 }
 
-// Add a placeholder method for updating a specific record in the researchers table.
+// Add a placeholder method for updating a specific record in the researchers table. It
+// returns ErrEditConflict if the artifact has been changed (or deleted) since its
+// Version was read.
 func (s ArtifactModel) Update(artifact *Artifact) error {
+	// As in Insert(), the new version is saved in the same statement. The row lock taken
+	// by the UPDATE means that concurrent updates get consecutive version numbers, and
+	// checking the version means that only the first of them succeeds.
 	query := `
-		UPDATE artifact
-		SET title = $1, age = $2, location = $3, researcher_id = $4
-		WHERE artifact_id = $5
-		RETURNING artifact_id, title, age, location, researcher_id;
+		WITH updated AS (
+			UPDATE artifact
+			SET title = $1, age = $2, location = $3, researcher_id = $4, version = version + 1
+			WHERE artifact_id = $5 AND version = $7
+			RETURNING artifact_id, title, age, location, researcher_id, version
+		), saved AS (
+			INSERT INTO artifact_versions (artifact_id, version, title, age, location, researcher_id, actor_id)
+			SELECT artifact_id, version, title, age, location, researcher_id, $6
+			FROM updated
+		)
+		SELECT artifact_id, title, age, location, researcher_id, version
+		FROM updated;
 		`
 
-	args := []interface{}{artifact.Title, artifact.Age, artifact.Location, artifact.Researcher_id, artifact.Id}
+	args := []interface{}{artifact.Title, artifact.Age, artifact.Location, artifact.Researcher_id, artifact.Id, s.actor.id(), artifact.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id)
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		before, err := s.lock(q, int64(artifact.Id))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				return nil, ErrEditConflict
+			default:
+				return nil, err
+			}
+		}
+
+		err = q.QueryRowContext(ctx, query, args...).Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id, &artifact.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrEditConflict
+			default:
+				return nil, translateError(err)
+			}
+		}
+		return newAuditEvent("artifact.updated", "artifact", int64(artifact.Id), before, artifact)
+	})
 }
 
+// InsertMany() inserts all of the given artifacts inside a transaction, so that either
+// every row is imported or none of them are. The rows are copied into a temporary table
+// with a single COPY statement, and then inserted from there together with their first
+// versions, so only the new rows are touched. The Id fields of the artifacts are left
+// untouched. If the model is already bound to a transaction, the rows are imported in
+// that one and it's up to the caller to commit it. Each new artifact is recorded in the
+// audit trail just as if it had been inserted with Insert().
+func (s ArtifactModel) InsertMany(artifacts []*Artifact) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return auditedMany(s.DB, s.tx, s.actor, func(q queryer) ([]*AuditEvent, error) {
+		// The temporary table is dropped at the end, so that InsertMany() can be
+		// called more than once in the same transaction. ON COMMIT DROP covers the
+		// case where we return early.
+		_, err := q.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE artifact_import ON COMMIT DROP AS
+			SELECT title, age, location, researcher_id FROM artifact WITH NO DATA`)
+		if err != nil {
+			return nil, err
+		}
+
+		stmt, err := q.PrepareContext(ctx, pq.CopyIn("artifact_import", "title", "age", "location", "researcher_id"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, artifact := range artifacts {
+			_, err = stmt.ExecContext(ctx, artifact.Title, artifact.Age, artifact.Location, artifact.Researcher_id)
+			if err != nil {
+				stmt.Close()
+				return nil, err
+			}
+		}
+
+		// Calling Exec() with no arguments flushes the buffered rows to the server.
+		_, err = stmt.ExecContext(ctx)
+		if err != nil {
+			stmt.Close()
+			return nil, err
+		}
+
+		err = stmt.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// Insert the copied rows and save their first versions in the same statement,
+		// in the same way as Insert(). This is the point where any constraint
+		// violations are reported.
+		query := `
+			WITH inserted AS (
+				INSERT INTO artifact (title, age, location, researcher_id)
+				SELECT title, age, location, researcher_id
+				FROM artifact_import
+				RETURNING artifact_id, title, age, location, researcher_id, version
+			), saved AS (
+				INSERT INTO artifact_versions (artifact_id, version, title, age, location, researcher_id, actor_id)
+				SELECT artifact_id, version, title, age, location, researcher_id, $1
+				FROM inserted
+			)
+			SELECT artifact_id, title, age, location, researcher_id, version
+			FROM inserted`
+
+		rows, err := q.QueryContext(ctx, query, s.actor.id())
+		if err != nil {
+			return nil, translateError(err)
+		}
+		defer rows.Close()
+
+		events := []*AuditEvent{}
+		for rows.Next() {
+			var artifact Artifact
+			err = rows.Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id, &artifact.Version)
+			if err != nil {
+				return nil, err
+			}
+
+			event, err := newAuditEvent("artifact.created", "artifact", int64(artifact.Id), nil, &artifact)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, translateError(err)
+		}
+		rows.Close()
+
+		_, err = q.ExecContext(ctx, "DROP TABLE artifact_import")
+		if err != nil {
+			return nil, err
+		}
+
+		return events, nil
+	})
+}
+
+// Delete() deletes an artifact, but keeps its versions. A tombstone version marked as
+// deleted is saved in the same statement, so that the history shows who deleted it and
+// when, and the artifact can be restored later with Restore().
 func (s ArtifactModel) Delete(id int64) error {
 	// Return an ErrRecordNotFound error if the researcher ID is less than 1.
 	if id < 1 {
@@ -123,31 +336,79 @@ func (s ArtifactModel) Delete(id int64) error {
 	}
 
 	query := `
-		DELETE FROM artifact
-		WHERE artifact_id = $1
-		`
+		WITH deleted AS (
+			DELETE FROM artifact
+			WHERE artifact_id = $1
+			RETURNING artifact_id, title, age, location, researcher_id, version
+		)
+		INSERT INTO artifact_versions (artifact_id, version, title, age, location, researcher_id, actor_id, deleted)
+		SELECT artifact_id, version + 1, title, age, location, researcher_id, $2, true
+		FROM deleted`
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := s.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		// Locking the record first gets us its final state for the audit trail, and
+		// tells us whether there's a record with the provided ID at all.
+		before, err := s.lock(q, id)
+		if err != nil {
+			return nil, err
+		}
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows
-	// affected by the query.
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+		_, err = q.ExecContext(ctx, query, id, s.actor.id())
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("artifact.deleted", "artifact", id, before, nil)
+	})
+}
 
-	// If no rows were affected, we know that the researchers table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+// Restore() brings back a deleted artifact, under its old ID, with the content of the
+// given artifact. Its Version must be that of the tombstone saved when it was deleted;
+// the restored artifact is saved as the next version after that. It returns
+// ErrEditConflict if the artifact isn't deleted, or has been restored (or deleted
+// again) since its tombstone was read.
+func (s ArtifactModel) Restore(artifact *Artifact) error {
+	query := `
+		WITH inserted AS (
+			INSERT INTO artifact (artifact_id, title, age, location, researcher_id, version)
+			SELECT artifact_id, $2, $3, $4, $5, version + 1
+			FROM artifact_versions
+			WHERE artifact_id = $1 AND version = $6 AND deleted
+			AND NOT EXISTS (
+				SELECT 1 FROM artifact_versions AS later
+				WHERE later.artifact_id = $1 AND later.version > $6
+			)
+			RETURNING artifact_id, title, age, location, researcher_id, version
+		), saved AS (
+			INSERT INTO artifact_versions (artifact_id, version, title, age, location, researcher_id, actor_id)
+			SELECT artifact_id, version, title, age, location, researcher_id, $7
+			FROM inserted
+		)
+		SELECT artifact_id, title, age, location, researcher_id, version
+		FROM inserted`
+
+	args := []interface{}{artifact.Id, artifact.Title, artifact.Age, artifact.Location, artifact.Researcher_id, artifact.Version, s.actor.id()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		err := q.QueryRowContext(ctx, query, args...).Scan(&artifact.Id, &artifact.Title, &artifact.Age, &artifact.Location, &artifact.Researcher_id, &artifact.Version)
+		if err != nil {
+			// Two restores of the same artifact at once both get past the checks, but
+			// the second can't insert the artifact again.
+			err = translateError(err)
+			switch {
+			case errors.Is(err, sql.ErrNoRows), errors.Is(err, ErrDuplicate):
+				return nil, ErrEditConflict
+			default:
+				return nil, err
+			}
+		}
+		return newAuditEvent("artifact.restored", "artifact", int64(artifact.Id), nil, artifact)
+	})
 }
 
 // Create a new GetAll() method which returns a slice of researchers. Although we're not
@@ -156,7 +417,7 @@ func (s ArtifactModel) Delete(id int64) error {
 func (s ArtifactModel) GetAll(title string, age int, filters Filters) ([]*Artifact, Metadata, error) {
 	// Construct the SQL query to retrieve all researcher records.
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), artifact_id, title, age, location, researcher_id
+		SELECT count(*) OVER(), artifact_id, title, age, location, researcher_id, version
 		FROM artifact
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (age = $2 OR $2 = 1)
@@ -175,7 +436,7 @@ func (s ArtifactModel) GetAll(title string, age int, filters Filters) ([]*Artifa
 
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
 	// containing the result.
-	rows, err := s.DB.QueryContext(ctx, query, args...)
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -200,6 +461,7 @@ func (s ArtifactModel) GetAll(title string, age int, filters Filters) ([]*Artifa
 			&artifact.Age,
 			&artifact.Location,
 			&artifact.Researcher_id,
+			&artifact.Version,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -223,7 +485,7 @@ func (s ArtifactModel) GetAll(title string, age int, filters Filters) ([]*Artifa
 func (s ArtifactModel) GetArtifactsByResearcher(id int64, title string, age int, filters Filters) ([]*Artifact, Metadata, error) {
 	// Construct the SQL query to retrieve all researcher records.
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), artifact_id, title, age, location, researcher_id
+		SELECT count(*) OVER(), artifact_id, title, age, location, researcher_id, version
 		FROM artifact
 		WHERE (researcher_id = $1)
 		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
@@ -238,7 +500,7 @@ func (s ArtifactModel) GetArtifactsByResearcher(id int64, title string, age int,
 
 	args := []interface{}{id, title, age, filters.limit(), filters.offset()}
 
-	rows, err := s.DB.QueryContext(ctx, query, args...)
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -261,6 +523,7 @@ func (s ArtifactModel) GetArtifactsByResearcher(id int64, title string, age int,
 			&artifact.Age,
 			&artifact.Location,
 			&artifact.Researcher_id,
+			&artifact.Version,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -277,3 +540,221 @@ func (s ArtifactModel) GetArtifactsByResearcher(id int64, title string, age int,
 	
 	return artifacts, metadata, nil
 }
+
+// GetStats() returns the number of artifacts in each group for the given grouping,
+// respecting the same title and age filters as GetAll(). The grouping expression is
+// picked from a fixed set below rather than interpolated from the client, so the
+// group_by value never reaches the SQL directly.
+func (s ArtifactModel) GetStats(title string, age int, filters StatsFilters) ([]*Stat, error) {
+	args := []interface{}{title, age}
+
+	var groupExpr string
+	switch filters.groupBy() {
+	case "location":
+		groupExpr = "location"
+	case "researcher_id":
+		groupExpr = "researcher_id::text"
+	case "age_bucket":
+		// Put each artifact into a bucket of BucketSize years, labelled with the
+		// first and last age of the bucket (e.g. "100-199").
+		groupExpr = `CASE WHEN age IS NULL THEN 'unknown'
+			ELSE concat((age / $3) * $3, '-', (age / $3) * $3 + $3 - 1) END`
+		args = append(args, filters.BucketSize)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s AS key, count(*)
+		FROM artifact
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (age = $2 OR $2 = 1)
+		GROUP BY key
+		ORDER BY count(*) DESC, key`, groupExpr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*Stat{}
+
+	for rows.Next() {
+		var stat Stat
+		err := rows.Scan(&stat.Key, &stat.Count)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &stat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// StreamAll() calls fn for every artifact matching the same filters as GetAll(), in the
+// requested sort order. Unlike GetAll() the results aren't paginated: rows are read
+// from a server-side cursor in batches, so this is safe to use for full exports.
+func (s ArtifactModel) StreamAll(title string, age int, filters Filters, fn func(artifact *Artifact) error) error {
+	query := fmt.Sprintf(`
+		SELECT artifact_id, title, age, location, researcher_id, version
+		FROM artifact
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (age = $2 OR $2 = 1)
+		ORDER BY %s %s, artifact_id`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{title, age}
+
+	return streamRows(s.DB, query, args, func(rows *sql.Rows) error {
+		var artifact Artifact
+		err := rows.Scan(
+			&artifact.Id,
+			&artifact.Title,
+			&artifact.Age,
+			&artifact.Location,
+			&artifact.Researcher_id,
+			&artifact.Version,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(&artifact)
+	})
+}
+
+// GetHistory() returns a page of the saved versions of an artifact. It returns
+// ErrRecordNotFound if there's no such artifact.
+func (s ArtifactModel) GetHistory(id int64, filters Filters) ([]*ArtifactVersion, Metadata, error) {
+	if id < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), artifact_id, title, age, location, researcher_id, version, actor_id, deleted, approximate, created_at
+		FROM artifact_versions
+		WHERE artifact_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.conn().QueryContext(ctx, query, id, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	versions := []*ArtifactVersion{}
+
+	for rows.Next() {
+		var version ArtifactVersion
+		err := rows.Scan(
+			&totalRecords,
+			&version.Artifact.Id,
+			&version.Artifact.Title,
+			&version.Artifact.Age,
+			&version.Artifact.Location,
+			&version.Artifact.Researcher_id,
+			&version.Artifact.Version,
+			&version.ActorID,
+			&version.Deleted,
+			&version.Approximate,
+			&version.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		versions = append(versions, &version)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// Every artifact has at least one version (and they're kept when it's deleted), so
+	// an empty first page means that the artifact never existed.
+	if totalRecords == 0 && filters.Page == 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return versions, metadata, nil
+}
+
+// GetVersion() returns a single saved version of an artifact, or ErrRecordNotFound if
+// there's no such version.
+func (s ArtifactModel) GetVersion(id int64, version int) (*ArtifactVersion, error) {
+	query := `
+		SELECT artifact_id, title, age, location, researcher_id, version, actor_id, deleted, approximate, created_at
+		FROM artifact_versions
+		WHERE artifact_id = $1 AND version = $2`
+
+	return s.getVersion(query, id, version)
+}
+
+// GetLatestVersion() returns the newest saved version of an artifact, which is the
+// tombstone if the artifact has been deleted. It returns ErrRecordNotFound if there
+// has never been such an artifact.
+func (s ArtifactModel) GetLatestVersion(id int64) (*ArtifactVersion, error) {
+	query := `
+		SELECT artifact_id, title, age, location, researcher_id, version, actor_id, deleted, approximate, created_at
+		FROM artifact_versions
+		WHERE artifact_id = $1
+		ORDER BY version DESC
+		LIMIT 1`
+
+	return s.getVersion(query, id)
+}
+
+// GetAsOf() reconstructs an artifact as it was at the given time, by returning the
+// latest version that had been saved by then. This is the tombstone if the artifact had
+// been deleted by then. It returns ErrRecordNotFound if the artifact didn't exist yet.
+// An approximate first version counts as saved at any time, since we don't know how
+// long before versions were introduced the artifact was created.
+func (s ArtifactModel) GetAsOf(id int64, t time.Time) (*ArtifactVersion, error) {
+	query := `
+		SELECT artifact_id, title, age, location, researcher_id, version, actor_id, deleted, approximate, created_at
+		FROM artifact_versions
+		WHERE artifact_id = $1 AND (created_at <= $2 OR approximate)
+		ORDER BY version DESC
+		LIMIT 1`
+
+	return s.getVersion(query, id, t)
+}
+
+// getVersion() runs a query which returns a single row from artifact_versions.
+func (s ArtifactModel) getVersion(query string, args ...interface{}) (*ArtifactVersion, error) {
+	var version ArtifactVersion
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.conn().QueryRowContext(ctx, query, args...).Scan(
+		&version.Artifact.Id,
+		&version.Artifact.Title,
+		&version.Artifact.Age,
+		&version.Artifact.Location,
+		&version.Artifact.Researcher_id,
+		&version.Artifact.Version,
+		&version.ActorID,
+		&version.Deleted,
+		&version.Approximate,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &version, nil
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// newTestArtifact inserts an artifact, belonging to a new researcher, which are both
+// deleted again (along with the artifact's versions) when the test finishes.
+func newTestArtifact(tb testing.TB, m Models) *Artifact {
+	tb.Helper()
+
+	researcher := &Researcher{Name: "Arthur Evans", Specialization: "Minoan", Project: "Knossos"}
+	if err := m.Researchers.Insert(researcher); err != nil {
+		tb.Fatal(err)
+	}
+
+	artifact := &Artifact{Title: "Snake goddess", Age: 3600, Location: "Crete", Researcher_id: researcher.Id}
+	if err := m.Artifacts.Insert(artifact); err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		m.Artifacts.Delete(int64(artifact.Id))
+		m.db.Exec(`DELETE FROM artifact_versions WHERE artifact_id = $1`, artifact.Id)
+		m.Researchers.Delete(int64(researcher.Id))
+	})
+	return artifact
+}
+
+func TestArtifactUpdateEditConflict(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	artifact := newTestArtifact(t, m)
+
+	first, err := m.Artifacts.Get(int64(artifact.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.Artifacts.Get(int64(artifact.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.Location = "Heraklion"
+	if err := m.Artifacts.Update(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 {
+		t.Errorf("got version %d; want 2", first.Version)
+	}
+
+	// The second copy was read before the first update, so saving it would lose that
+	// update.
+	second.Age = 3700
+	if err := m.Artifacts.Update(second); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("updating a stale artifact: got %v; want %v", err, ErrEditConflict)
+	}
+
+	if err := m.Artifacts.Delete(int64(artifact.Id)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Artifacts.Update(first); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("updating a deleted artifact: got %v; want %v", err, ErrEditConflict)
+	}
+}
+
+func TestArtifactDeleteAndRestore(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	artifact := newTestArtifact(t, m)
+	id := int64(artifact.Id)
+
+	if err := m.Artifacts.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The versions are kept, ending with a tombstone.
+	tombstone, err := m.Artifacts.GetLatestVersion(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tombstone.Deleted || tombstone.Artifact.Version != 2 || tombstone.Artifact.Title != artifact.Title {
+		t.Errorf("got latest version %+v; want a tombstone for version 1", tombstone)
+	}
+
+	restored := tombstone.Artifact
+	if err := m.Artifacts.Restore(&restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Id != artifact.Id || restored.Version != 3 {
+		t.Errorf("got restored artifact %+v; want ID %d at version 3", restored, artifact.Id)
+	}
+
+	if _, err := m.Artifacts.Get(id); err != nil {
+		t.Errorf("getting the restored artifact: %v", err)
+	}
+
+	// Restoring from the same tombstone again is a conflict, as the artifact is back.
+	again := tombstone.Artifact
+	if err := m.Artifacts.Restore(&again); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("restoring twice: got %v; want %v", err, ErrEditConflict)
+	}
+}
+
+// importedTitles counts the artifacts, and the versions of artifacts, with the given
+// titles.
+func importedTitles(t *testing.T, m Models, titles ...string) (artifacts, versions int) {
+	t.Helper()
+
+	err := m.db.QueryRow(`SELECT count(*) FROM artifact WHERE title = ANY($1)`, pq.Array(titles)).Scan(&artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.db.QueryRow(`SELECT count(*) FROM artifact_versions WHERE title = ANY($1)`, pq.Array(titles)).Scan(&versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return artifacts, versions
+}
+
+func TestArtifactInsertMany(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	researcher := newTestArtifact(t, m).Researcher_id
+	suffix := fmt.Sprintf(" %d", time.Now().UnixNano())
+
+	t.Cleanup(func() {
+		m.db.Exec(`DELETE FROM artifact WHERE title LIKE '%' || $1`, suffix)
+		m.db.Exec(`DELETE FROM artifact_versions WHERE title LIKE '%' || $1`, suffix)
+	})
+
+	t.Run("all or nothing", func(t *testing.T) {
+		titles := []string{"Linear A tablet" + suffix, "Linear B tablet" + suffix}
+		artifacts := []*Artifact{
+			{Title: titles[0], Age: 3700, Location: "Crete", Researcher_id: researcher},
+			// There's no such researcher, so the whole import must be rolled back.
+			{Title: titles[1], Age: 3400, Location: "Pylos", Researcher_id: researcher + 1_000_000},
+		}
+
+		err := m.Artifacts.InsertMany(artifacts)
+		if !errors.Is(err, ErrForeignKeyViolation) {
+			t.Fatalf("got %v; want %v", err, ErrForeignKeyViolation)
+		}
+
+		if n, versions := importedTitles(t, m, titles...); n != 0 || versions != 0 {
+			t.Errorf("got %d artifacts and %d versions; want none", n, versions)
+		}
+	})
+
+	t.Run("twice in one transaction", func(t *testing.T) {
+		titles := []string{"Phaistos disc" + suffix, "Harvester vase" + suffix}
+
+		tx, err := m.db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		// The temporary table is dropped after each import, so the second one can
+		// create it again.
+		for _, title := range titles {
+			err = m.WithTx(tx).Artifacts.InsertMany([]*Artifact{{Title: title, Age: 3700, Location: "Crete", Researcher_id: researcher}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if n, versions := importedTitles(t, m, titles...); n != 2 || versions != 2 {
+			t.Errorf("got %d artifacts and %d versions; want 2 of each", n, versions)
+		}
+	})
+}
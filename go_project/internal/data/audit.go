@@ -0,0 +1,366 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"goproject/internal/validator"
+)
+
+// AuditEvent records a single change made through the API: who made it (ActorID),
+// which request it was made in (RequestID and IP), what they did (Action), which record
+// it was done to (TargetType and TargetID), how the record changed, and any extra
+// details that are useful when reviewing it later. The actor ID isn't a foreign key, so
+// that events outlive the users who caused them. An actor ID of 0 means that nobody
+// was logged in, as when a user registers; if there's no request ID either, the change
+// was made by the application itself, as when the janitor deletes stale accounts.
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	ActorID    int64                  `json:"actor_id"`
+	RequestID  string                 `json:"request_id"`
+	IP         string                 `json:"ip"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   int64                  `json:"target_id"`
+	Changes    map[string]Change      `json:"changes,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+
+	// record is the record that the event is about, as it was after the change (or
+	// before it, for a deletion). It isn't stored, but it's sent to webhooks.
+	record interface{}
+}
+
+// Change is the old and new value of one field of a record. From is null for a record
+// that was created, and To is null for one that was deleted.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Actor identifies who is making changes through a set of models, so that the models
+// can record them in the audit trail. See Models.As().
+type Actor struct {
+	UserID    int64
+	RequestID string
+	IP        string
+}
+
+// id returns the actor's user ID, or zero if there's no actor.
+func (a *Actor) id() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.UserID
+}
+
+// event fills in the actor's details on an audit event, and returns it. A nil actor is
+// the application itself, which is recorded with an actor ID of 0 and no request.
+func (a *Actor) event(event *AuditEvent) *AuditEvent {
+	if a == nil {
+		return event
+	}
+	event.ActorID = a.UserID
+	event.RequestID = a.RequestID
+	event.IP = a.IP
+	return event
+}
+
+// Diff compares two versions of a record, going by their JSON encodings, and returns
+// the fields that differ. Either version may be nil, for a record that was created or
+// deleted. Fields that are hidden from JSON (such as password hashes) are left out.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	for name, value := range beforeFields {
+		if !bytes.Equal(value, afterFields[name]) {
+			changes[name] = Change{From: value, To: fieldValue(afterFields, name)}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			changes[name] = Change{From: nil, To: value}
+		}
+	}
+
+	return changes, nil
+}
+
+// fieldValue returns the encoded field, or nil if the record doesn't have it.
+func fieldValue(fields map[string]json.RawMessage, name string) interface{} {
+	if value, ok := fields[name]; ok {
+		return value
+	}
+	return nil
+}
+
+// jsonFields returns the JSON encoding of each field of a record.
+func jsonFields(record interface{}) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if record == nil {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// newAuditEvent returns an event for a change to a record, with the differences
+// between the before and after versions as its changes.
+func newAuditEvent(action, targetType string, targetID int64, before, after interface{}) (*AuditEvent, error) {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	record := after
+	if after == nil {
+		record = before
+	}
+
+	return &AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    changes,
+		record:     record,
+	}, nil
+}
+
+// audited is how the models make changes that belong in the audit trail. fn makes the
+// change using the queryer that it's given, and returns an event describing it. fn runs
+// inside a transaction (the model's own, if it's bound to one), and the event is
+// inserted in the same transaction, so that a change is never saved without its event
+// or the other way round. Models without an actor record their changes against the
+// application itself. The event is also queued for any webhooks subscribed to it in
+// the same transaction.
+func audited(db *sql.DB, tx *sql.Tx, actor *Actor, fn func(q queryer) (*AuditEvent, error)) error {
+	return auditedMany(db, tx, actor, func(q queryer) ([]*AuditEvent, error) {
+		event, err := fn(q)
+		if err != nil || event == nil {
+			return nil, err
+		}
+		return []*AuditEvent{event}, nil
+	})
+}
+
+// auditedMany is audited for changes to many records at once, such as imports, where fn
+// returns an event for each record that it changed.
+func auditedMany(db *sql.DB, tx *sql.Tx, actor *Actor, fn func(q queryer) ([]*AuditEvent, error)) error {
+	ownTx := tx == nil
+	if ownTx {
+		var err error
+		tx, err = db.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		// Rolling back after a successful commit is a no-op, so it's safe to always
+		// defer it.
+		defer tx.Rollback()
+	}
+
+	events, err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	// When there are many events, check once for each type of event whether any
+	// webhooks want it, rather than building a payload for every record.
+	subscribed := make(map[string]bool)
+
+	for _, event := range events {
+		err = insertAuditEvent(tx, actor.event(event))
+		if err != nil {
+			return err
+		}
+
+		if len(events) > 1 {
+			wanted, checked := subscribed[event.Action]
+			if !checked {
+				wanted, err = hasWebhookSubscribers(tx, event.Action)
+				if err != nil {
+					return err
+				}
+				subscribed[event.Action] = wanted
+			}
+			if !wanted {
+				continue
+			}
+		}
+
+		err = enqueueWebhookDeliveries(tx, event)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ownTx {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// Define the AuditEventModel type.
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new event to the audit trail. It's for events which aren't about a
+// change made through one of the other models, such as a user being logged out.
+func (m AuditEventModel) Insert(event *AuditEvent) error {
+	return insertAuditEvent(m.DB, event)
+}
+
+func insertAuditEvent(q queryer, event *AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	changes, err := json.Marshal(event.Changes)
+	if err != nil {
+		return err
+	}
+	if event.Changes == nil {
+		changes = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO audit_events (actor_id, request_id, ip, action, target_type, target_id, changes, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	args := []interface{}{event.ActorID, event.RequestID, event.IP, event.Action, event.TargetType, event.TargetID, changes, details}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// AuditEventFilter holds the optional filters for listing audit events. Zero values
+// match everything.
+type AuditEventFilter struct {
+	ActorID    int64
+	RequestID  string
+	Action     string
+	TargetType string
+	TargetID   int64
+	Since      time.Time
+	Until      time.Time
+}
+
+// ValidateAuditEventFilter checks that a time range makes sense.
+func ValidateAuditEventFilter(v *validator.Validator, f AuditEventFilter) {
+	v.Check(f.ActorID >= 0, "actor_id", "must not be negative")
+	v.Check(f.TargetID >= 0, "target_id", "must not be negative")
+	v.Check(f.Since.IsZero() || f.Until.IsZero() || f.Since.Before(f.Until), "until", "must be after since")
+}
+
+// GetAll() returns a page of audit events matching the filter.
+func (m AuditEventModel) GetAll(filter AuditEventFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, request_id, ip, action, target_type, target_id, changes, details
+		FROM audit_events
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (request_id = $2 OR $2 = '')
+		AND (action = $3 OR $3 = '')
+		AND (target_type = $4 OR $4 = '')
+		AND (target_id = $5 OR $5 = 0)
+		AND (created_at >= $6 OR $6 IS NULL)
+		AND (created_at < $7 OR $7 IS NULL)
+		ORDER BY %s %s, id
+		LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		filter.ActorID,
+		filter.RequestID,
+		filter.Action,
+		filter.TargetType,
+		filter.TargetID,
+		nullTime(filter.Since),
+		nullTime(filter.Until),
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var changes, details []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.RequestID,
+			&event.IP,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&changes,
+			&details,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(changes, &event.Changes)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return events, metadata, nil
+}
+
+// nullTime converts the zero time to NULL, for optional time filters.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
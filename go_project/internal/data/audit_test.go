@@ -0,0 +1,175 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	before := &Artifact{Id: 1, Title: "Phaistos disc", Age: 3700, Location: "Crete", Researcher_id: 1, Version: 1}
+	after := &Artifact{Id: 1, Title: "Phaistos disc", Age: 3700, Location: "Heraklion", Researcher_id: 1, Version: 2}
+
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          map[string]Change
+	}{
+		{"unchanged", before, before, map[string]Change{}},
+		{"updated", before, after, map[string]Change{
+			"location": {From: `"Crete"`, To: `"Heraklion"`},
+			"version":  {From: `1`, To: `2`},
+		}},
+		{"created", nil, before, nil},
+		{"deleted", before, nil, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			changes, err := Diff(tc.before, tc.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A created or deleted record changes every field, from or to null.
+			if tc.want == nil {
+				fields, _ := jsonFields(before)
+				if len(changes) != len(fields) {
+					t.Errorf("got %d changes; want %d", len(changes), len(fields))
+				}
+				for name, change := range changes {
+					if (tc.before == nil) != (change.From == nil) || (tc.after == nil) != (change.To == nil) {
+						t.Errorf("%s: got %s", name, encodeChange(t, change))
+					}
+				}
+				return
+			}
+
+			if len(changes) != len(tc.want) {
+				t.Errorf("got %d changes; want %d", len(changes), len(tc.want))
+			}
+			for name, want := range tc.want {
+				got := encodeChange(t, changes[name])
+				if got != fmt.Sprintf(`{"from":%s,"to":%s}`, want.From, want.To) {
+					t.Errorf("%s: got %s; want from %s to %s", name, got, want.From, want.To)
+				}
+			}
+		})
+	}
+}
+
+func encodeChange(t *testing.T, change Change) string {
+	t.Helper()
+
+	js, err := json.Marshal(change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+// auditEvents returns the events recorded for a request.
+func auditEvents(t *testing.T, m Models, filter AuditEventFilter) []*AuditEvent {
+	t.Helper()
+
+	events, _, err := m.AuditEvents.GetAll(filter, Filters{Page: 1, PageSize: 100, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestAuditTrail(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	actor := &Actor{UserID: 42, RequestID: fmt.Sprintf("test-%d", time.Now().UnixNano()), IP: "192.0.2.1"}
+
+	// The test artifact is inserted without an actor, so it's recorded against the
+	// application itself.
+	artifact := newTestArtifact(t, m)
+	events := auditEvents(t, m, AuditEventFilter{Action: "artifact.created", TargetType: "artifact", TargetID: int64(artifact.Id)})
+	if len(events) != 1 {
+		t.Fatalf("got %d events for inserting an artifact without an actor; want 1", len(events))
+	}
+	if events[0].ActorID != 0 || events[0].RequestID != "" {
+		t.Errorf("got actor %d and request %q; want the application itself", events[0].ActorID, events[0].RequestID)
+	}
+
+	artifact.Location = "Heraklion"
+	if err := m.As(actor).Artifacts.Update(artifact); err != nil {
+		t.Fatal(err)
+	}
+
+	events = auditEvents(t, m, AuditEventFilter{RequestID: actor.RequestID})
+	if len(events) != 1 {
+		t.Fatalf("got %d events for the request; want 1", len(events))
+	}
+	event := events[0]
+	if event.ActorID != actor.UserID || event.IP != actor.IP || event.Action != "artifact.updated" || event.TargetID != int64(artifact.Id) {
+		t.Errorf("got %+v; want artifact.updated by the actor", event)
+	}
+	if change, ok := event.Changes["location"]; !ok || change.From != "Crete" || change.To != "Heraklion" {
+		t.Errorf("got location change %+v; want Crete to Heraklion", change)
+	}
+}
+
+func TestInsertManyAudited(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	researcher := newTestArtifact(t, m).Researcher_id
+	actor := &Actor{UserID: 42, RequestID: fmt.Sprintf("test-%d", time.Now().UnixNano())}
+
+	artifacts := []*Artifact{
+		{Title: "Linear A tablet", Age: 3700, Location: "Crete", Researcher_id: researcher},
+		{Title: "Linear B tablet", Age: 3400, Location: "Pylos", Researcher_id: researcher},
+	}
+	if err := m.As(actor).Artifacts.InsertMany(artifacts); err != nil {
+		t.Fatal(err)
+	}
+
+	events := auditEvents(t, m, AuditEventFilter{RequestID: actor.RequestID})
+	t.Cleanup(func() {
+		for _, event := range events {
+			m.Artifacts.Delete(event.TargetID)
+			m.db.Exec(`DELETE FROM artifact_versions WHERE artifact_id = $1`, event.TargetID)
+		}
+	})
+
+	if len(events) != len(artifacts) {
+		t.Fatalf("got %d events; want one for each of the %d artifacts", len(events), len(artifacts))
+	}
+
+	titles := map[interface{}]bool{}
+	for _, event := range events {
+		if event.Action != "artifact.created" || event.TargetID == 0 || event.ActorID != actor.UserID {
+			t.Errorf("got %+v; want artifact.created for a new artifact by the actor", event)
+		}
+		titles[event.Changes["title"].To] = true
+	}
+	for _, artifact := range artifacts {
+		if !titles[artifact.Title] {
+			t.Errorf("no event created %q", artifact.Title)
+		}
+	}
+}
+
+func TestDeleteUnactivatedAudited(t *testing.T) {
+	m := NewModels(newTestDB(t))
+
+	user := newTestUser(t, m)
+	_, err := m.db.Exec(`UPDATE users SET activated = false, activated_at = NULL, created_at = NOW() - INTERVAL '2 days' WHERE id = $1`, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Users.DeleteUnactivated(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	events := auditEvents(t, m, AuditEventFilter{Action: "user.deleted", TargetType: "user", TargetID: user.ID})
+	if len(events) != 1 {
+		t.Fatalf("got %d events; want 1", len(events))
+	}
+	if events[0].ActorID != 0 || events[0].Changes["email"].From != user.Email {
+		t.Errorf("got %+v; want the user's deletion by the application itself", events[0])
+	}
+}
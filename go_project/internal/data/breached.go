@@ -0,0 +1,91 @@
+package data
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedPasswords is an offline copy of a list of passwords known from data breaches,
+// such as the Pwned Passwords list. Only the SHA-1 hashes of the passwords are stored,
+// split into range files in the same way as the k-anonymity range API: the file for a
+// five character hex prefix holds the rest of every hash that starts with it. The full
+// list is tens of gigabytes, so rather than loading it, each lookup only reads the one
+// range file that it needs.
+type BreachedPasswords struct {
+	dir string
+}
+
+// LoadBreachedPasswords opens a directory of range files, like the ones written by the
+// Pwned Passwords downloader. Each file is named after its upper case prefix (with or
+// without a .txt extension), and each line of it holds the remaining 35 hex characters
+// of a hash, a colon and the number of times it was seen, exactly as the range API
+// returns them. A prefix with no file has no breached passwords.
+func LoadBreachedPasswords(dir string) (*BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory of range files", dir)
+	}
+
+	return &BreachedPasswords{dir: dir}, nil
+}
+
+// Contains reports whether the plaintext password is in the list. Suffixes with a count
+// of zero are padding that the range API can add to hide the size of a range, so they
+// don't count.
+func (b *BreachedPasswords) Contains(plaintext string) (bool, error) {
+	sum := sha1.Sum([]byte(plaintext))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	file, err := b.openRange(hash[:5])
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		suffix, count, _ := strings.Cut(text, ":")
+		if len(suffix) != 2*sha1.Size-5 || strings.Trim(suffix, "0123456789ABCDEFabcdef") != "" {
+			return false, fmt.Errorf("%s:%d: invalid hash suffix %q", file.Name(), line, suffix)
+		}
+
+		if strings.EqualFold(suffix, hash[5:]) {
+			return count != "0", nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// openRange opens the range file for the prefix, which may or may not have a .txt
+// extension.
+func (b *BreachedPasswords) openRange(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(b.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = os.Open(filepath.Join(b.dir, prefix))
+	}
+	return file, err
+}
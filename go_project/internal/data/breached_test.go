@@ -0,0 +1,68 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+
+	// SHA-1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8, and
+	// SHA-1("123456") is 7C4A8D09CA3762AF61E59520943DC26494F8941B.
+	files := map[string]string{
+		"5BAA6.txt": "003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n",
+		"7C4A8":     "D09CA3762AF61E59520943DC26494F8941B:0\n",
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := LoadBreachedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		// Only in the range as padding, with a count of zero.
+		{"123456", false},
+		// No range file at all.
+		{"correct horse battery staple", false},
+	}
+
+	for _, tc := range tests {
+		got, err := b.Contains(tc.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Contains(%q) = %t; want %t", tc.password, got, tc.want)
+		}
+	}
+}
+
+func TestBreachedPasswordsInvalidRange(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("not a hash suffix:1\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := LoadBreachedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Contains("password"); err == nil {
+		t.Error("got no error for an invalid range file")
+	}
+}
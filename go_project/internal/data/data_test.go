@@ -0,0 +1,51 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// The tests and benchmarks which need PostgreSQL run against the database in the
+// TEST_DB_DSN environment variable, which must have all of the migrations applied.
+// They're skipped when it isn't set.
+func newTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// newTestUser inserts a user with a unique email address, which is deleted again when
+// the test finishes.
+func newTestUser(tb testing.TB, m Models) *User {
+	tb.Helper()
+
+	user := &User{
+		Name:      "Test User",
+		Email:     fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+	}
+	user.Password.hash = []byte("not a real hash")
+
+	if err := m.Users.Insert(user); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { m.Users.Delete(user.ID) })
+	return user
+}
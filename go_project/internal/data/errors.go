@@ -0,0 +1,82 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// SQLSTATE codes for the integrity constraint violations that we translate. Unlike the
+// message text, which Postgres translates according to the server's lc_messages
+// setting, these codes are the same whatever language the server speaks.
+const (
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgCheckViolation      = "23514"
+)
+
+var (
+	ErrDuplicate           = errors.New("duplicate record")
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	ErrCheckViolation      = errors.New("check constraint violation")
+)
+
+// constraintErrors maps the names of constraints which need special handling to the
+// errors that should be returned when they're violated. Violations of any other
+// constraint are returned as a ConstraintError.
+var constraintErrors = map[string]error{
+	"users_email_key": ErrDuplicateEmail,
+}
+
+// ConstraintError is returned when a query violates a constraint that doesn't have an
+// error of its own in constraintErrors. Err is one of ErrDuplicate,
+// ErrForeignKeyViolation or ErrCheckViolation, so callers can check for the kind of
+// violation with errors.Is().
+type ConstraintError struct {
+	Err        error
+	Table      string
+	Constraint string
+	pqErr      *pq.Error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%s: %q on table %q", e.Err, e.Constraint, e.Table)
+}
+
+func (e *ConstraintError) Unwrap() []error {
+	return []error{e.Err, e.pqErr}
+}
+
+// translateError converts constraint violations reported by Postgres into the errors
+// above, going by the SQLSTATE code and constraint name rather than the message.
+// Any other error is returned unchanged.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	if constraintErr, ok := constraintErrors[pqErr.Constraint]; ok {
+		return constraintErr
+	}
+
+	var kind error
+	switch pqErr.Code {
+	case pgUniqueViolation:
+		kind = ErrDuplicate
+	case pgForeignKeyViolation:
+		kind = ErrForeignKeyViolation
+	case pgCheckViolation:
+		kind = ErrCheckViolation
+	default:
+		return err
+	}
+
+	return &ConstraintError{
+		Err:        kind,
+		Table:      pqErr.Table,
+		Constraint: pqErr.Constraint,
+		pqErr:      pqErr,
+	}
+}
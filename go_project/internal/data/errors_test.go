@@ -0,0 +1,80 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"duplicate email", &pq.Error{Code: pgUniqueViolation, Constraint: "users_email_key"}, ErrDuplicateEmail},
+		{"other unique violation", &pq.Error{Code: pgUniqueViolation, Constraint: "api_keys_hash_key"}, ErrDuplicate},
+		{"foreign key violation", &pq.Error{Code: pgForeignKeyViolation, Constraint: "tokens_user_id_fkey"}, ErrForeignKeyViolation},
+		{"check violation", &pq.Error{Code: pgCheckViolation, Constraint: "artifacts_age_check"}, ErrCheckViolation},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := translateError(tc.err)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("got %v; want %v", err, tc.want)
+			}
+		})
+	}
+
+	other := errors.New("something else")
+	if err := translateError(other); err != other {
+		t.Errorf("got %v; want the error unchanged", err)
+	}
+}
+
+// TestTranslateErrorLocales checks that constraint violations are recognised whatever
+// language the server reports them in. Changing lc_messages needs a superuser, and each
+// locale has to be installed on the server; the ones that can't be used are skipped.
+func TestTranslateErrorLocales(t *testing.T) {
+	db := newTestDB(t)
+
+	// SET only changes the connection it's sent on, so make sure that there's only
+	// the one.
+	db.SetMaxOpenConns(1)
+	m := NewModels(db)
+
+	for _, locale := range []string{"C", "en_US.UTF-8", "de_DE.UTF-8", "fr_FR.UTF-8", "es_ES.UTF-8", "ja_JP.UTF-8"} {
+		t.Run(locale, func(t *testing.T) {
+			_, err := db.Exec(`SELECT set_config('lc_messages', $1, false)`, locale)
+			if err != nil {
+				t.Skipf("can't set lc_messages: %v", err)
+			}
+			t.Cleanup(func() { db.Exec(`RESET lc_messages`) })
+
+			user := newTestUser(t, m)
+
+			duplicate := &User{Name: "Test User", Email: user.Email}
+			duplicate.Password.hash = []byte("not a real hash")
+			err = m.Users.Insert(duplicate)
+			if !errors.Is(err, ErrDuplicateEmail) {
+				t.Errorf("inserting a duplicate email: got %v; want %v", err, ErrDuplicateEmail)
+			}
+			if err == nil {
+				m.Users.Delete(duplicate.ID)
+			}
+
+			_, err = m.Tokens.New(-1, time.Hour, ScopeAuthentication)
+			if !errors.Is(err, ErrForeignKeyViolation) {
+				t.Errorf("inserting a token for a missing user: got %v; want %v", err, ErrForeignKeyViolation)
+			}
+
+			err = m.Permissions.AddForUser(-1, "artifacts:read")
+			if !errors.Is(err, ErrForeignKeyViolation) {
+				t.Errorf("granting a permission to a missing user: got %v; want %v", err, ErrForeignKeyViolation)
+			}
+		})
+	}
+}
@@ -43,6 +43,48 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 type ExpeditionModel struct {
 	DB *sql.DB
+	// tx is set on copies of the model returned by Models.WithTx(), in which case all
+	// queries run inside that transaction rather than directly on the pool.
+	tx *sql.Tx
+	// actor is set on copies of the model returned by Models.As(), and is who changes
+	// are recorded against in the audit trail. Without one, they're recorded against
+	// the application itself.
+	actor *Actor
+}
+
+// conn() returns the transaction that the model is bound to, if any, or the connection
+// pool otherwise.
+func (s ExpeditionModel) conn() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+// lock() fetches a record and locks it until the end of the transaction, so that the
+// audit trail can record what it looked like before it was changed. It returns
+// ErrRecordNotFound if there's no such record.
+func (s ExpeditionModel) lock(q queryer, id int64) (*Expedition, error) {
+	query := `
+		SELECT expedition_id, title, expeditionYear, researcher_id
+		FROM expedition
+		WHERE expedition_id = $1
+		FOR UPDATE`
+
+	var expedition Expedition
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := q.QueryRowContext(ctx, query, id).Scan(&expedition.Id, &expedition.Title, &expedition.ExpeditionYear, &expedition.Researcher_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &expedition, nil
 }
 
 // Add a placeholder method for inserting a new record in the researchers table.
@@ -65,7 +107,13 @@ func (s ExpeditionModel) Insert(expedition *Expedition) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&expedition.Id, &expedition.Title, &expedition.ExpeditionYear, &expedition.Researcher_id)
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		err := q.QueryRowContext(ctx, query, args...).Scan(&expedition.Id, &expedition.Title, &expedition.ExpeditionYear, &expedition.Researcher_id)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("expedition.created", "expedition", int64(expedition.Id), nil, expedition)
+	})
 }
 
 // Add a placeholder method for fetching a specific record from the researchers table.
@@ -84,7 +132,7 @@ func (s ExpeditionModel) Get(id int64) (*Expedition, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := s.DB.QueryRowContext(ctx, query, id)
+	row := s.conn().QueryRowContext(ctx, query, id)
 	err := row.Scan(&expedition.Id, &expedition.Title, &expedition.ExpeditionYear, &expedition.Researcher_id)
 	if err != nil {
 		switch {
@@ -110,7 +158,18 @@ func (s ExpeditionModel) Update(expedition *Expedition) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&expedition.Id, &expedition.Title, &expedition.ExpeditionYear, &expedition.Researcher_id)
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		before, err := s.lock(q, int64(expedition.Id))
+		if err != nil {
+			return nil, err
+		}
+
+		err = q.QueryRowContext(ctx, query, args...).Scan(&expedition.Id, &expedition.Title, &expedition.ExpeditionYear, &expedition.Researcher_id)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("expedition.updated", "expedition", int64(expedition.Id), before, expedition)
+	})
 }
 
 func (s ExpeditionModel) Delete(id int64) error {
@@ -126,25 +185,20 @@ func (s ExpeditionModel) Delete(id int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := s.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
-
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows
-	// affected by the query.
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		// Locking the record first gets us its final state for the audit trail, and
+		// tells us whether there's a record with the provided ID at all.
+		before, err := s.lock(q, id)
+		if err != nil {
+			return nil, err
+		}
 
-	// If no rows were affected, we know that the researchers table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+		_, err = q.ExecContext(ctx, query, id)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("expedition.deleted", "expedition", id, before, nil)
+	})
 }
 
 // Create a new GetAll() method which returns a slice of researchers. Although we're not
@@ -172,7 +226,7 @@ func (s ExpeditionModel) GetAll(title string, expeditionYear int, filters Filter
 
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
 	// containing the result.
-	rows, err := s.DB.QueryContext(ctx, query, args...)
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -235,7 +289,7 @@ func (s ExpeditionModel) GetExpeditionsByResearcher(id int64, title string, expe
 
 	args := []interface{}{id, title, expeditionYear, filters.limit(), filters.offset()}
 
-	rows, err := s.DB.QueryContext(ctx, query, args...)
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -274,3 +328,76 @@ func (s ExpeditionModel) GetExpeditionsByResearcher(id int64, title string, expe
 
 	return expeditions, metadata, nil
 }
+
+// GetStats() returns the number of expeditions in each group for the given grouping,
+// respecting the same title and year filters as GetAll().
+func (s ExpeditionModel) GetStats(title string, expeditionYear int, filters StatsFilters) ([]*Stat, error) {
+	var groupExpr string
+	switch filters.groupBy() {
+	case "year":
+		groupExpr = "coalesce(expeditionYear::text, 'unknown')"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s AS key, count(*)
+		FROM expedition
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (expeditionYear = $2 OR $2 = 1)
+		GROUP BY key
+		ORDER BY key`, groupExpr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{title, expeditionYear}
+
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*Stat{}
+
+	for rows.Next() {
+		var stat Stat
+		err := rows.Scan(&stat.Key, &stat.Count)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &stat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// StreamAll() calls fn for every expedition matching the same filters as GetAll(), in
+// the requested sort order, reading the rows from a server-side cursor.
+func (s ExpeditionModel) StreamAll(title string, expeditionYear int, filters Filters, fn func(expedition *Expedition) error) error {
+	query := fmt.Sprintf(`
+		SELECT expedition_id, title, expeditionYear, researcher_id
+		FROM expedition
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (expeditionYear = $2 OR $2 = 1)
+		ORDER BY %s %s, expedition_id`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{title, expeditionYear}
+
+	return streamRows(s.DB, query, args, func(rows *sql.Rows) error {
+		var expedition Expedition
+		err := rows.Scan(
+			&expedition.Id,
+			&expedition.Title,
+			&expedition.ExpeditionYear,
+			&expedition.Researcher_id,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(&expedition)
+	})
+}
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownPasswordHash is returned when a stored password hash isn't in a format that
+// any of the password hashers recognise.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher is a password hashing algorithm. The hashes that it produces must be
+// self-describing, so that a hash records which algorithm and parameters were used to
+// make it and can still be checked after the configured hasher has changed.
+type PasswordHasher interface {
+	// Hash returns the hash of the plaintext password.
+	Hash(plaintext []byte) ([]byte, error)
+	// Recognises reports whether the hash was produced by this algorithm, with any
+	// parameters.
+	Recognises(hash []byte) bool
+	// Matches reports whether the plaintext password matches a hash that this
+	// algorithm recognises, using the parameters recorded in the hash.
+	Matches(hash, plaintext []byte) (bool, error)
+	// NeedsRehash reports whether a hash that this algorithm recognises was made with
+	// different parameters to the hasher's own.
+	NeedsRehash(hash []byte) bool
+}
+
+// DefaultPasswordHasher is used to hash new passwords, and any existing hash that it
+// doesn't match is rehashed with it the next time that the user logs in. It's set from
+// the command-line flags when the application starts.
+var DefaultPasswordHasher PasswordHasher = BcryptHasher{Cost: 12}
+
+// passwordHashers are all of the algorithms that stored hashes can be checked with.
+// Their parameters don't matter, because checking a hash uses the ones recorded in it.
+var passwordHashers = []PasswordHasher{BcryptHasher{}, Argon2idHasher{}}
+
+// passwordHasherFor returns the hasher which recognises the hash.
+func passwordHasherFor(hash []byte) (PasswordHasher, error) {
+	for _, hasher := range passwordHashers {
+		if hasher.Recognises(hash) {
+			return hasher, nil
+		}
+	}
+	return nil, ErrUnknownPasswordHash
+}
+
+// BcryptHasher hashes passwords with bcrypt, in the standard "$2a$<cost>$..." format.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext []byte) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(plaintext, h.Cost)
+}
+
+func (h BcryptHasher) Recognises(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (h BcryptHasher) Matches(hash, plaintext []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id, in the PHC string format used by the
+// reference implementation:
+//
+//	$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+//
+// where the salt and key are unpadded base64.
+type Argon2idHasher struct {
+	// Memory is the amount of memory used, in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (h Argon2idHasher) Hash(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(plaintext, salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Recognises(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+func (h Argon2idHasher) Matches(hash, plaintext []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey(plaintext, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, _, err := decodeArgon2idHash(hash)
+	return err != nil || params != h
+}
+
+// decodeArgon2idHash splits an argon2id hash into its parameters, salt and key.
+func decodeArgon2idHash(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
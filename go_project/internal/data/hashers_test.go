@@ -0,0 +1,244 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2id uses the smallest sensible parameters, so that the tests run quickly.
+var testArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// useDefaultPasswordHasher replaces DefaultPasswordHasher until the test finishes.
+func useDefaultPasswordHasher(t *testing.T, hasher PasswordHasher) {
+	t.Helper()
+
+	previous := DefaultPasswordHasher
+	DefaultPasswordHasher = hasher
+	t.Cleanup(func() { DefaultPasswordHasher = previous })
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := testArgon2id.Hash([]byte("pa55word1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := regexp.MustCompile(`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`)
+	if !format.Match(hash) {
+		t.Fatalf("got %s; want a PHC string", hash)
+	}
+
+	params, _, _, err := decodeArgon2idHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != testArgon2id {
+		t.Errorf("decoded parameters %+v; want %+v", params, testArgon2id)
+	}
+
+	// Each hash has its own salt.
+	again, err := testArgon2id.Hash([]byte("pa55word1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) == string(hash) {
+		t.Error("hashing the same password twice gave the same hash")
+	}
+
+	for _, tc := range []struct {
+		plaintext string
+		want      bool
+	}{
+		{"pa55word1234", true},
+		{"pa55word1235", false},
+		{"", false},
+	} {
+		match, err := testArgon2id.Matches(hash, []byte(tc.plaintext))
+		if err != nil || match != tc.want {
+			t.Errorf("matching %q: got %t, %v; want %t", tc.plaintext, match, err, tc.want)
+		}
+	}
+}
+
+func TestArgon2idMatchesParametersInHash(t *testing.T) {
+	// Build a hash by hand with parameters which differ from the hasher's, to check
+	// that the ones recorded in the hash are the ones used.
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("pa55word1234"), salt, 2, 128, 2, 24)
+	hash := fmt.Sprintf("$argon2id$v=19$m=128,t=2,p=2$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	match, err := testArgon2id.Matches([]byte(hash), []byte("pa55word1234"))
+	if err != nil || !match {
+		t.Errorf("got %t, %v; want a match", match, err)
+	}
+
+	params, _, _, err := decodeArgon2idHash([]byte(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Argon2idHasher{Memory: 128, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 24}
+	if params != want {
+		t.Errorf("decoded parameters %+v; want %+v", params, want)
+	}
+	if !testArgon2id.NeedsRehash([]byte(hash)) {
+		t.Error("a hash with other parameters doesn't need rehashing")
+	}
+	if want.NeedsRehash([]byte(hash)) {
+		t.Error("a hash with the same parameters needs rehashing")
+	}
+}
+
+func TestDecodeArgon2idHashErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"bcrypt", "$2a$04$abcdefghijklmnopqrstuu"},
+		{"argon2i", "$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$a2V5"},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ"},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$a2V5"},
+		{"bad version", "$argon2id$version$m=64,t=1,p=1$c29tZXNhbHQ$a2V5"},
+		{"bad parameters", "$argon2id$v=19$t=1,m=64,p=1$c29tZXNhbHQ$a2V5"},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5"},
+		{"padded key", "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$a2V5MQ=="},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2idHash([]byte(tc.hash)); err == nil {
+				t.Error("decoded a malformed hash")
+			}
+			if _, err := testArgon2id.Matches([]byte(tc.hash), []byte("pa55word1234")); err == nil {
+				t.Error("matched against a malformed hash")
+			}
+			if !testArgon2id.NeedsRehash([]byte(tc.hash)) {
+				t.Error("a malformed hash doesn't need rehashing")
+			}
+		})
+	}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := BcryptHasher{Cost: bcrypt.MinCost}
+
+	hash, err := hasher.Hash([]byte("pa55word1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if match, err := hasher.Matches(hash, []byte("pa55word1234")); err != nil || !match {
+		t.Errorf("matching the password: got %t, %v", match, err)
+	}
+	if match, err := hasher.Matches(hash, []byte("pa55word1235")); err != nil || match {
+		t.Errorf("matching another password: got %t, %v", match, err)
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Error("a hash with the same cost needs rehashing")
+	}
+	if !(BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Error("a hash with another cost doesn't need rehashing")
+	}
+}
+
+func TestPasswordHasherFor(t *testing.T) {
+	// Hashes stored before the format became pluggable are plain bcrypt, and have to
+	// keep working.
+	legacy, err := bcrypt.GenerateFromPassword([]byte("pa55word1234"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon, err := testArgon2id.Hash([]byte("pa55word1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash []byte
+		want PasswordHasher
+	}{
+		{"legacy bcrypt", legacy, BcryptHasher{}},
+		{"bcrypt 2b", []byte("$2b$12$abcdefghijklmnopqrstuu"), BcryptHasher{}},
+		{"bcrypt 2y", []byte("$2y$12$abcdefghijklmnopqrstuu"), BcryptHasher{}},
+		{"argon2id", argon, Argon2idHasher{}},
+		{"empty", nil, nil},
+		{"plaintext", []byte("pa55word1234"), nil},
+		{"scrypt", []byte("$scrypt$ln=16,r=8,p=1$c29tZXNhbHQ$a2V5"), nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hasher, err := passwordHasherFor(tc.hash)
+			if tc.want == nil {
+				if !errors.Is(err, ErrUnknownPasswordHash) {
+					t.Errorf("got %v, %v; want %v", hasher, err, ErrUnknownPasswordHash)
+				}
+				return
+			}
+			if err != nil || hasher != tc.want {
+				t.Errorf("got %#v, %v; want %#v", hasher, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	useDefaultPasswordHasher(t, BcryptHasher{Cost: bcrypt.MinCost})
+
+	var p password
+	if err := p.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if p.NeedsRehash() {
+		t.Error("a hash made with the default hasher needs rehashing")
+	}
+
+	// Raising the cost, or switching algorithm, means that existing hashes are
+	// rehashed, but they can still be checked in the meantime.
+	tests := []struct {
+		name   string
+		hasher PasswordHasher
+	}{
+		{"higher bcrypt cost", BcryptHasher{Cost: bcrypt.MinCost + 1}},
+		{"argon2id", testArgon2id},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useDefaultPasswordHasher(t, tc.hasher)
+
+			if !p.NeedsRehash() {
+				t.Error("the old hash doesn't need rehashing")
+			}
+			if match, err := p.Matches("pa55word1234"); err != nil || !match {
+				t.Errorf("matching the old hash: got %t, %v", match, err)
+			}
+
+			var rehashed password
+			if err := rehashed.Set("pa55word1234"); err != nil {
+				t.Fatal(err)
+			}
+			if rehashed.NeedsRehash() {
+				t.Error("the new hash needs rehashing")
+			}
+			if match, err := rehashed.Matches("pa55word1234"); err != nil || !match {
+				t.Errorf("matching the new hash: got %t, %v", match, err)
+			}
+		})
+	}
+
+	var unknown password
+	unknown.hash = []byte("pa55word1234")
+	if _, err := unknown.Matches("pa55word1234"); !errors.Is(err, ErrUnknownPasswordHash) {
+		t.Errorf("matching an unknown hash: got %v; want %v", err, ErrUnknownPasswordHash)
+	}
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyKey holds a client-supplied Idempotency-Key together with a hash of the
+// request that it was first used with and, once the request has finished, the response
+// that was sent. A Status of zero means that the original request is still in flight.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash []byte
+	Status      int
+	Headers     map[string][]string
+	Body        []byte
+	Expiry      time.Time
+}
+
+// Define the IdempotencyKeyModel type.
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Reserve() claims an idempotency key for a user before the request is processed. If
+// the key was free (or its previous use has expired) it is stored with the given
+// request hash and Reserve() returns nil. Otherwise the existing record is returned so
+// that the caller can decide whether to replay it or reject the request.
+func (m IdempotencyKeyModel) Reserve(userID int64, key string, requestHash []byte, ttl time.Duration) (*IdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Clear out an expired record for the same key first, so that it can be reused.
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND expiry <= NOW()`
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// ON CONFLICT DO NOTHING means that only one of two concurrent requests with the
+	// same key can win the insert. The loser gets sql.ErrNoRows from the RETURNING
+	// clause and falls through to reading the winner's record below.
+	query = `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		RETURNING user_id`
+
+	var inserted int64
+	err = m.DB.QueryRowContext(ctx, query, userID, key, requestHash, time.Now().Add(ttl)).Scan(&inserted)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	query = `
+		SELECT request_hash, status, headers, body, expiry
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	existing := IdempotencyKey{UserID: userID, Key: key}
+	var headers []byte
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key).Scan(
+		&existing.RequestHash,
+		&existing.Status,
+		&headers,
+		&existing.Body,
+		&existing.Expiry,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(headers, &existing.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// Complete() stores the response for a reserved key, so that later retries can be
+// answered with exactly the same response.
+func (m IdempotencyKeyModel) Complete(idempotencyKey *IdempotencyKey) error {
+	headers, err := json.Marshal(idempotencyKey.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, headers = $2, body = $3
+		WHERE user_id = $4 AND key = $5`
+
+	args := []interface{}{idempotencyKey.Status, headers, idempotencyKey.Body, idempotencyKey.UserID, idempotencyKey.Key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Release() deletes a reserved key. It's used when the original request failed with a
+// server error, so that the client is free to retry it with the same key.
+func (m IdempotencyKeyModel) Release(userID int64, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired() deletes the idempotency keys which have expired, and returns how
+// many there were.
+func (m IdempotencyKeyModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expiry <= NOW()`
+
+	return deleteRows(m.DB, query)
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCLoginState is what we need to remember between sending a user to the identity
+// provider and them coming back to the callback: the PKCE code verifier and the nonce
+// that the ID token must contain.
+type OIDCLoginState struct {
+	State        string
+	CodeVerifier string
+	Nonce        string
+	Expiry       time.Time
+}
+
+// Define the OIDCLoginStateModel type.
+type OIDCLoginStateModel struct {
+	DB *sql.DB
+}
+
+// Insert() stores a new login state.
+func (m OIDCLoginStateModel) Insert(state *OIDCLoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state, code_verifier, nonce, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, state.State, state.CodeVerifier, state.Nonce, state.Expiry)
+	return err
+}
+
+// Consume() deletes an unexpired login state and returns it, so that each state can
+// only be used once. It returns ErrRecordNotFound if there's no such state.
+func (m OIDCLoginStateModel) Consume(state string) (*OIDCLoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = $1 AND expiry > NOW()
+		RETURNING state, code_verifier, nonce, expiry`
+
+	var loginState OIDCLoginState
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, state).Scan(
+		&loginState.State,
+		&loginState.CodeVerifier,
+		&loginState.Nonce,
+		&loginState.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &loginState, nil
+}
+
+// DeleteExpired() deletes the login states which expired without being used, and
+// returns how many there were.
+func (m OIDCLoginStateModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE expiry <= NOW()`
+
+	return deleteRows(m.DB, query)
+}
+
+// Define the IdentityModel type.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUser() returns the user linked to an identity at a provider, or ErrRecordNotFound
+// if the identity hasn't been linked to anyone yet.
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.researcher_id, users.version
+		FROM users
+		INNER JOIN user_identities ON user_identities.user_id = users.id
+		WHERE user_identities.provider = $1 AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.ResearcherID,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Link() links an identity at a provider to a user.
+func (m IdentityModel) Link(provider, subject string, userID int64) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id)
+		VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, provider, subject, userID)
+	return translateError(err)
+}
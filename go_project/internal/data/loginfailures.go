@@ -0,0 +1,212 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The kinds of key that failed logins are counted against.
+const (
+	LoginFailureEmail = "email"
+	LoginFailureIP    = "ip"
+)
+
+// LoginFailure is the number of consecutive failed logins for one email address or IP
+// address, and when the most recent one happened.
+type LoginFailure struct {
+	Kind          string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+}
+
+// LoginThrottle describes how failed logins are slowed down. The first FreeAttempts
+// failures cost nothing; after that each failure doubles the time that the client must
+// wait before trying again, starting at BaseDelay and capped at MaxDelay. Once there
+// have been MaxFailures failures the key is locked out until Lockout has passed since
+// the last one. Failures are forgotten once Lockout has passed without another one.
+type LoginThrottle struct {
+	FreeAttempts int
+	MaxFailures  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Lockout      time.Duration
+}
+
+// Wait returns how long the client must wait before its next login attempt given the
+// failures recorded so far, and whether that's because the key is locked out.
+func (t LoginThrottle) Wait(f *LoginFailure, now time.Time) (time.Duration, bool) {
+	if f.Failures == 0 || now.Sub(f.LastFailureAt) >= t.Lockout {
+		return 0, false
+	}
+
+	if f.Failures >= t.MaxFailures {
+		return f.LastFailureAt.Add(t.Lockout).Sub(now), true
+	}
+
+	if f.Failures < t.FreeAttempts {
+		return 0, false
+	}
+
+	delay := t.BaseDelay
+	for i := t.FreeAttempts; i < f.Failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+
+	wait := f.LastFailureAt.Add(delay).Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, false
+}
+
+// Define the LoginFailureModel type.
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// LoginKey is one of the keys that a login attempt is counted against, together with
+// the throttling rules for it.
+type LoginKey struct {
+	Kind     string
+	Key      string
+	Throttle LoginThrottle
+}
+
+// LoginWait is how long the client must wait before trying a key again, and whether
+// that's because the key is locked out.
+type LoginWait struct {
+	Wait   time.Duration
+	Locked bool
+}
+
+// Attempt() checks a login attempt against the failures recorded for each of its keys
+// and, if none of them has to wait, counts it as a failure against all of them before
+// the credentials have been checked. The waits are returned in the same order as the
+// keys. If any key has to wait, nothing is counted: only attempts whose credentials are
+// actually checked count, so that sending a bad login now and then can't keep an
+// account locked out for ever, and the owner trying their password during a lockout
+// doesn't make it any longer.
+//
+// The rows for the keys are locked while this happens, so however many attempts are
+// made at the same time, each one sees all of the ones before it. Callers must always
+// pass the kinds of key in the same order, so that two attempts can't each hold a lock
+// that the other is waiting for. Attempts which turn out to be right are taken back with
+// Refund().
+func (m LoginFailureModel) Attempt(keys ...LoginKey) ([]LoginWait, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	waits := make([]LoginWait, len(keys))
+	throttled := false
+
+	for i, key := range keys {
+		// Make sure that there's a row to lock, even for a key with no failures yet.
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO login_failures (kind, key, failures, last_failure_at)
+			VALUES ($1, $2, 0, NOW())
+			ON CONFLICT (kind, key) DO NOTHING`, key.Kind, key.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		failure := &LoginFailure{Kind: key.Kind, Key: key.Key}
+		err = tx.QueryRowContext(ctx, `
+			SELECT failures, last_failure_at
+			FROM login_failures
+			WHERE kind = $1 AND key = $2
+			FOR UPDATE`, key.Kind, key.Key).Scan(&failure.Failures, &failure.LastFailureAt)
+		if err != nil {
+			return nil, err
+		}
+
+		waits[i].Wait, waits[i].Locked = key.Throttle.Wait(failure, now)
+		if waits[i].Wait > 0 {
+			throttled = true
+		}
+	}
+
+	// Rolling back also removes any rows that were only added to be locked.
+	if throttled {
+		return waits, nil
+	}
+
+	// If the previous failure was longer ago than the lockout, the count starts again
+	// from one.
+	query := `
+		UPDATE login_failures
+		SET failures = CASE
+				WHEN last_failure_at < $3 THEN 1
+				ELSE failures + 1
+			END,
+			previous_failure_at = CASE
+				WHEN last_failure_at < $3 OR failures = 0 THEN NULL
+				ELSE last_failure_at
+			END,
+			last_failure_at = NOW()
+		WHERE kind = $1 AND key = $2`
+
+	for _, key := range keys {
+		_, err := tx.ExecContext(ctx, query, key.Kind, key.Key, now.Add(-key.Throttle.Lockout))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return waits, nil
+}
+
+// Refund() takes back an attempt counted by Attempt(), once the credentials have turned
+// out to be right.
+func (m LoginFailureModel) Refund(kind, key string) error {
+	query := `
+		UPDATE login_failures
+		SET failures = failures - 1,
+			last_failure_at = COALESCE(previous_failure_at, last_failure_at),
+			previous_failure_at = NULL
+		WHERE kind = $1 AND key = $2 AND failures > 0`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, kind, key)
+	return err
+}
+
+// Reset() forgets the failures recorded for a key, after a successful login.
+func (m LoginFailureModel) Reset(kind, key string) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE kind = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, kind, key)
+	return err
+}
+
+// DeleteStale() deletes the failed logins which are old enough to have been forgotten
+// (see LoginThrottle), and returns how many there were.
+func (m LoginFailureModel) DeleteStale(window time.Duration) (int64, error) {
+	query := `
+		DELETE FROM login_failures
+		WHERE last_failure_at < $1`
+
+	return deleteRows(m.DB, query, time.Now().Add(-window))
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleWait(t *testing.T) {
+	throttle := LoginThrottle{
+		FreeAttempts: 3,
+		MaxFailures:  10,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		Lockout:      15 * time.Minute,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		failures   int
+		ago        time.Duration
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{"no failures", 0, 0, 0, false},
+		{"free attempts", 2, 0, 0, false},
+		{"first backoff", 3, 0, time.Second, false},
+		{"doubled backoff", 5, 0, 4 * time.Second, false},
+		{"backoff partly waited", 5, time.Second, 3 * time.Second, false},
+		{"backoff waited", 3, 2 * time.Second, 0, false},
+		{"backoff capped", 9, 0, time.Minute, false},
+		{"locked out", 10, 5 * time.Minute, 10 * time.Minute, true},
+		{"lockout over", 10, 15 * time.Minute, 0, false},
+		{"failures forgotten", 5, time.Hour, 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &LoginFailure{Failures: tc.failures, LastFailureAt: now.Add(-tc.ago)}
+			wait, locked := throttle.Wait(f, now)
+			if wait != tc.wantWait || locked != tc.wantLocked {
+				t.Errorf("got %v, %t; want %v, %t", wait, locked, tc.wantWait, tc.wantLocked)
+			}
+		})
+	}
+}
+
+// loginFailures returns the failures recorded for a key, and when the last one was.
+func loginFailures(t *testing.T, m Models, kind, key string) (int, time.Time) {
+	t.Helper()
+
+	var failures int
+	var last time.Time
+	err := m.db.QueryRow(`SELECT failures, last_failure_at FROM login_failures WHERE kind = $1 AND key = $2`, kind, key).Scan(&failures, &last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return failures, last
+}
+
+func TestLoginFailureAttempt(t *testing.T) {
+	m := NewModels(newTestDB(t))
+
+	throttle := LoginThrottle{FreeAttempts: 2, MaxFailures: 3, BaseDelay: time.Hour, MaxDelay: time.Hour, Lockout: 24 * time.Hour}
+	email := LoginKey{Kind: LoginFailureEmail, Key: fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()), Throttle: throttle}
+	ip := LoginKey{Kind: LoginFailureIP, Key: fmt.Sprintf("test-%d", time.Now().UnixNano()), Throttle: throttle}
+	t.Cleanup(func() {
+		m.LoginFailures.Reset(email.Kind, email.Key)
+		m.LoginFailures.Reset(ip.Kind, ip.Key)
+	})
+
+	attempt := func(keys ...LoginKey) []LoginWait {
+		t.Helper()
+		waits, err := m.LoginFailures.Attempt(keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return waits
+	}
+
+	// The free attempts are counted as failures up front.
+	for i := 0; i < 2; i++ {
+		if waits := attempt(email); waits[0].Wait != 0 {
+			t.Fatalf("attempt %d: got wait %v; want none", i+1, waits[0].Wait)
+		}
+	}
+	if failures, _ := loginFailures(t, m, email.Kind, email.Key); failures != 2 {
+		t.Fatalf("got %d failures; want 2", failures)
+	}
+
+	// A refund takes back one attempt, leaving the one before it.
+	if err := m.LoginFailures.Refund(email.Kind, email.Key); err != nil {
+		t.Fatal(err)
+	}
+	if failures, _ := loginFailures(t, m, email.Kind, email.Key); failures != 1 {
+		t.Fatalf("after a refund: got %d failures; want 1", failures)
+	}
+	attempt(email)
+
+	// Now the client has to wait, and an attempt made anyway isn't counted and doesn't
+	// make the wait any longer.
+	_, before := loginFailures(t, m, email.Kind, email.Key)
+	waits := attempt(email, ip)
+	if waits[0].Wait <= 0 || waits[0].Locked {
+		t.Errorf("got %+v; want a backoff", waits[0])
+	}
+	if waits[1].Wait != 0 {
+		t.Errorf("got %+v for the IP address; want no wait", waits[1])
+	}
+	failures, after := loginFailures(t, m, email.Kind, email.Key)
+	if failures != 2 || !after.Equal(before) {
+		t.Errorf("throttled attempt was counted: got %d failures at %v; want 2 at %v", failures, after, before)
+	}
+
+	// Nor is it counted against the other keys.
+	if failures, _ := loginFailures(t, m, ip.Kind, ip.Key); failures != 0 {
+		t.Errorf("throttled attempt was counted against the IP address: got %d failures", failures)
+	}
+
+	// Once the key is locked out, attempts still aren't counted.
+	_, err := m.db.Exec(`UPDATE login_failures SET failures = 3 WHERE kind = $1 AND key = $2`, email.Kind, email.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waits := attempt(email); !waits[0].Locked {
+		t.Errorf("got %+v; want locked out", waits[0])
+	}
+	if failures, last := loginFailures(t, m, email.Kind, email.Key); failures != 3 || !last.Equal(before) {
+		t.Errorf("locked out attempt was counted: got %d failures at %v; want 3 at %v", failures, last, before)
+	}
+
+	// A reset forgets everything.
+	if err := m.LoginFailures.Reset(email.Kind, email.Key); err != nil {
+		t.Fatal(err)
+	}
+	if waits := attempt(email); waits[0].Wait != 0 {
+		t.Errorf("after a reset: got wait %v; want none", waits[0].Wait)
+	}
+	if failures, _ := loginFailures(t, m, email.Kind, email.Key); failures != 1 {
+		t.Errorf("after a reset: got %d failures; want 1", failures)
+	}
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// runMigration runs the named migration file from the migrations directory.
+func runMigration(t *testing.T, tx *sql.Tx, name string) {
+	t.Helper()
+
+	script, err := os.ReadFile(filepath.Join("..", "..", "migrations", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(string(script)); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+// sortedStrings runs a query returning a single text column, and returns the values
+// sorted.
+func sortedStrings(t *testing.T, tx *sql.Tx, query string, args ...interface{}) []string {
+	t.Helper()
+
+	values, err := queryStrings(tx, query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// TestNamespacePermissionsMigration runs migration 000008 up and back down against
+// the tables as they were before it, in a schema of their own which is thrown away
+// when the transaction is rolled back.
+func TestNamespacePermissionsMigration(t *testing.T) {
+	db := newTestDB(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	schema := fmt.Sprintf("migration_test_%d", time.Now().UnixNano())
+	_, err = tx.Exec(fmt.Sprintf(`
+		CREATE SCHEMA %[1]s;
+		SET LOCAL search_path TO %[1]s;
+
+		CREATE TABLE users (id bigserial PRIMARY KEY, email text NOT NULL);
+		CREATE TABLE permissions (id bigserial PRIMARY KEY, code text NOT NULL);
+		CREATE TABLE users_permissions (
+			user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+			permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+			PRIMARY KEY (user_id, permission_id)
+		);
+
+		INSERT INTO permissions (code) VALUES ('movies:read'), ('movies:write');
+		INSERT INTO users (email) VALUES ('reader@example.com'), ('writer@example.com'), ('nobody@example.com');
+
+		INSERT INTO users_permissions
+		SELECT users.id, permissions.id FROM users, permissions
+		WHERE (users.email = 'reader@example.com' AND permissions.code = 'movies:read')
+		OR (users.email = 'writer@example.com' AND permissions.code IN ('movies:read', 'movies:write'));`, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userRoles := func() []string {
+		return sortedStrings(t, tx, `
+			SELECT users.email || ' ' || roles.name
+			FROM users_roles
+			INNER JOIN users ON users.id = users_roles.user_id
+			INNER JOIN roles ON roles.id = users_roles.role_id`)
+	}
+	rolePermissions := func(role string) []string {
+		return sortedStrings(t, tx, `
+			SELECT permissions.code
+			FROM roles_permissions
+			INNER JOIN roles ON roles.id = roles_permissions.role_id
+			INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+			WHERE roles.name = $1`, role)
+	}
+	userPermissions := func() []string {
+		return sortedStrings(t, tx, `
+			SELECT users.email || ' ' || permissions.code
+			FROM users_permissions
+			INNER JOIN users ON users.id = users_permissions.user_id
+			INNER JOIN permissions ON permissions.id = users_permissions.permission_id`)
+	}
+
+	runMigration(t, tx, "000008_namespace_permissions_and_add_roles.up.sql")
+
+	t.Run("up", func(t *testing.T) {
+		codes := sortedStrings(t, tx, `SELECT code FROM permissions`)
+		want := []string{"artifacts:read", "artifacts:write", "expeditions:read", "expeditions:write", "researchers:read", "researchers:write", "users:admin"}
+		if !reflect.DeepEqual(codes, want) {
+			t.Errorf("got codes %v; want %v", codes, want)
+		}
+
+		tests := []struct {
+			role string
+			want []string
+		}{
+			{"viewer", []string{"artifacts:read", "expeditions:read", "researchers:read"}},
+			{"curator", []string{"artifacts:read", "artifacts:write", "expeditions:read", "expeditions:write", "researchers:read", "researchers:write"}},
+			{"admin", want},
+		}
+		for _, tc := range tests {
+			if got := rolePermissions(tc.role); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %s permissions %v; want %v", tc.role, got, tc.want)
+			}
+		}
+
+		// The old grants become roles, and the old codes are gone together with the
+		// grants that used them.
+		wantRoles := []string{"reader@example.com viewer", "writer@example.com curator", "writer@example.com viewer"}
+		if got := userRoles(); !reflect.DeepEqual(got, wantRoles) {
+			t.Errorf("got roles %v; want %v", got, wantRoles)
+		}
+		if got := userPermissions(); len(got) != 0 {
+			t.Errorf("got direct grants %v; want none", got)
+		}
+
+		_, err := tx.Exec(`SAVEPOINT duplicate_code`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec(`INSERT INTO permissions (code) VALUES ('users:admin')`); err == nil {
+			t.Error("inserted a duplicate permission code")
+		}
+		if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT duplicate_code`); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	runMigration(t, tx, "000008_namespace_permissions_and_add_roles.down.sql")
+
+	t.Run("down", func(t *testing.T) {
+		codes := sortedStrings(t, tx, `SELECT code FROM permissions`)
+		if want := []string{"movies:read", "movies:write"}; !reflect.DeepEqual(codes, want) {
+			t.Errorf("got codes %v; want %v", codes, want)
+		}
+
+		want := []string{"reader@example.com movies:read", "writer@example.com movies:read", "writer@example.com movies:write"}
+		if got := userPermissions(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got grants %v; want %v", got, want)
+		}
+
+		tables := sortedStrings(t, tx, `SELECT table_name::text FROM information_schema.tables WHERE table_schema = $1`, schema)
+		if want := []string{"permissions", "users", "users_permissions"}; !reflect.DeepEqual(tables, want) {
+			t.Errorf("got tables %v; want %v", tables, want)
+		}
+	})
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -21,6 +23,7 @@ type Models struct {
 		Insert(researcher *Researcher) error
 		Get(id int64) (*Researcher, error)
 		GetAll(name string, specialization string, filters Filters) ([]*Researcher, Metadata, error)
+		StreamAll(name string, specialization string, filters Filters, fn func(researcher *Researcher) error) error
 		Update(researcher *Researcher) error
 		Delete(id int64) error
 	}
@@ -29,33 +32,136 @@ type Models struct {
 		Insert(expedition *Expedition) error
 		Get(id int64) (*Expedition, error)
 		GetAll(title string, expeditionYear int, filters Filters) ([]*Expedition, Metadata, error)
+		StreamAll(title string, expeditionYear int, filters Filters, fn func(expedition *Expedition) error) error
 		Update(expedition *Expedition) error
 		Delete(id int64) error
 		GetExpeditionsByResearcher(researcher_id int64, title string, expeditionYear int, filters Filters) ([]*Expedition, Metadata, error)
+		GetStats(title string, expeditionYear int, filters StatsFilters) ([]*Stat, error)
 	}
 
 	Artifacts interface {
 		Insert(artifact *Artifact) error
+		InsertMany(artifacts []*Artifact) error
 		Get(id int64) (*Artifact, error)
 		GetAll(title string, age int, filters Filters) ([]*Artifact, Metadata, error)
+		StreamAll(title string, age int, filters Filters, fn func(artifact *Artifact) error) error
 		Update(artifact *Artifact) error
 		Delete(id int64) error
+		Restore(artifact *Artifact) error
 		GetArtifactsByResearcher(researcher_id int64, title string, age int, filters Filters) ([]*Artifact, Metadata, error)
+		GetStats(title string, age int, filters StatsFilters) ([]*Stat, error)
+		GetHistory(id int64, filters Filters) ([]*ArtifactVersion, Metadata, error)
+		GetVersion(id int64, version int) (*ArtifactVersion, error)
+		GetLatestVersion(id int64) (*ArtifactVersion, error)
+		GetAsOf(id int64, t time.Time) (*ArtifactVersion, error)
 	}
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Users           UserModel
+	Tokens          TokenModel
+	Permissions     PermissionModel
+	IdempotencyKeys IdempotencyKeyModel
+	AuditEvents     AuditEventModel
+	APIKeys         APIKeyModel
+	LoginFailures   LoginFailureModel
+	TwoFactor       TwoFactorModel
+	OIDCStates      OIDCLoginStateModel
+	Identities      IdentityModel
+	Webhooks        WebhookModel
+	Deliveries      WebhookDeliveryModel
+
+	// db is the connection pool that the models were created with. It's used to start
+	// new transactions with Begin().
+	db *sql.DB
+	// tx and actor are the transaction and audit actor which the domain models are
+	// bound to, if any. They're kept here so that WithTx() and As() can be combined.
+	tx    *sql.Tx
+	actor *Actor
+}
+
+// queryer is the set of query methods shared by *sql.DB and *sql.Tx, which lets a model
+// run the same SQL whether or not it is bound to a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// deleteRows() runs a DELETE statement and returns the number of rows that it deleted.
+// It's used by the clean-up methods, whose callers only care about the counts.
+func deleteRows(db queryer, query string, args ...interface{}) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // Create a helper function which returns a Models instance containing the mock models
 // only.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Researchers: ResearcherModel{DB: db},
-		Expeditions: ExpeditionModel{DB: db},
-		Artifacts:   ArtifactModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Researchers:     ResearcherModel{DB: db},
+		Expeditions:     ExpeditionModel{DB: db},
+		Artifacts:       ArtifactModel{DB: db},
+		Permissions:     PermissionModel{DB: db},
+		Tokens:          TokenModel{DB: db},
+		Users:           UserModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		AuditEvents:     AuditEventModel{DB: db},
+		APIKeys:         APIKeyModel{DB: db},
+		LoginFailures:   LoginFailureModel{DB: db},
+		TwoFactor:       TwoFactorModel{DB: db},
+		OIDCStates:      OIDCLoginStateModel{DB: db},
+		Identities:      IdentityModel{DB: db},
+		Webhooks:        WebhookModel{DB: db},
+		Deliveries:      WebhookDeliveryModel{DB: db},
+		db:              db,
+	}
+}
+
+// Begin() starts a new database transaction. Use WithTx() to get a set of models which
+// run inside it, and remember that the caller is responsible for calling Commit() or
+// Rollback() on the returned transaction.
+func (m Models) Begin() (*sql.Tx, error) {
+	return m.db.BeginTx(context.Background(), nil)
+}
+
+// WithTx() returns a copy of the models in which the researcher, expedition, artifact
+// and token models run all of their queries inside the given transaction, and the user
+// and permission models make their changes inside it.
+func (m Models) WithTx(tx *sql.Tx) Models {
+	m.tx = tx
+	return m.bind()
+}
+
+// As() returns a copy of the models in which every change made through the researcher,
+// expedition, artifact, user and permission models is recorded in the audit trail
+// against the given actor. Passing nil records them against the application itself.
+func (m Models) As(actor *Actor) Models {
+	m.actor = actor
+	return m.bind()
+}
+
+// bind() rebuilds the models which depend on the current transaction and actor. Other
+// implementations of the domain model interfaces, such as the mocks used in the handler
+// tests, are left as they are.
+func (m Models) bind() Models {
+	if _, ok := m.Researchers.(ResearcherModel); ok {
+		m.Researchers = ResearcherModel{DB: m.db, tx: m.tx, actor: m.actor}
+	}
+	if _, ok := m.Expeditions.(ExpeditionModel); ok {
+		m.Expeditions = ExpeditionModel{DB: m.db, tx: m.tx, actor: m.actor}
+	}
+	if _, ok := m.Artifacts.(ArtifactModel); ok {
+		m.Artifacts = ArtifactModel{DB: m.db, tx: m.tx, actor: m.actor}
 	}
+	m.Tokens.tx = m.tx
+	m.Users.tx = m.tx
+	m.Users.actor = m.actor
+	m.Permissions.tx = m.tx
+	m.Permissions.actor = m.actor
+	return m
 }
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"goproject/internal/validator"
+)
+
+// PasswordPolicy holds the rules that new passwords must follow on top of the length
+// checks in ValidatePasswordPlaintext(). The zero value only checks the password
+// against the user's own details.
+type PasswordPolicy struct {
+	// MinScore is the lowest strength score (0 to 4, see PasswordScore()) that a
+	// password may have.
+	MinScore int
+	// Breached is the list of passwords known from data breaches. Checking is skipped
+	// if it's nil.
+	Breached *BreachedPasswords
+}
+
+// Validate checks a new password against the policy, adding any problem to the
+// validator under the given key. The user is used to reject passwords containing their
+// name or email address, so their Name and Email should already be set. Nothing is
+// checked if there's already an error for the key (for example because the password is
+// too long), so the client only gets one message per field. An error is only returned
+// if the breached password list couldn't be read.
+func (p *PasswordPolicy) Validate(v *validator.Validator, key, plaintext string, user *User) error {
+	if _, exists := v.Errors[key]; exists {
+		return nil
+	}
+
+	v.Check(!containsPersonalDetails(plaintext, user), key, "must not contain your name or email address")
+	v.Check(PasswordScore(plaintext, personalWords(user)...) >= p.MinScore, key, "is too easy to guess, try a longer password or one that isn't based on common words or patterns")
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(plaintext)
+		if err != nil {
+			return err
+		}
+		v.Check(!breached, key, "has appeared in a data breach and must not be used")
+	}
+	return nil
+}
+
+// personalWords returns the parts of the user's name and email address which are long
+// enough to be worth looking for in a password.
+func personalWords(user *User) []string {
+	if user == nil {
+		return nil
+	}
+
+	local, domain, _ := strings.Cut(user.Email, "@")
+	domain, _, _ = strings.Cut(domain, ".")
+
+	var words []string
+	for _, word := range append(strings.Fields(user.Name), local, domain) {
+		word = strings.ToLower(word)
+		if len(word) >= 3 {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// containsPersonalDetails reports whether the password contains the user's name or the
+// local part of their email address, ignoring case.
+func containsPersonalDetails(plaintext string, user *User) bool {
+	if user == nil {
+		return false
+	}
+
+	lower := strings.ToLower(plaintext)
+	local, _, _ := strings.Cut(strings.ToLower(user.Email), "@")
+
+	for _, word := range append(strings.Fields(strings.ToLower(user.Name)), local) {
+		if len(word) >= 3 && strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPasswords are some of the most common passwords and password fragments, most
+// common first. A password built from them is only as strong as its position in this
+// list, however long it is.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "monkey", "dragon", "football",
+	"baseball", "iloveyou", "admin", "login", "master", "sunshine", "shadow", "princess",
+	"starwars", "whatever", "trustno1", "superman", "batman", "hello", "freedom",
+	"michael", "jennifer", "charlie", "secret", "summer", "winter", "spring", "autumn",
+	"flower", "cheese", "computer", "internet", "soccer", "hockey", "killer", "pepper",
+	"ginger", "hunter", "ranger", "buster", "thomas", "robert", "jordan", "harley",
+	"orange", "banana", "apple", "chocolate", "love", "lovely", "angel", "access",
+	"mustang", "passw0rd", "pass", "test", "guest", "root", "user", "default", "changeme",
+	"archive", "research", "artifact", "expedition", "goproject",
+}
+
+// leetSubstitutions undoes the usual character substitutions before looking for
+// common words, so that "p@ssw0rd" is treated the same as "password".
+var leetSubstitutions = strings.NewReplacer(
+	"4", "a", "@", "a", "8", "b", "3", "e", "6", "g", "1", "i", "!", "i",
+	"0", "o", "5", "s", "$", "s", "7", "t", "+", "t", "2", "z",
+)
+
+// keyboardRows are the rows of a QWERTY keyboard, used to spot walks along the keys
+// such as "asdfgh".
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// PasswordScore estimates how hard a password is to guess on a scale from 0 (trivial)
+// to 4 (strong), in the same spirit as zxcvbn. Rather than just counting character
+// classes, it splits the password into the patterns that attackers try first (common
+// words, repeated characters, sequences and keyboard walks), estimates the number of
+// guesses needed for each piece, and adds them up. Any extra words, such as parts of
+// the user's name, are treated as the most common words of all.
+func PasswordScore(plaintext string, extraWords ...string) int {
+	bits := passwordEntropy(plaintext, extraWords)
+
+	// The thresholds match zxcvbn's: 10^3, 10^6, 10^8 and 10^10 guesses.
+	switch {
+	case bits < 10:
+		return 0
+	case bits < 20:
+		return 1
+	case bits < 26.6:
+		return 2
+	case bits < 33.2:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// passwordEntropy returns the estimated number of guesses for the password as a number
+// of bits. It works greedily from left to right, at each position taking whichever
+// pattern covers the most characters.
+func passwordEntropy(plaintext string, extraWords []string) float64 {
+	runes := []rune(plaintext)
+	lower := []rune(strings.ToLower(plaintext))
+	unleet := []rune(leetSubstitutions.Replace(string(lower)))
+	charBits := math.Log2(float64(charsetSize(runes)))
+
+	// The leet replacer maps single characters to single characters, so positions in
+	// unleet line up with positions in the original password.
+	if len(unleet) != len(lower) {
+		unleet = lower
+	}
+
+	words := append(append([]string{}, extraWords...), commonPasswords...)
+
+	var bits float64
+	for i := 0; i < len(runes); {
+		length, patternBits := 1, charBits
+
+		for rank, word := range words {
+			n := len([]rune(word))
+			if n <= length || i+n > len(lower) {
+				continue
+			}
+			if string(lower[i:i+n]) != word && string(unleet[i:i+n]) != word {
+				continue
+			}
+			length, patternBits = n, math.Log2(float64(rank+2))
+			// Capitalisation and substitutions only add a little.
+			if string(runes[i:i+n]) != word {
+				patternBits++
+			}
+		}
+
+		// Years are one of about two hundred likely values.
+		if i+4 <= len(runes) && length < 4 && isYear(string(runes[i:i+4])) {
+			length, patternBits = 4, math.Log2(200)
+		}
+
+		if n := repeatLength(lower[i:]); n >= 3 && n > length {
+			length, patternBits = n, charBits+math.Log2(float64(n))
+		}
+
+		if n := sequenceLength(lower[i:]); n >= 3 && n > length {
+			length, patternBits = n, math.Log2(float64(len(keyboardRows)*26))+math.Log2(float64(n))
+		}
+
+		bits += patternBits
+		i += length
+	}
+
+	return bits
+}
+
+// isYear reports whether the string is a year from 1900 to 2099.
+func isYear(s string) bool {
+	if !strings.HasPrefix(s, "19") && !strings.HasPrefix(s, "20") {
+		return false
+	}
+	return strings.Trim(s, "0123456789") == ""
+}
+
+// repeatLength returns how many times the first character is repeated at the start of
+// the runes.
+func repeatLength(runes []rune) int {
+	n := 1
+	for n < len(runes) && runes[n] == runes[0] {
+		n++
+	}
+	return n
+}
+
+// sequenceLength returns the length of the sequence at the start of the runes, where a
+// sequence is a run of consecutive characters (such as "abcd" or "9876") or of
+// neighbouring keys on the keyboard (such as "qwer").
+func sequenceLength(runes []rune) int {
+	if len(runes) < 2 {
+		return len(runes)
+	}
+
+	step := func(a, b rune) int {
+		if b-a == 1 || b-a == -1 {
+			return int(b - a)
+		}
+		for _, row := range keyboardRows {
+			i, j := strings.IndexRune(row, a), strings.IndexRune(row, b)
+			if i >= 0 && j >= 0 && (j-i == 1 || j-i == -1) {
+				return 2 * (j - i)
+			}
+		}
+		return 0
+	}
+
+	direction := step(runes[0], runes[1])
+	if direction == 0 {
+		return 1
+	}
+
+	n := 2
+	for n < len(runes) && step(runes[n-1], runes[n]) == direction {
+		n++
+	}
+	return n
+}
+
+// charsetSize returns the size of the smallest set of characters that the password
+// could have been chosen from, going by the classes of character that it uses.
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			size += class.size
+		}
+	}
+	return max(size, 2)
+}
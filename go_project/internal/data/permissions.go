@@ -3,6 +3,9 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 	"github.com/lib/pq" 
 )
@@ -20,22 +23,145 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
-// Define the PermissionModel type.
+// PermissionCache is a small in-process cache of each user's permissions, so that
+// repeated requests from the same user don't all need to hit the database. Entries
+// expire after the configured TTL, and are dropped straight away whenever the user's
+// grants are changed through the PermissionModel. Expired entries are dropped when
+// they're next looked up, and Sweep() drops the ones for users who haven't come back,
+// so that the cache doesn't keep growing. All methods are safe to call on a nil
+// *PermissionCache, which simply behaves as a cache that's always empty.
+type PermissionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[int64]permissionCacheEntry
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// NewPermissionCache returns a new PermissionCache which holds entries for the given
+// amount of time.
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+func (c *PermissionCache) get(userID int64) (Permissions, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiry) {
+		delete(c.entries, userID)
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+func (c *PermissionCache) set(userID int64, permissions Permissions) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[userID] = permissionCacheEntry{permissions: permissions, expiry: time.Now().Add(c.ttl)}
+}
+
+// Invalidate drops the cached permissions for a single user.
+func (c *PermissionCache) Invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
+
+// InvalidateAll empties the cache. It's needed when something changes the permissions
+// of many users at once, such as editing a role.
+func (c *PermissionCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]permissionCacheEntry)
+}
+
+// Sweep drops every expired entry and returns how many it dropped.
+func (c *PermissionCache) Sweep() int64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var swept int64
+	now := time.Now()
+	for userID, entry := range c.entries {
+		if now.After(entry.expiry) {
+			delete(c.entries, userID)
+			swept++
+		}
+	}
+	return swept
+}
+
+// Intersect returns the permissions which are in both p and other.
+func (p Permissions) Intersect(other Permissions) Permissions {
+	permissions := Permissions{}
+	for _, code := range p {
+		if other.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions
+}
+
+// Define the PermissionModel type. Cache is optional; when it's nil every call to
+// GetAllForUser() goes to the database.
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *PermissionCache
+	// tx is set on copies of the model returned by Models.WithTx(), in which case
+	// changes to grants are made inside that transaction.
+	tx *sql.Tx
+	// actor is set on copies of the model returned by Models.As(), and is who changes
+	// are recorded against in the audit trail. Without one, they're recorded against
+	// the application itself.
+	actor *Actor
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user in a
-// Permissions slice. The code in this method should feel very familiar --- it uses the
-// standard pattern that we've already seen before for retrieving multiple data rows in
-// an SQL query.
+// Permissions slice. This includes both the permissions granted to the user directly
+// and the permissions bundled in any roles that the user has been given. Permissions
+// which require two-factor authentication are left out unless the user has enabled it.
+// A disabled user has no permissions at all, so that a deactivated account can't carry
+// on writing with a JWT access token that hasn't expired yet.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
-	query := `
-		SELECT permissions.code
-		FROM permissions
-		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+	query := userPermissionsQuery("$1")
+
+	if permissions, ok := m.Cache.get(userID); ok {
+		return permissions, nil
+	}
 	
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -60,6 +186,8 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+
+	m.Cache.set(userID, permissions)
 	return permissions, nil
 }
 
@@ -70,9 +198,328 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+	return m.changeGrants(userID, "permissions", "user.permissions_granted", query, codes)
+}
+
+// AddRolesForUser() gives a user the named roles, and so every permission that those
+// roles bundle. Like AddForUser() it's variadic, so that several roles can be assigned
+// in a single call.
+func (m PermissionModel) AddRolesForUser(userID int64, roles ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+	return m.changeGrants(userID, "roles", "user.roles_granted", query, roles)
+}
+
+// GrantForUser() gives a user the provided permission codes and roles in a single
+// transaction, so that either all of them are granted or none are.
+func (m PermissionModel) GrantForUser(userID int64, codes []string, roles []string) error {
+	tx := m.tx
+	if tx == nil {
+		var err error
+		tx, err = m.DB.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		// Rolling back after a successful commit is a no-op, so it's safe to always
+		// defer it.
+		defer tx.Rollback()
+	}
+
+	bound := m
+	bound.tx = tx
+
+	if len(codes) > 0 {
+		err := bound.AddForUser(userID, codes...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(roles) > 0 {
+		err := bound.AddRolesForUser(userID, roles...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.tx != nil {
+		return nil
+	}
+
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// The cache was already emptied by AddForUser() and AddRolesForUser(), but a
+	// request could have cached the old grants again before the commit.
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// RemoveForUser() revokes the provided permission codes from a specific user. Only
+// direct grants are removed; permissions that the user gets through a role are left
+// alone.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)`
+	return m.changeGrants(userID, "permissions", "user.permissions_revoked", query, codes)
+}
+
+// RemoveRolesForUser() takes the named roles away from a specific user.
+func (m PermissionModel) RemoveRolesForUser(userID int64, roles ...string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+		AND users_roles.user_id = $1
+		AND roles.name = ANY($2)`
+	return m.changeGrants(userID, "roles", "user.roles_revoked", query, roles)
+}
+
+// changeGrants() runs a query which grants or revokes a user's permissions or roles
+// (depending on kind), and records the user's direct grants of that kind before and
+// after the change in the audit trail.
+func (m PermissionModel) changeGrants(userID int64, kind, action, query string, names []string) error {
+	grantsQuery := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+	if kind == "roles" {
+		grantsQuery = `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`
+	}
+
+	err := audited(m.DB, m.tx, m.actor, func(q queryer) (*AuditEvent, error) {
+		before, err := queryStrings(q, grantsQuery, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_, err = q.ExecContext(ctx, query, userID, pq.Array(names))
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		after, err := queryStrings(q, grantsQuery, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &AuditEvent{
+			Action:     action,
+			TargetType: "user",
+			TargetID:   userID,
+			Changes:    map[string]Change{kind: {From: before, To: after}},
+			Details:    map[string]interface{}{kind: names},
+		}, nil
+	})
+	if err == nil {
+		m.Cache.Invalidate(userID)
+	}
+	return err
+}
+
+// GetDirectForUser() returns only the permission codes that have been granted to a
+// user directly, without the ones that come from their roles.
+func (m PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+
+	return m.queryStrings(query, userID)
+}
+
+// GetRolesForUser() returns the names of the roles that a user has been given.
+func (m PermissionModel) GetRolesForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`
+
+	return m.queryStrings(query, userID)
+}
+
+// GetAll() returns every permission code that exists.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	return m.queryStrings(query)
+}
+
+// GetRequiringTwoFactor() returns the codes of the permissions which only count for
+// users who have two-factor authentication enabled.
+func (m PermissionModel) GetRequiringTwoFactor() (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		WHERE requires_2fa
+		ORDER BY code`
+
+	return m.queryStrings(query)
+}
+
+// SetRequiresTwoFactor() changes whether a permission requires two-factor
+// authentication. This can change the permissions of any number of users, so the whole
+// cache is emptied. It returns ErrRecordNotFound if there's no such permission.
+func (m PermissionModel) SetRequiresTwoFactor(code string, required bool) error {
+	query := `
+		UPDATE permissions
+		SET requires_2fa = $2
+		FROM (SELECT id, requires_2fa FROM permissions WHERE code = $1 FOR UPDATE) AS before
+		WHERE permissions.id = before.id
+		RETURNING permissions.id, before.requires_2fa`
+
+	err := audited(m.DB, nil, m.actor, func(q queryer) (*AuditEvent, error) {
+		var id int64
+		var before bool
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		err := q.QueryRowContext(ctx, query, code, required).Scan(&id, &before)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+			}
+		}
+
+		return &AuditEvent{
+			Action:     "permission.updated",
+			TargetType: "permission",
+			TargetID:   id,
+			Changes:    map[string]Change{"requires_2fa": {From: before, To: required}},
+			Details:    map[string]interface{}{"code": code},
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Cache.InvalidateAll()
+	return nil
+}
+
+// GetAllRoles() returns every role, mapped to the permission codes that it bundles.
+func (m PermissionModel) GetAllRoles() (map[string]Permissions, error) {
+	query := `
+		SELECT roles.name, coalesce(permissions.code, '')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+		ORDER BY roles.name, permissions.code`
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
-}
\ No newline at end of file
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[string]Permissions)
+	for rows.Next() {
+		var name, code string
+		err := rows.Scan(&name, &code)
+		if err != nil {
+			return nil, err
+		}
+
+		// A role without any permissions still needs to appear in the map.
+		if code == "" {
+			roles[name] = Permissions{}
+			continue
+		}
+		roles[name] = append(roles[name], code)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// queryStrings() is a small helper for the queries above which return a single text
+// column.
+func (m PermissionModel) queryStrings(query string, args ...interface{}) ([]string, error) {
+	return queryStrings(m.DB, query, args...)
+}
+
+// queryStrings() runs a query which returns a single text column using the given
+// connection or transaction, and returns the values as a slice.
+func queryStrings(q queryer, query string, args ...interface{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		err := rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// userPermissionsQuery returns a query for the codes of all of a user's permissions,
+// worked out as described for GetAllForUser(). userID is the SQL for the user's ID,
+// which can be a parameter or, when the query is used as a subquery, a column of the
+// outer query.
+func userPermissionsQuery(userID string) string {
+	return fmt.Sprintf(`
+		SELECT permissions.code
+		FROM permissions
+		WHERE permissions.id IN (
+			SELECT users_permissions.permission_id
+			FROM users_permissions
+			WHERE users_permissions.user_id = %[1]s
+			UNION
+			SELECT roles_permissions.permission_id
+			FROM roles_permissions
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+			WHERE users_roles.user_id = %[1]s
+		)
+		AND (NOT permissions.requires_2fa OR EXISTS (
+			SELECT 1 FROM user_totp WHERE user_totp.user_id = %[1]s AND user_totp.confirmed
+		))
+		AND NOT EXISTS (
+			SELECT 1 FROM users WHERE users.id = %[1]s AND users.disabled
+		)`, userID)
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPermissionCacheDropsExpiredEntriesOnGet(t *testing.T) {
+	c := NewPermissionCache(time.Minute)
+	c.set(1, Permissions{"artifacts:read"})
+
+	if _, ok := c.get(1); !ok {
+		t.Fatal("fresh entry not found")
+	}
+
+	c.entries[1] = permissionCacheEntry{expiry: time.Now().Add(-time.Second)}
+
+	if _, ok := c.get(1); ok {
+		t.Fatal("expired entry was returned")
+	}
+	if len(c.entries) != 0 {
+		t.Fatalf("expired entry was kept: %d entries left", len(c.entries))
+	}
+}
+
+func TestPermissionCacheSweep(t *testing.T) {
+	c := NewPermissionCache(time.Minute)
+	c.set(1, Permissions{"artifacts:read"})
+	c.set(2, Permissions{"artifacts:read"})
+	c.entries[3] = permissionCacheEntry{expiry: time.Now().Add(-time.Second)}
+	c.entries[4] = permissionCacheEntry{expiry: time.Now().Add(-time.Hour)}
+
+	if swept := c.Sweep(); swept != 2 {
+		t.Fatalf("got %d swept; want 2", swept)
+	}
+	if len(c.entries) != 2 {
+		t.Fatalf("got %d entries left; want 2", len(c.entries))
+	}
+
+	var nilCache *PermissionCache
+	if swept := nilCache.Sweep(); swept != 0 {
+		t.Fatalf("nil cache swept %d", swept)
+	}
+}
+
+func BenchmarkGetAllForUser(b *testing.B) {
+	db := newTestDB(b)
+	m := NewModels(db)
+	user := newTestUser(b, m)
+
+	if err := m.Permissions.AddRolesForUser(user.ID, "admin"); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bm := range []struct {
+		name  string
+		cache *PermissionCache
+	}{
+		{"cache=off", nil},
+		{"cache=on", NewPermissionCache(time.Minute)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			permissions := m.Permissions
+			permissions.Cache = bm.cache
+
+			for i := 0; i < b.N; i++ {
+				if _, err := permissions.GetAllForUser(user.ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
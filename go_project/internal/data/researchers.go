@@ -43,6 +43,48 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 type ResearcherModel struct {
 	DB *sql.DB
+	// tx is set on copies of the model returned by Models.WithTx(), in which case all
+	// queries run inside that transaction rather than directly on the pool.
+	tx *sql.Tx
+	// actor is set on copies of the model returned by Models.As(), and is who changes
+	// are recorded against in the audit trail. Without one, they're recorded against
+	// the application itself.
+	actor *Actor
+}
+
+// conn() returns the transaction that the model is bound to, if any, or the connection
+// pool otherwise.
+func (s ResearcherModel) conn() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+// lock() fetches a record and locks it until the end of the transaction, so that the
+// audit trail can record what it looked like before it was changed. It returns
+// ErrRecordNotFound if there's no such record.
+func (s ResearcherModel) lock(q queryer, id int64) (*Researcher, error) {
+	query := `
+		SELECT researcher_id, name, specialization, project
+		FROM researcher
+		WHERE researcher_id = $1
+		FOR UPDATE`
+
+	var researcher Researcher
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := q.QueryRowContext(ctx, query, id).Scan(&researcher.Id, &researcher.Name, &researcher.Specialization, &researcher.Project)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &researcher, nil
 }
 
 
@@ -66,7 +108,13 @@ func (s ResearcherModel) Insert(researcher *Researcher) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&researcher.Id, &researcher.Name, &researcher.Specialization, &researcher.Project)
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		err := q.QueryRowContext(ctx, query, args...).Scan(&researcher.Id, &researcher.Name, &researcher.Specialization, &researcher.Project)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("researcher.created", "researcher", int64(researcher.Id), nil, researcher)
+	})
 }
 
 // Add a placeholder method for fetching a specific record from the researchers table.
@@ -85,7 +133,7 @@ func (s ResearcherModel) Get(id int64) (*Researcher, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	row := s.DB.QueryRowContext(ctx, query, id)
+	row := s.conn().QueryRowContext(ctx, query, id)
 	err := row.Scan(&researcher.Id, &researcher.Name, &researcher.Specialization, &researcher.Project)
 	if err != nil {
 		switch {
@@ -111,7 +159,18 @@ func (s ResearcherModel) Update(researcher *Researcher) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&researcher.Id, &researcher.Name, &researcher.Specialization, &researcher.Project)
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		before, err := s.lock(q, int64(researcher.Id))
+		if err != nil {
+			return nil, err
+		}
+
+		err = q.QueryRowContext(ctx, query, args...).Scan(&researcher.Id, &researcher.Name, &researcher.Specialization, &researcher.Project)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("researcher.updated", "researcher", int64(researcher.Id), before, researcher)
+	})
 }
 
 
@@ -128,25 +187,22 @@ func (s ResearcherModel) Delete(id int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := s.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	return audited(s.DB, s.tx, s.actor, func(q queryer) (*AuditEvent, error) {
+		// Locking the record first gets us its final state for the audit trail, and
+		// tells us whether there's a record with the provided ID at all.
+		before, err := s.lock(q, id)
+		if err != nil {
+			return nil, err
+		}
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows
-	// affected by the query.
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	
-	// If no rows were affected, we know that the researchers table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-	return nil
+		_, err = q.ExecContext(ctx, query, id)
+		if err != nil {
+			// Expeditions and artifacts which still refer to the researcher make this
+			// fail with a foreign key violation.
+			return nil, translateError(err)
+		}
+		return newAuditEvent("researcher.deleted", "researcher", id, before, nil)
+	})
 }
 
 
@@ -176,7 +232,7 @@ func (s ResearcherModel) GetAll(name string, specialization string, filters Filt
 
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
 	// containing the result.
-	rows, err := s.DB.QueryContext(ctx, query, args...)
+	rows, err := s.conn().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -219,3 +275,31 @@ func (s ResearcherModel) GetAll(name string, specialization string, filters Filt
 	// If everything went OK, then return the slice of researchers.
 	return researchers, metadata, nil
 }
+
+
+// StreamAll() calls fn for every researcher matching the same filters as GetAll(), in
+// the requested sort order, reading the rows from a server-side cursor.
+func (s ResearcherModel) StreamAll(name string, specialization string, filters Filters, fn func(researcher *Researcher) error) error {
+	query := fmt.Sprintf(`
+		SELECT researcher_id, name, specialization, project
+		FROM researcher
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (to_tsvector('simple', specialization) @@ plainto_tsquery('simple', $2) OR $2 = '')
+		ORDER BY %s %s, researcher_id`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{name, specialization}
+
+	return streamRows(s.DB, query, args, func(rows *sql.Rows) error {
+		var researcher Researcher
+		err := rows.Scan(
+			&researcher.Id,
+			&researcher.Name,
+			&researcher.Specialization,
+			&researcher.Project,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(&researcher)
+	})
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"goproject/internal/validator"
+)
+
+// Stat holds a single row of an aggregate query: the value of the grouping key
+// (rendered as text so that every grouping can share the same type) and the number of
+// records that fall into that group.
+type Stat struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// StatsFilters plays the same role for the aggregate endpoints as Filters does for the
+// list endpoints. GroupBy is checked against GroupBySafelist before it is ever turned
+// into SQL, and BucketSize is only used by the "age_bucket" grouping.
+type StatsFilters struct {
+	GroupBy         string
+	GroupBySafelist []string
+	BucketSize      int
+}
+
+// Check that the client-provided GroupBy value matches one of the entries in our
+// safelist. Like sortColumn(), this panics if it is called with an unchecked value,
+// because that can only happen if a handler forgot to call ValidateStatsFilters().
+func (f StatsFilters) groupBy() string {
+	for _, safeValue := range f.GroupBySafelist {
+		if f.GroupBy == safeValue {
+			return f.GroupBy
+		}
+	}
+	panic("unsafe group_by parameter: " + f.GroupBy)
+}
+
+func ValidateStatsFilters(v *validator.Validator, f StatsFilters) {
+	v.Check(f.GroupBy != "", "group_by", "must be provided")
+	v.Check(validator.In(f.GroupBy, f.GroupBySafelist...), "group_by", "invalid group_by value")
+	v.Check(f.BucketSize > 0, "bucket_size", "must be greater than zero")
+	v.Check(f.BucketSize <= 1_000_000, "bucket_size", "must be a maximum of 1 million")
+}
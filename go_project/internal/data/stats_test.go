@@ -0,0 +1,119 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"goproject/internal/validator"
+)
+
+func TestValidateStatsFilters(t *testing.T) {
+	safelist := []string{"location", "researcher_id", "age_bucket"}
+
+	tests := []struct {
+		name    string
+		filters StatsFilters
+		want    map[string]string
+	}{
+		{"location", StatsFilters{GroupBy: "location", BucketSize: 100}, map[string]string{}},
+		{"age bucket", StatsFilters{GroupBy: "age_bucket", BucketSize: 1_000_000}, map[string]string{}},
+		{"missing group_by", StatsFilters{BucketSize: 100}, map[string]string{"group_by": "must be provided"}},
+		{"unknown group_by", StatsFilters{GroupBy: "title", BucketSize: 100}, map[string]string{"group_by": "invalid group_by value"}},
+		{"SQL in group_by", StatsFilters{GroupBy: "location; DROP TABLE artifact", BucketSize: 100}, map[string]string{"group_by": "invalid group_by value"}},
+		// The safelist is matched exactly, so a column name in another case is rejected.
+		{"group_by case", StatsFilters{GroupBy: "Location", BucketSize: 100}, map[string]string{"group_by": "invalid group_by value"}},
+		{"zero bucket size", StatsFilters{GroupBy: "age_bucket", BucketSize: 0}, map[string]string{"bucket_size": "must be greater than zero"}},
+		{"negative bucket size", StatsFilters{GroupBy: "age_bucket", BucketSize: -100}, map[string]string{"bucket_size": "must be greater than zero"}},
+		{"huge bucket size", StatsFilters{GroupBy: "age_bucket", BucketSize: 1_000_001}, map[string]string{"bucket_size": "must be a maximum of 1 million"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filters.GroupBySafelist = safelist
+
+			v := validator.New()
+			ValidateStatsFilters(v, tc.filters)
+			if !reflect.DeepEqual(v.Errors, tc.want) {
+				t.Errorf("got errors %v; want %v", v.Errors, tc.want)
+			}
+		})
+	}
+}
+
+func TestStatsFiltersGroupBy(t *testing.T) {
+	f := StatsFilters{GroupBy: "age_bucket", GroupBySafelist: []string{"location", "age_bucket"}}
+	if got := f.groupBy(); got != "age_bucket" {
+		t.Errorf("got %q; want %q", got, "age_bucket")
+	}
+
+	// An unchecked value must never make it into the SQL.
+	defer func() {
+		if recover() == nil {
+			t.Error("groupBy() didn't panic on a value outside the safelist")
+		}
+	}()
+	f.GroupBy = "location; DROP TABLE artifact"
+	f.groupBy()
+}
+
+func TestArtifactGetStats(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	researcher := newTestArtifact(t, m).Researcher_id
+
+	// Give every artifact the same unusual word in its title, so that the title filter
+	// only counts the artifacts inserted by this test.
+	word := fmt.Sprintf("stats%d", time.Now().UnixNano())
+	for _, artifact := range []*Artifact{
+		{Title: "Pithos " + word, Age: 95, Location: "Knossos", Researcher_id: researcher},
+		{Title: "Rhyton " + word, Age: 100, Location: "Knossos", Researcher_id: researcher},
+		{Title: "Larnax " + word, Age: 199, Location: "Phaistos", Researcher_id: researcher},
+		{Title: "Kylix " + word, Age: 250, Location: "Knossos", Researcher_id: researcher},
+	} {
+		if err := m.Artifacts.Insert(artifact); err != nil {
+			t.Fatal(err)
+		}
+		id := artifact.Id
+		t.Cleanup(func() {
+			m.Artifacts.Delete(int64(id))
+			m.db.Exec(`DELETE FROM artifact_versions WHERE artifact_id = $1`, id)
+		})
+	}
+
+	tests := []struct {
+		groupBy    string
+		bucketSize int
+		want       []Stat
+	}{
+		// Groups are ordered by count, largest first, and then by key.
+		{"location", 100, []Stat{{"Knossos", 3}, {"Phaistos", 1}}},
+		{"researcher_id", 100, []Stat{{fmt.Sprint(researcher), 4}}},
+		{"age_bucket", 100, []Stat{{"100-199", 2}, {"0-99", 1}, {"200-299", 1}}},
+		{"age_bucket", 50, []Stat{{"100-149", 1}, {"150-199", 1}, {"250-299", 1}, {"50-99", 1}}},
+		{"age_bucket", 1000, []Stat{{"0-999", 4}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tc.groupBy, tc.bucketSize), func(t *testing.T) {
+			filters := StatsFilters{
+				GroupBy:         tc.groupBy,
+				GroupBySafelist: []string{"location", "researcher_id", "age_bucket"},
+				BucketSize:      tc.bucketSize,
+			}
+
+			stats, err := m.Artifacts.GetStats(word, 1, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []Stat{}
+			for _, stat := range stats {
+				got = append(got, *stat)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// streamBatchSize is the number of rows fetched from the server-side cursor in each
+// round trip while streaming.
+const streamBatchSize = 500
+
+// streamTimeout is an upper bound on how long a single export is allowed to hold its
+// read-only transaction open.
+const streamTimeout = 10 * time.Minute
+
+// The streamRows() helper runs the provided query through a server-side cursor and
+// calls scan() once for every row in the result, so that exports of any size can be
+// written out without loading the whole result into memory. The cursor only lives for
+// the duration of a read-only transaction, which is always rolled back when we're done.
+// If scan() returns an error (for example because the client went away), streaming
+// stops and that error is returned.
+func streamRows(db *sql.DB, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	// Nothing is ever written in this transaction, so rolling it back is the simplest way
+	// of closing the cursor and releasing the connection.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DECLARE stream_cursor NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		// FETCH doesn't accept placeholder parameters, so the (constant) batch size is
+		// interpolated into the statement.
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM stream_cursor", streamBatchSize))
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			err = scan(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+
+		if err = rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+
+		// A short batch means the cursor is exhausted.
+		if fetched < streamBatchSize {
+			return nil
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 	"context"
 	"database/sql"
+	"errors"
 	"goproject/internal/validator"
 )
 
@@ -15,17 +16,25 @@ import (
 const (
 	ScopeActivation = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset = "password-reset"
+	ScopeRefresh = "refresh"
+	ScopeTwoFactorPending = "2fa-pending"
 )
 
 // Define a Token struct to hold the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expiry time and
 // scope.
 type Token struct {
-	Plaintext string `json:"token"`
+	ID int64 `json:"id"`
+	Plaintext string `json:"token,omitempty"`
 	Hash []byte `json:"-"`
 	UserID int64 `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
 	Expiry time.Time `json:"expiry"`
 	Scope string `json:"-"`
+	IP string `json:"ip"`
+	UserAgent string `json:"user_agent"`
 }
 	
 
@@ -80,6 +89,18 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 // Define the TokenModel type.
 type TokenModel struct {
 	DB *sql.DB
+	// tx is set on copies of the model returned by Models.WithTx(), in which case all
+	// queries run inside that transaction rather than directly on the pool.
+	tx *sql.Tx
+}
+
+// conn() returns the transaction that the model is bound to, if any, or the connection
+// pool otherwise.
+func (m TokenModel) conn() queryer {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
 }
 
 // The New() method is a shortcut which creates a new Token struct and then inserts the
@@ -95,17 +116,178 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewSession() creates an authentication or refresh token like New(), but also records
+// the IP address and user agent of the client that it was issued to, so that the user
+// can recognise the session later.
+func (m TokenModel) NewSession(userID int64, ttl time.Duration, scope, ip, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.IP = ip
+	token.UserAgent = userAgent
+
+	err = m.Insert(token)
+	return token, err
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)`
+	INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
 	
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
 	
+	err := m.conn().QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+	return translateError(err)
+}
+
+// GetAllForUser() returns the unexpired tokens with the given scope for a user, most
+// recently created first. The plaintext is never stored, so it isn't included.
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	query := `
+	SELECT id, hash, user_id, created_at, last_used_at, expiry, scope, ip, user_agent
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND expiry > NOW()
+	ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.conn().QueryContext(ctx, query, scope, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(
+			&token.ID,
+			&token.Hash,
+			&token.UserID,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.Expiry,
+			&token.Scope,
+			&token.IP,
+			&token.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Touch() records that the token with the given hash has just been used. To avoid a
+// write on every single request, last_used_at is only updated once it's more than
+// interval out of date.
+func (m TokenModel) Touch(hash []byte, interval time.Duration) error {
+	query := `
+	UPDATE tokens
+	SET last_used_at = NOW()
+	WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, query, hash, time.Now().Add(-interval))
+	return err
+}
+
+// DeleteByID() deletes a single token belonging to a user by its ID.
+func (m TokenModel) DeleteByID(scope string, userID int64, id int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, query, scope, userID, id)
+	return err
+}
+
+// DeleteAllSessionsForUser() deletes all of a user's authentication and refresh tokens,
+// which logs them out everywhere whichever auth mode their sessions were created in.
+func (m TokenModel) DeleteAllSessionsForUser(userID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope IN ($1, $2) AND user_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh, userID)
+	return err
+}
+
+// DeleteOtherSessionsForUser() deletes all of a user's authentication and refresh
+// tokens except the one for the current session, which is identified either by the hash
+// of its opaque token or by the ID of its refresh token (pass nil and 0 for whichever
+// doesn't apply).
+func (m TokenModel) DeleteOtherSessionsForUser(userID int64, currentHash []byte, currentID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope IN ($1, $2) AND user_id = $3
+	AND hash IS DISTINCT FROM $4 AND id <> $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh, userID, currentHash, currentID)
+	return err
+}
+
+// Consume() deletes an unexpired token with the given scope and returns the ID of the
+// user that it belonged to, or ErrRecordNotFound if there's no such token. Finding and
+// deleting the token is a single statement, so a token can only ever be consumed once,
+// even by two requests racing each other.
+func (m TokenModel) Consume(scope, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+	RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := m.conn().QueryRowContext(ctx, query, tokenHash[:], scope).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// Delete() deletes a single token by its hash.
+func (m TokenModel) Delete(hash []byte) error {
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, query, hash)
 	return err
 }
 	
@@ -116,7 +298,17 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	WHERE scope = $1 AND user_id = $2`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	_, err := m.conn().ExecContext(ctx, query, scope, userID)
 	return err
 }
-		
\ No newline at end of file
+		
+
+// DeleteExpired() deletes every token that has expired, whatever its scope, and
+// returns how many there were.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE expiry < NOW()`
+
+	return deleteRows(m.DB, query)
+}
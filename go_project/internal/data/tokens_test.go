@@ -0,0 +1,186 @@
+package data
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenConsume(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	user := newTestUser(t, m)
+
+	token, err := m.Tokens.New(user.ID, time.Hour, ScopeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token can't be consumed with the wrong scope, and trying doesn't use it up.
+	if _, err := m.Tokens.Consume(ScopeAuthentication, token.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("consuming with the wrong scope: got %v; want %v", err, ErrRecordNotFound)
+	}
+
+	userID, err := m.Tokens.Consume(ScopeRefresh, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != user.ID {
+		t.Errorf("got user %d; want %d", userID, user.ID)
+	}
+
+	if _, err := m.Tokens.Consume(ScopeRefresh, token.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("consuming twice: got %v; want %v", err, ErrRecordNotFound)
+	}
+
+	expired, err := m.Tokens.New(user.ID, -time.Minute, ScopeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Tokens.Consume(ScopeRefresh, expired.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("consuming an expired token: got %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestTokenConsumeRace(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	user := newTestUser(t, m)
+
+	token, err := m.Tokens.New(user.ID, time.Hour, ScopeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// However many requests try to use the same refresh token at once, only one of
+	// them gets it.
+	const racers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, racers)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Tokens.Consume(ScopeRefresh, token.Plaintext)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrRecordNotFound):
+			t.Error(err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d requests consumed the token; want 1", succeeded)
+	}
+}
+
+func TestTokenTouch(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	user := newTestUser(t, m)
+
+	token, err := m.Tokens.NewSession(user.ID, time.Hour, ScopeAuthentication, "192.0.2.1", "curl/8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastUsed := func() *time.Time {
+		t.Helper()
+		tokens, err := m.Tokens.GetAllForUser(ScopeAuthentication, user.ID)
+		if err != nil || len(tokens) != 1 {
+			t.Fatalf("got %d tokens, %v; want 1", len(tokens), err)
+		}
+		return tokens[0].LastUsedAt
+	}
+
+	if got := lastUsed(); got != nil {
+		t.Fatalf("got last used %v for a new token; want nil", got)
+	}
+
+	if err := m.Tokens.Touch(token.Hash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	first := lastUsed()
+	if first == nil {
+		t.Fatal("last used wasn't set")
+	}
+
+	// Using the token again within the interval doesn't write anything.
+	if err := m.Tokens.Touch(token.Hash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if second := lastUsed(); !second.Equal(*first) {
+		t.Errorf("got last used %v; want it left at %v", second, first)
+	}
+
+	// Once it's out of date, it's updated again.
+	time.Sleep(10 * time.Millisecond)
+	if err := m.Tokens.Touch(token.Hash, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if third := lastUsed(); !third.After(*first) {
+		t.Errorf("got last used %v; want it moved on from %v", third, first)
+	}
+}
+
+func TestDeleteSessionsForUser(t *testing.T) {
+	m := NewModels(newTestDB(t))
+	user := newTestUser(t, m)
+	other := newTestUser(t, m)
+
+	newToken := func(userID int64, scope string) *Token {
+		t.Helper()
+		token, err := m.Tokens.New(userID, time.Hour, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	count := func(userID int64, scope string) int {
+		t.Helper()
+		tokens, err := m.Tokens.GetAllForUser(scope, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(tokens)
+	}
+
+	current := newToken(user.ID, ScopeAuthentication)
+	newToken(user.ID, ScopeAuthentication)
+	refresh := newToken(user.ID, ScopeRefresh)
+	newToken(user.ID, ScopeRefresh)
+	newToken(user.ID, ScopeActivation)
+	newToken(other.ID, ScopeAuthentication)
+
+	// The current session is kept, whether it's identified by its hash or its ID.
+	if err := m.Tokens.DeleteOtherSessionsForUser(user.ID, current.Hash, refresh.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(user.ID, ScopeAuthentication); got != 1 {
+		t.Errorf("got %d authentication tokens after deleting the others; want 1", got)
+	}
+	if got := count(user.ID, ScopeRefresh); got != 1 {
+		t.Errorf("got %d refresh tokens after deleting the others; want 1", got)
+	}
+
+	if err := m.Tokens.DeleteAllSessionsForUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(user.ID, ScopeAuthentication) + count(user.ID, ScopeRefresh); got != 0 {
+		t.Errorf("got %d sessions after deleting them all; want 0", got)
+	}
+
+	// Other kinds of token, and other users' sessions, are left alone.
+	if got := count(user.ID, ScopeActivation); got != 1 {
+		t.Errorf("got %d activation tokens; want 1", got)
+	}
+	if got := count(other.ID, ScopeAuthentication); got != 1 {
+		t.Errorf("got %d sessions for another user; want 1", got)
+	}
+}
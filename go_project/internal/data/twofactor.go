@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+)
+
+// recoveryCodeCount is the number of recovery codes that a user gets each time that
+// they're generated.
+const recoveryCodeCount = 10
+
+// TwoFactor holds a user's TOTP secret. Confirmed is false between enrolment and the
+// user proving that their authenticator app works by sending a code; until then the
+// secret isn't used for anything.
+type TwoFactor struct {
+	UserID      int64
+	Secret      string
+	Confirmed   bool
+	LastCounter int64
+}
+
+// Define the TwoFactorModel type.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Get() returns the TOTP details for a user, or ErrRecordNotFound if they haven't
+// started enrolling.
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, confirmed, last_counter
+		FROM user_totp
+		WHERE user_id = $1`
+
+	var twoFactor TwoFactor
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.Secret,
+		&twoFactor.Confirmed,
+		&twoFactor.LastCounter,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &twoFactor, nil
+}
+
+// Enabled() reports whether a user has confirmed two-factor authentication.
+func (m TwoFactorModel) Enabled(userID int64) (bool, error) {
+	twoFactor, err := m.Get(userID)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return twoFactor.Confirmed, nil
+}
+
+// Enrol() stores a new, unconfirmed secret for a user, replacing any earlier secret
+// that they never confirmed. It returns ErrTwoFactorEnabled if the user has already
+// confirmed a secret, which has to be disabled before a new one can be enrolled.
+func (m TwoFactorModel) Enrol(userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_counter = 0, created_at = NOW()
+		WHERE NOT user_totp.confirmed
+		RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, userID, secret).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTwoFactorEnabled
+		default:
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+// Confirm() turns two-factor authentication on for a user.
+func (m TwoFactorModel) Confirm(userID int64) error {
+	query := `
+		UPDATE user_totp
+		SET confirmed = true
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// UseCounter() records that the code for the given time step has been accepted. It
+// returns false if that code (or a later one) has already been used, in which case
+// the code must be rejected.
+func (m TwoFactorModel) UseCounter(userID int64, counter int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_counter = $2
+		WHERE user_id = $1 AND last_counter < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Delete() turns two-factor authentication off for a user, removing their secret and
+// recovery codes.
+func (m TwoFactorModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes() throws away a user's recovery codes and generates a new set.
+// The plaintext codes are returned so that they can be shown to the user once; only
+// their hashes are stored.
+func (m TwoFactorModel) ReplaceRecoveryCodes(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+		codes[i] = code[:8] + "-" + code[8:]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(code))
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode() checks a recovery code and, if it's valid, deletes it so that it
+// can't be used again.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	query := `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
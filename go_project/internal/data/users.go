@@ -1,13 +1,15 @@
 package data
 
 import (
+	"bytes"
 	"context" 
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 	"crypto/sha256" 
+	"sync"
 	"goproject/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var AnonymousUser = &User{}
@@ -23,6 +25,8 @@ type User struct {
 	Email string `json:"email"`
 	Password password `json:"-"`
 	Activated bool `json:"activated"`
+	Disabled bool `json:"disabled"`
+	ResearcherID *int64 `json:"researcher_id"`
 	Version int `json:"-"`
 }
 
@@ -30,6 +34,12 @@ type User struct {
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
+
+// OwnsResearcher reports whether the user is linked to the researcher profile with the
+// given ID.
+func (u *User) OwnsResearcher(researcherID int) bool {
+	return u.ResearcherID != nil && *u.ResearcherID == int64(researcherID)
+}
 	
 
 var (
@@ -46,10 +56,11 @@ type password struct {
 	hash []byte
 }
 
-// The Set() method calculates the bcrypt hash of a plaintext password, and stores both
-// the hash and the plaintext versions in the struct.
+// The Set() method calculates the hash of a plaintext password with the
+// DefaultPasswordHasher, and stores both the hash and the plaintext versions in the
+// struct.
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := DefaultPasswordHasher.Hash([]byte(plaintextPassword))
 	if err != nil {
 		return err
 	}
@@ -58,20 +69,42 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// dummyPassword is a password hash which no user has. It's generated (once) the first
+// time that it's needed, with the same hasher as real password hashes.
+var (
+	dummyPasswordOnce sync.Once
+	dummyPassword password
+)
+
+// CompareDummyPassword() compares a plaintext password against dummyPassword and
+// throws the result away. Calling it when a login names an email address that doesn't
+// exist means that the response takes as long as it would for a real user, so the
+// timing doesn't give away which addresses are registered.
+func CompareDummyPassword(plaintextPassword string) {
+	dummyPasswordOnce.Do(func() {
+		_ = dummyPassword.Set("dummy password which nobody has")
+	})
+	_, _ = dummyPassword.Matches(plaintextPassword)
+}
+
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
-// otherwise.
+// otherwise. The hash is checked with whichever hasher produced it, so hashes made
+// before the DefaultPasswordHasher was changed keep working.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := passwordHasherFor(p.hash)
 	if err != nil {
-		switch {
-			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-				return false, nil
-			default:
-				return false, err
-		}
+		return false, err
 	}
-	return true, nil
+	return hasher.Matches(p.hash, []byte(plaintextPassword))
+}
+
+// The NeedsRehash() method reports whether the stored hash was made with a different
+// algorithm or different parameters to the DefaultPasswordHasher, in which case it
+// should be replaced (by calling Set() with the plaintext password) once the user has
+// proved that they know the password.
+func (p *password) NeedsRehash() bool {
+	return !DefaultPasswordHasher.Recognises(p.hash) || DefaultPasswordHasher.NeedsRehash(p.hash)
 }
 
 
@@ -115,6 +148,46 @@ func ValidateUser(v *validator.Validator, user *User) {
 // Create a UserModel struct which wraps the connection pool.
 type UserModel struct {
 	DB *sql.DB
+	// tx is set on copies of the model returned by Models.WithTx(), in which case
+	// changes are made inside that transaction.
+	tx *sql.Tx
+	// actor is set on copies of the model returned by Models.As(), and is who changes
+	// are recorded against in the audit trail. Without one, they're recorded against
+	// the application itself.
+	actor *Actor
+}
+
+// lock() fetches a user and locks their record until the end of the transaction, so
+// that the audit trail can record what it looked like before it was changed.
+func (m UserModel) lock(q queryer, id int64) (*User, error) {
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, disabled, researcher_id, version
+	FROM users
+	WHERE id = $1
+	FOR UPDATE`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.ResearcherID,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
 }
 // Insert a new record in the database for the user. Note that the id, created_at and
 // version fields are all automatically generated by our database, so we use the
@@ -122,8 +195,8 @@ type UserModel struct {
 // that we did when creating a movie.
 func (m UserModel) Insert(user *User) error {
 	query := `
-	INSERT INTO users (name, email, password_hash, activated)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO users (name, email, password_hash, activated, activated_at)
+	VALUES ($1, $2, $3, $4, CASE WHEN $4 THEN NOW() END)
 	RETURNING id, created_at, version`
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -131,18 +204,15 @@ func (m UserModel) Insert(user *User) error {
 
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
-	// constraint that we set up in the previous chapter. We check for this error
-	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
-	if err != nil {
-		switch {
-			case err.Error() == `pq: повторяющееся значение ключа нарушает ограничение уникальности "users_email_key"`:
-				return ErrDuplicateEmail
-			default:
-				return err
+	// constraint that we set up in the previous chapter. translateError() spots this by
+	// the constraint name and returns our custom ErrDuplicateEmail error instead.
+	return audited(m.DB, m.tx, m.actor, func(q queryer) (*AuditEvent, error) {
+		err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+		if err != nil {
+			return nil, translateError(err)
 		}
-	}
-	return nil
+		return newAuditEvent("user.created", "user", user.ID, nil, user)
+	})
 }
 
 // Retrieve the User details from the database based on the user's email address.
@@ -150,7 +220,7 @@ func (m UserModel) Insert(user *User) error {
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, created_at, name, email, password_hash, activated, version
+	SELECT id, created_at, name, email, password_hash, activated, disabled, researcher_id, version
 	FROM users
 	WHERE email = $1`
 	var user User
@@ -163,6 +233,43 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
+		&user.ResearcherID,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Retrieve the User details from the database based on the user's ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, disabled, researcher_id, version
+	FROM users
+	WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Disabled,
+		&user.ResearcherID,
 		&user.Version,
 	)
 	if err != nil {
@@ -176,42 +283,145 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetAll() returns a page of users, optionally filtered by name and email (both
+// partial, case-insensitive matches) and by activation status. An empty activated
+// value means "any".
+func (m UserModel) GetAll(name string, email string, activated string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, disabled, researcher_id, version
+	FROM users
+	WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+	AND (email ILIKE '%%' || $2 || '%%' OR $2 = '')
+	AND (activated = ($3 = 'true') OR $3 = '')
+	ORDER BY %s %s, id
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{name, email, activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Disabled,
+			&user.ResearcherID,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
+
 // Update the details for a specific user. Notice that we check against the version
 // field to help prevent any race conditions during the request cycle, just like we did
-// when updating a movie. And we also check for a violation of the "users_email_key"
-// constraint when performing the update, just like we did when inserting the user
-// record originally.
+// when updating a movie. And a violation of the "users_email_key" constraint is
+// translated into ErrDuplicateEmail, just like when inserting the user record
+// originally.
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, password_hash = $3, activated = $4, disabled = $5, researcher_id = $6, version = version + 1,
+	activated_at = CASE WHEN $4 THEN COALESCE(activated_at, NOW()) ELSE activated_at END
+	WHERE id = $7 AND version = $8
 	RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Disabled,
+		user.ResearcherID,
 		user.ID,
 		user.Version,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
-	if err != nil {
-		switch {
-			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-				return ErrDuplicateEmail
-			case errors.Is(err, sql.ErrNoRows):
-				return ErrEditConflict
-			default:
-				return err
+	return audited(m.DB, m.tx, m.actor, func(q queryer) (*AuditEvent, error) {
+		before, err := m.lock(q, user.ID)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return nil, ErrEditConflict
+			}
+			return nil, err
 		}
-	}
-	return nil
+
+		err = q.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		if err != nil {
+			switch {
+				case errors.Is(err, sql.ErrNoRows):
+					return nil, ErrEditConflict
+				default:
+					return nil, translateError(err)
+			}
+		}
+
+		event, err := newAuditEvent("user.updated", "user", user.ID, before, user)
+		if err != nil {
+			return nil, err
+		}
+		// The password hash is never included in JSON, so Diff() can't see it change.
+		// The audit trail only needs to say that it did, not what it is.
+		if !bytes.Equal(before.Password.hash, user.Password.hash) {
+			event.Changes["password"] = Change{From: "[redacted]", To: "[redacted]"}
+		}
+		return event, nil
+	})
 }
 
 
+// Delete() removes a user. Their tokens, API keys, permissions and other per-user
+// records are removed along with them by the ON DELETE CASCADE foreign keys.
+func (m UserModel) Delete(id int64) error {
+	query := `
+	DELETE FROM users
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audited(m.DB, m.tx, m.actor, func(q queryer) (*AuditEvent, error) {
+		before, err := m.lock(q, id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = q.ExecContext(ctx, query, id)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		return newAuditEvent("user.deleted", "user", id, before, nil)
+	})
+}
+
+// GetForToken() returns the user that an unexpired token with the given scope belongs
+// to. Tokens belonging to disabled users don't match, so a disabled account can't
+// authenticate, activate itself, reset its password or refresh a session.
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
@@ -219,13 +429,14 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	
 	// Set up the SQL query.
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.disabled, users.researcher_id, users.version
 	FROM users
 	INNER JOIN tokens 
 	ON users.id = tokens.user_id
 	WHERE tokens.hash = $1
 	AND tokens.scope = $2
-	AND tokens.expiry > $3`
+	AND tokens.expiry > $3
+	AND NOT users.disabled`
 	// Create a slice containing the query arguments. Notice how we use the [:] operator
 	// to get a slice containing the token hash, rather than passing in the array (which
 	// is not supported by the pq driver), and that we pass the current time as the
@@ -244,6 +455,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Disabled,
+		&user.ResearcherID,
 		&user.Version,
 	)
 	if err != nil {
@@ -257,4 +470,50 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	// Return the matching user.
 	return &user, nil
-}
\ No newline at end of file
+}
+
+// DeleteUnactivated() deletes the users who registered more than the given time ago
+// and have never activated their account, so that their email addresses can be used
+// again. Users who were activated once and are only unactivated now because they
+// changed their email address are kept. Each deleted user is recorded in the audit
+// trail. It returns how many users were deleted.
+func (m UserModel) DeleteUnactivated(olderThan time.Duration) (int64, error) {
+	query := `
+	DELETE FROM users
+	WHERE activated = false AND activated_at IS NULL AND created_at < $1
+	RETURNING id, created_at, name, email, activated, disabled, researcher_id, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var deleted int64
+	err := auditedMany(m.DB, m.tx, m.actor, func(q queryer) ([]*AuditEvent, error) {
+		rows, err := q.QueryContext(ctx, query, time.Now().Add(-olderThan))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		events := []*AuditEvent{}
+		for rows.Next() {
+			var user User
+			err = rows.Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Activated, &user.Disabled, &user.ResearcherID, &user.Version)
+			if err != nil {
+				return nil, err
+			}
+
+			event, err := newAuditEvent("user.deleted", "user", user.ID, &user, nil)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+
+		deleted = int64(len(events))
+		return events, nil
+	})
+	return deleted, err
+}
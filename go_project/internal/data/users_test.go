@@ -0,0 +1,58 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeleteUnactivated(t *testing.T) {
+	m := NewModels(newTestDB(t))
+
+	tests := []struct {
+		name        string
+		activated   bool
+		activatedAt string
+		age         string
+		wantDeleted bool
+	}{
+		{"never activated", false, "NULL", "2 days", true},
+		{"recently registered", false, "NULL", "1 hour", false},
+		{"activated", true, "NOW() - INTERVAL '1 day'", "2 days", false},
+		// Changing email address makes an account unactivated again, but it was
+		// activated once, so it belongs to someone and mustn't be deleted.
+		{"changed email address", false, "NOW() - INTERVAL '1 day'", "2 days", false},
+	}
+
+	users := make([]*User, len(tests))
+	for i, tc := range tests {
+		users[i] = newTestUser(t, m)
+		_, err := m.db.Exec(`UPDATE users SET activated = $1, activated_at = `+tc.activatedAt+`, created_at = NOW() - $2::interval WHERE id = $3`,
+			tc.activated, tc.age, users[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := m.Users.DeleteUnactivated(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other tests may have left stale users of their own behind, so only check that
+	// ours were counted.
+	if deleted < 1 {
+		t.Errorf("got %d users deleted; want at least 1", deleted)
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := m.Users.Get(users[i].ID)
+			switch {
+			case tc.wantDeleted && !errors.Is(err, ErrRecordNotFound):
+				t.Errorf("got %v; want the user deleted", err)
+			case !tc.wantDeleted && err != nil:
+				t.Errorf("got %v; want the user kept", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"goproject/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// WebhookEventTypes are the events that webhooks can subscribe to. The names are the
+// same as the actions recorded in the audit trail.
+var WebhookEventTypes = []string{
+	"artifact.created",
+	"artifact.updated",
+	"artifact.deleted",
+	"artifact.restored",
+	"expedition.created",
+	"expedition.updated",
+	"expedition.deleted",
+}
+
+// The statuses of a webhook delivery. A pending delivery is waiting to be sent (or
+// retried); a failed one has used up all of its attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL which is sent a request whenever one of the subscribed events
+// happens. The secret is used to sign the requests. It's only shown to the user when
+// the webhook is created, so it's left out of the JSON.
+type Webhook struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	Version    int32     `json:"version"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && validator.In(u.Scheme, "http", "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	// Otherwise anyone who can manage webhooks could make the server send requests to
+	// itself or to other machines on its network. The address is checked again when
+	// each delivery is sent, see webhook.NewTransport().
+	if err == nil {
+		v.Check(validator.PublicHost(u.Hostname()), "url", "must not point at a loopback, private, link-local or internal host")
+	}
+
+	v.Check(len(webhook.EventTypes) > 0, "event_types", "must contain at least one event type")
+	v.Check(validator.Unique(webhook.EventTypes), "event_types", "must not contain duplicate values")
+	for _, eventType := range webhook.EventTypes {
+		v.Check(validator.In(eventType, WebhookEventTypes...), "event_types", "must only contain supported event types")
+	}
+}
+
+// WebhookDelivery is one event sent (or to be sent) to a webhook, and doubles as the
+// delivery log: it records how many attempts have been made and how the last one went.
+// A redelivery is a new delivery of the same payload, which points back at the
+// original through RedeliveryOf.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	RedeliveryOf   *int64          `json:"redelivery_of"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+
+	// URL and Secret are copied from the webhook by ClaimDue(), for the worker.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// webhookPayload is the body of every delivery. Data holds the record that the event is
+// about, keyed by its type, as it was after the change (or before it, for a deletion).
+type webhookPayload struct {
+	Event      string                 `json:"event"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// webhookReadPermissions are the permissions needed to see the records that each type
+// of event is about, keyed by the event's TargetType.
+var webhookReadPermissions = map[string]string{
+	"artifact":   "artifacts:read",
+	"expedition": "expeditions:read",
+}
+
+// enqueueWebhookDeliveries adds a delivery to the outbox for every active webhook which
+// is subscribed to the event, using the same transaction as the change that the event
+// describes. Events that webhooks can't subscribe to are ignored. A webhook only gets
+// the event if its owner is still allowed to manage webhooks and to read the record,
+// going by their permissions now rather than when the webhook was created, and their
+// account hasn't been disabled.
+func enqueueWebhookDeliveries(q queryer, event *AuditEvent) error {
+	if !validator.In(event.Action, WebhookEventTypes...) || event.record == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Event:      event.Action,
+		OccurredAt: time.Now().UTC(),
+		Data:       map[string]interface{}{event.TargetType: event.record},
+	})
+	if err != nil {
+		return err
+	}
+
+	required := []string{"webhooks:manage", webhookReadPermissions[event.TargetType]}
+
+	query := fmt.Sprintf(`
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+		SELECT webhooks.id, $1, $2
+		FROM webhooks
+		INNER JOIN users ON users.id = webhooks.user_id
+		WHERE webhooks.active AND $1 = ANY(webhooks.event_types)
+		AND NOT users.disabled
+		AND (
+			SELECT count(*)
+			FROM (%s) AS granted
+			WHERE granted.code = ANY($3)
+		) = cardinality($3::text[])`, userPermissionsQuery("webhooks.user_id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = q.ExecContext(ctx, query, event.Action, payload, pq.Array(required))
+	return translateError(err)
+}
+
+// hasWebhookSubscribers reports whether any active webhook is subscribed to the event
+// type. It lets bulk operations skip building payloads that nobody wants.
+func hasWebhookSubscribers(q queryer, eventType string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM webhooks WHERE active AND $1 = ANY(event_types))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := q.QueryRowContext(ctx, query, eventType).Scan(&exists)
+	return exists, err
+}
+
+// Define the WebhookModel type.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert() generates the secret for a new webhook and stores it.
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return err
+	}
+	webhook.Secret = "whsec_" + hex.EncodeToString(secret)
+
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, event_types, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.EventTypes), webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+	return translateError(err)
+}
+
+// GetForUser() returns one of a user's webhooks. It returns ErrRecordNotFound if the
+// user has no webhook with that ID.
+func (m WebhookModel) GetForUser(userID int64, id int64) (*Webhook, error) {
+	query := `
+		SELECT id, user_id, url, secret, event_types, active, created_at, version
+		FROM webhooks
+		WHERE user_id = $1 AND id = $2`
+
+	var webhook Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, id).Scan(
+		&webhook.ID,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.EventTypes),
+		&webhook.Active,
+		&webhook.CreatedAt,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &webhook, nil
+}
+
+// GetAllForUser() returns all of a user's webhooks.
+func (m WebhookModel) GetAllForUser(userID int64) ([]*Webhook, error) {
+	query := `
+		SELECT id, user_id, url, secret, event_types, active, created_at, version
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.UserID,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.EventTypes),
+			&webhook.Active,
+			&webhook.CreatedAt,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Update() changes a webhook's URL, event types and whether it's active. It returns
+// ErrEditConflict if the webhook has been changed (or deleted) since it was read.
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, event_types = $2, active = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{webhook.URL, pq.Array(webhook.EventTypes), webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+// Delete() deletes one of a user's webhooks, along with its delivery log. It returns
+// ErrRecordNotFound if the user has no webhook with that ID.
+func (m WebhookModel) Delete(userID int64, id int64) error {
+	query := `
+		DELETE FROM webhooks
+		WHERE user_id = $1 AND id = $2`
+
+	deleted, err := deleteRows(m.DB, query, userID, id)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Define the WebhookDeliveryModel type.
+type WebhookDeliveryModel struct {
+	DB *sql.DB
+}
+
+// webhookDeliveryColumns are the columns read into a WebhookDelivery by scanDelivery().
+const webhookDeliveryColumns = `webhook_deliveries.id, webhook_deliveries.webhook_id,
+	webhook_deliveries.redelivery_of, webhook_deliveries.event_type, webhook_deliveries.payload,
+	webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.next_attempt_at,
+	webhook_deliveries.last_attempt_at, webhook_deliveries.response_status,
+	webhook_deliveries.last_error, webhook_deliveries.created_at`
+
+// scanDelivery() scans the webhookDeliveryColumns, followed by any extra destinations.
+func scanDelivery(row interface{ Scan(...interface{}) error }, delivery *WebhookDelivery, extra ...interface{}) error {
+	dest := []interface{}{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.RedeliveryOf,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// GetAllForWebhook() returns a page of a webhook's delivery log, optionally only the
+// deliveries with the given status.
+func (m WebhookDeliveryModel) GetAllForWebhook(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT %s, count(*) OVER()
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, webhookDeliveryColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := scanDelivery(rows, &delivery, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return deliveries, metadata, nil
+}
+
+// Get() returns one of a webhook's deliveries, or ErrRecordNotFound if the webhook has
+// no delivery with that ID.
+func (m WebhookDeliveryModel) Get(webhookID int64, id int64) (*WebhookDelivery, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND id = $2`, webhookDeliveryColumns)
+
+	var delivery WebhookDelivery
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanDelivery(m.DB.QueryRowContext(ctx, query, webhookID, id), &delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &delivery, nil
+}
+
+// Redeliver() queues a new delivery of the same payload as one of a webhook's earlier
+// deliveries, whatever happened to that one, and returns it. The original is left as
+// it was so that the log stays accurate. It returns ErrRecordNotFound if the webhook
+// has no delivery with that ID.
+func (m WebhookDeliveryModel) Redeliver(webhookID int64, id int64) (*WebhookDelivery, error) {
+	query := fmt.Sprintf(`
+		INSERT INTO webhook_deliveries (webhook_id, redelivery_of, event_type, payload)
+		SELECT webhook_id, id, event_type, payload
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND id = $2
+		RETURNING %s`, webhookDeliveryColumns)
+
+	var delivery WebhookDelivery
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanDelivery(m.DB.QueryRowContext(ctx, query, webhookID, id), &delivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &delivery, nil
+}
+
+// ClaimDue() returns up to limit pending deliveries which are due to be sent, along
+// with the URL and secret of their webhooks. Claiming a delivery pushes its next
+// attempt back by the lease, so that other workers leave it alone while it's being
+// sent; if this worker dies before recording the attempt, the delivery is picked up
+// again once the lease runs out. Deliveries for inactive webhooks stay queued until the
+// webhook is turned back on.
+func (m WebhookDeliveryModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := fmt.Sprintf(`
+		WITH claimed AS (
+			UPDATE webhook_deliveries
+			SET next_attempt_at = $2
+			WHERE id IN (
+				SELECT webhook_deliveries.id
+				FROM webhook_deliveries
+				INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+				WHERE webhook_deliveries.status = 'pending'
+				AND webhook_deliveries.next_attempt_at <= NOW()
+				AND webhooks.active
+				ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+				LIMIT $1
+				FOR UPDATE OF webhook_deliveries SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT %s, webhooks.url, webhooks.secret
+		FROM claimed AS webhook_deliveries
+		INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+		ORDER BY webhook_deliveries.id`, webhookDeliveryColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := scanDelivery(rows, &delivery, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt() saves the outcome of an attempt to send a delivery: its new Status,
+// NextAttemptAt (for a retry), ResponseStatus and LastError. The attempt count and time
+// of the last attempt are filled in on the struct.
+func (m WebhookDeliveryModel) RecordAttempt(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, next_attempt_at = $2, response_status = $3, last_error = $4,
+			attempts = attempts + 1, last_attempt_at = NOW()
+		WHERE id = $5
+		RETURNING attempts, last_attempt_at`
+
+	args := []interface{}{delivery.Status, delivery.NextAttemptAt, delivery.ResponseStatus, delivery.LastError, delivery.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&delivery.Attempts, &delivery.LastAttemptAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// The webhook was deleted while the delivery was being sent.
+			return ErrRecordNotFound
+		default:
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+// DeleteFinished() deletes the deliveries which succeeded or failed for good more than
+// olderThan ago, and returns how many it deleted. Pending deliveries are always kept.
+func (m WebhookDeliveryModel) DeleteFinished(olderThan time.Duration) (int64, error) {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'pending' AND created_at < $1`
+
+	return deleteRows(m.DB, query, time.Now().Add(-olderThan))
+}
@@ -0,0 +1,52 @@
+package data
+
+import "testing"
+
+func TestWebhookDeliveriesNeedPermission(t *testing.T) {
+	m := NewModels(newTestDB(t))
+
+	tests := []struct {
+		name        string
+		permissions []string
+		disabled    bool
+		want        int
+	}{
+		{"can read artifacts", []string{"webhooks:manage", "artifacts:read"}, false, 1},
+		{"can't read artifacts", []string{"webhooks:manage", "expeditions:read"}, false, 0},
+		{"can't manage webhooks", []string{"artifacts:read"}, false, 0},
+		{"disabled", []string{"webhooks:manage", "artifacts:read"}, true, 0},
+	}
+
+	webhooks := make([]*Webhook, len(tests))
+	for i, tc := range tests {
+		user := newTestUser(t, m)
+		if err := m.Permissions.AddForUser(user.ID, tc.permissions...); err != nil {
+			t.Fatal(err)
+		}
+		if tc.disabled {
+			user.Disabled = true
+			if err := m.Users.Update(user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		webhooks[i] = &Webhook{UserID: user.ID, URL: "https://example.com/hook", EventTypes: []string{"artifact.created"}, Active: true}
+		if err := m.Webhooks.Insert(webhooks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newTestArtifact(t, m)
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			deliveries, _, err := m.Deliveries.GetAllForWebhook(webhooks[i].ID, "", Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != tc.want {
+				t.Errorf("got %d deliveries; want %d", len(deliveries), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+// Package jwt implements the small part of JSON Web Tokens (RFC 7519) that the API
+// needs: compact JWS tokens signed with HS256 or EdDSA (Ed25519), verification of RS256
+// tokens from identity providers, and the registered claims.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrAlgorithm        = errors.New("jwt: unexpected signing algorithm")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token has expired")
+	ErrNotYetValid      = errors.New("jwt: token is not valid yet")
+	ErrInvalidIssuer    = errors.New("jwt: unexpected issuer")
+	ErrInvalidAudience  = errors.New("jwt: unexpected audience")
+)
+
+// A Verifier checks the signature of a token for one particular algorithm.
+type Verifier interface {
+	// Alg returns the JWS "alg" header value, such as "HS256".
+	Alg() string
+	Verify(signingInput, signature []byte) error
+}
+
+// A Signer can create signatures as well as verify them.
+type Signer interface {
+	Verifier
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+type hs256 struct {
+	secret []byte
+}
+
+// NewHS256 returns a Signer which uses HMAC-SHA256 with the given secret.
+func NewHS256(secret []byte) Signer {
+	return hs256{secret: secret}
+}
+
+func (s hs256) Alg() string { return "HS256" }
+
+func (s hs256) Sign(signingInput []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil), nil
+}
+
+func (s hs256) Verify(signingInput, signature []byte) error {
+	expected, _ := s.Sign(signingInput)
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+type eddsa struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// NewEdDSA returns a Signer which uses an Ed25519 private key.
+func NewEdDSA(key ed25519.PrivateKey) Signer {
+	return eddsa{private: key, public: key.Public().(ed25519.PublicKey)}
+}
+
+func (s eddsa) Alg() string { return "EdDSA" }
+
+func (s eddsa) Sign(signingInput []byte) ([]byte, error) {
+	return ed25519.Sign(s.private, signingInput), nil
+}
+
+func (s eddsa) Verify(signingInput, signature []byte) error {
+	if !ed25519.Verify(s.public, signingInput, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+type rs256 struct {
+	public *rsa.PublicKey
+}
+
+// NewRS256Verifier returns a Verifier for RSASSA-PKCS1-v1_5 with SHA-256 signatures made
+// with the private half of the given key. It's used for ID tokens from an OpenID
+// Connect provider, so there's no matching Signer.
+func NewRS256Verifier(key *rsa.PublicKey) Verifier {
+	return rs256{public: key}
+}
+
+func (v rs256) Alg() string { return "RS256" }
+
+func (v rs256) Verify(signingInput, signature []byte) error {
+	digest := sha256.Sum256(signingInput)
+	if rsa.VerifyPKCS1v15(v.public, crypto.SHA256, digest[:], signature) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign encodes claims (any value that marshals to a JSON object) and signs them,
+// returning the token in compact form.
+func Sign(s Signer, claims interface{}) (string, error) {
+	h, err := json.Marshal(header{Alg: s.Alg(), Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+
+	signature, err := s.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Parse checks the signature of a compact token and decodes its payload into claims.
+// The token's "alg" header must match the verifier exactly, so a token can't choose a
+// weaker algorithm (or "none") for itself. Parse doesn't check any of the claims; use
+// RegisteredClaims.Validate() for that.
+func Parse(token string, v Verifier, claims interface{}) error {
+	h, err := decodeHeader(token)
+	if err != nil {
+		return err
+	}
+	if h.Alg != v.Alg() {
+		return ErrAlgorithm
+	}
+
+	i := strings.LastIndexByte(token, '.')
+
+	signature, err := encoding.DecodeString(token[i+1:])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	err = v.Verify([]byte(token[:i]), signature)
+	if err != nil {
+		return err
+	}
+
+	payload, err := encoding.DecodeString(token[strings.IndexByte(token, '.')+1 : i])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(claims); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// KeyID returns the "kid" header of a token without verifying it, so that the right key
+// can be picked from a key set before calling Parse().
+func KeyID(token string) (string, error) {
+	h, err := decodeHeader(token)
+	if err != nil {
+		return "", err
+	}
+	return h.Kid, nil
+}
+
+// LooksLikeJWT reports whether a string has the three dot-separated parts of a compact
+// token, which is enough to tell it apart from the API's opaque tokens.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func decodeHeader(token string) (header, error) {
+	var h header
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return h, ErrMalformed
+	}
+
+	raw, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return h, ErrMalformed
+	}
+
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return h, ErrMalformed
+	}
+	return h, nil
+}
+
+// Audience is the "aud" claim, which can be either a single string or an array of
+// strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Contains reports whether the audience includes the given value.
+func (a Audience) Contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisteredClaims holds the standard claims from RFC 7519 that the API uses. Embed it
+// in a struct to add application-specific claims.
+type RegisteredClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// leeway allows for a little clock skew between the server that issued a token and the
+// one checking it.
+const leeway = 30 * time.Second
+
+// Validate checks the time-based claims against now, and the issuer and audience if
+// they're not empty. A token without an expiry is never valid.
+func (c RegisteredClaims) Validate(now time.Time, issuer, audience string) error {
+	switch {
+	case c.ExpiresAt == 0 || now.Add(-leeway).After(time.Unix(c.ExpiresAt, 0)):
+		return ErrExpired
+	case c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)):
+		return ErrNotYetValid
+	case issuer != "" && c.Issuer != issuer:
+		return ErrInvalidIssuer
+	case audience != "" && !c.Audience.Contains(audience):
+		return ErrInvalidAudience
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	RegisteredClaims
+	Name string `json:"name"`
+}
+
+func newTestClaims() testClaims {
+	return testClaims{
+		RegisteredClaims: RegisteredClaims{
+			Issuer:    "goproject",
+			Subject:   "42",
+			Audience:  Audience{"api"},
+			ExpiresAt: 2000000000,
+			IssuedAt:  1700000000,
+		},
+		Name: "Alice",
+	}
+}
+
+// testSigners returns one signer for each of the algorithms that the API can sign with.
+func testSigners() map[string]Signer {
+	return map[string]Signer{
+		"HS256": NewHS256([]byte("a secret which is at least 32 bytes long")),
+		"EdDSA": NewEdDSA(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))),
+	}
+}
+
+func TestSignAndParse(t *testing.T) {
+	for alg, signer := range testSigners() {
+		t.Run(alg, func(t *testing.T) {
+			want := newTestClaims()
+
+			token, err := Sign(signer, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !LooksLikeJWT(token) {
+				t.Errorf("token %q doesn't look like a JWT", token)
+			}
+
+			var got testClaims
+			if err := Parse(token, signer, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v; want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	signers := testSigners()
+	hs256 := signers["HS256"]
+
+	token, err := Sign(hs256, newTestClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	tampered := newTestClaims()
+	tampered.Subject = "1"
+	payload, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none := encoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name     string
+		token    string
+		verifier Verifier
+		want     error
+	}{
+		{"other algorithm", token, signers["EdDSA"], ErrAlgorithm},
+		{"none", none, hs256, ErrAlgorithm},
+		{"wrong secret", token, NewHS256([]byte("another secret which is 32 bytes long")), ErrInvalidSignature},
+		{"tampered payload", parts[0] + "." + encoding.EncodeToString(payload) + "." + parts[2], hs256, ErrInvalidSignature},
+		{"missing signature", parts[0] + "." + parts[1] + ".", hs256, ErrInvalidSignature},
+		{"two parts", parts[0] + "." + parts[1], hs256, ErrMalformed},
+		{"bad header", "!!!." + parts[1] + "." + parts[2], hs256, ErrMalformed},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!!!", hs256, ErrMalformed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var claims testClaims
+			err := Parse(tc.token, tc.verifier, &claims)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestRS256 verifies the RS256 example from RFC 7515 appendix A.2 with the public half
+// of the key given there.
+func TestRS256(t *testing.T) {
+	n, err := encoding.DecodeString("ofgWCuLjybRlzo0tZWJjNiuSfb4p4fAkd_wWJcyQoTbji9k0l8W26mPddxHmfHQp-Vaw-4qPCJrcS2mJPMEzP1Pt0Bm4d4QlL-yRT-SFd2lZS-pCgNMsD1W_YpRPEwOWvG6b32690r2jZ47soMZo9wGzjb_7OMg0LOL-bSf63kpaSHSXndS5z5rexMdbBYUsLA9e-KXBdQOS-UTo7WTBEMa2R2CapHg665xsmtdVMTBQY4uDZlxvb3qCo5ZwKh9kG4LT6_I5IhlJH7aGhyxXFvUK-DWNmoudF8NAco9_h9iaGNj8q2ethFkMLs91kzk2PAcDTW9gb54h4FRWyuXpoQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	token := "eyJhbGciOiJSUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".cC4hiUPoj9Eetdgtv3hF80EGrhuB__dzERat0XF9g2VtQgr9PJbu3XOiZj5RZmh7AAuHIm4Bh-0Qc_lF5YKt_O8W2Fp5jujGbds9uJdbF9CUAr7t1dnZcAcQjbKBYNX4BAynRFdiuB--f_nZLgrnbyTyWzO75vRK5h6xBArLIARNPvkSjtQBMHlb1L07Qe7K0GarZRmB_eSN9383LcOLn6_dO--xi12jzDwusC-eOkHWEsqtFZESc6BfI7noOPqvhJ1phCnvWh6IeYI2w9QOYEUipUTI8np6LbgGY9Fs98rqVt5AXLIhWkWywlVmtVrBp0igcN_IoypGlUPQGe77Rw"
+
+	var claims RegisteredClaims
+	if err := Parse(token, NewRS256Verifier(key), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "joe" || claims.ExpiresAt != 1300819380 {
+		t.Errorf("got %+v; want iss joe and exp 1300819380", claims)
+	}
+
+	// The same token doesn't verify against any other key.
+	other := &rsa.PublicKey{N: new(big.Int).Add(key.N, big.NewInt(2)), E: key.E}
+	if err := Parse(token, NewRS256Verifier(other), &claims); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v; want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	token := encoding.EncodeToString([]byte(`{"alg":"RS256","kid":"key-1"}`)) + ".e30.c2ln"
+
+	kid, err := KeyID(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != "key-1" {
+		t.Errorf("got %q; want %q", kid, "key-1")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name   string
+		claims RegisteredClaims
+		want   error
+	}{
+		{"valid", RegisteredClaims{ExpiresAt: now.Add(time.Minute).Unix()}, nil},
+		{"no expiry", RegisteredClaims{}, ErrExpired},
+		{"expired", RegisteredClaims{ExpiresAt: now.Add(-time.Minute).Unix()}, ErrExpired},
+		{"expired within leeway", RegisteredClaims{ExpiresAt: now.Add(-20 * time.Second).Unix()}, nil},
+		{"not yet valid", RegisteredClaims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()}, ErrNotYetValid},
+		{"not yet valid within leeway", RegisteredClaims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(20 * time.Second).Unix()}, nil},
+		{"wrong issuer", RegisteredClaims{ExpiresAt: now.Add(time.Minute).Unix(), Issuer: "someone else"}, ErrInvalidIssuer},
+		{"wrong audience", RegisteredClaims{ExpiresAt: now.Add(time.Minute).Unix(), Issuer: "goproject", Audience: Audience{"web"}}, ErrInvalidAudience},
+		{"one of the audiences", RegisteredClaims{ExpiresAt: now.Add(time.Minute).Unix(), Issuer: "goproject", Audience: Audience{"web", "api"}}, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			issuer, audience := "", ""
+			if tc.claims.Issuer != "" {
+				issuer = "goproject"
+			}
+			if tc.claims.Audience != nil {
+				audience = "api"
+			}
+
+			err := tc.claims.Validate(now, issuer, audience)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestAudience(t *testing.T) {
+	tests := []struct {
+		json string
+		want Audience
+	}{
+		{`"api"`, Audience{"api"}},
+		{`["api"]`, Audience{"api"}},
+		{`["api","web"]`, Audience{"api", "web"}},
+	}
+
+	for _, tc := range tests {
+		var got Audience
+		if err := json.Unmarshal([]byte(tc.json), &got); err != nil {
+			t.Fatalf("%s: %v", tc.json, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v; want %v", tc.json, got, tc.want)
+		}
+	}
+
+	var invalid Audience
+	if err := json.Unmarshal([]byte(`42`), &invalid); err == nil {
+		t.Error("got no error for a number")
+	}
+
+	// A single audience is written as a plain string, and several as an array.
+	for _, tc := range []struct {
+		audience Audience
+		want     string
+	}{
+		{Audience{"api"}, `"api"`},
+		{Audience{"api", "web"}, `["api","web"]`},
+	} {
+		js, err := json.Marshal(tc.audience)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != tc.want {
+			t.Errorf("got %s; want %s", js, tc.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	texttemplate "text/template"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Define a Mailer struct which contains the SMTP server address and credentials, and
+// the sender information that you want the email to be from (such as "Alice Smith
+// <alice@example.com>").
+type Mailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+func New(host string, port int, username, password, sender string) Mailer {
+	// Only authenticate if we've been given a username, so that a local development
+	// server (such as MailHog) can be used without any credentials.
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return Mailer{
+		addr:   fmt.Sprintf("%s:%d", host, port),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+// Define a Send() method on the Mailer type. This takes the recipient email address
+// as the first parameter, the name of the file containing the templates, and any
+// dynamic data for the templates as an interface{} parameter.
+//
+// Each template file must define three named templates: "subject", "plainBody" and
+// "htmlBody". The email is sent as multipart/alternative, so that clients which can't
+// show HTML fall back to the plain-text version.
+func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	subject, plainBody, err := m.executeText(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	htmlBody, err := m.executeHTML(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.message(recipient, subject, plainBody, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(m.sender)
+	if err != nil {
+		return err
+	}
+
+	// Try sending the email up to three times before aborting and returning the final
+	// error. We sleep for 500 milliseconds between each attempt, to ride out short
+	// network problems.
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(m.addr, m.auth, from.Address, []string{recipient}, msg)
+		// If everything worked, return nil.
+		if err == nil {
+			return nil
+		}
+
+		// If it didn't work, sleep for a short time and retry.
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return err
+}
+
+// executeText renders the subject and plain-text body of a template file.
+func (m Mailer) executeText(templateFile string, data interface{}) (string, string, error) {
+	tmpl, err := texttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), plainBody.String(), nil
+}
+
+// executeHTML renders the HTML body of a template file. It uses html/template, so that
+// any dynamic data is escaped properly.
+func (m Mailer) executeHTML(templateFile string, data interface{}) (string, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return "", err
+	}
+
+	return htmlBody.String(), nil
+}
+
+// message builds the raw MIME message, with the plain-text and HTML bodies as the two
+// parts of a multipart/alternative body.
+func (m Mailer) message(recipient, subject, plainBody, htmlBody string) ([]byte, error) {
+	boundaryBytes := make([]byte, 16)
+	_, err := rand.Read(boundaryBytes)
+	if err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(boundaryBytes)
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", m.sender)
+	fmt.Fprintf(msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(msg, "--%s\r\n", boundary)
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(msg, "%s\r\n", plainBody)
+
+	fmt.Fprintf(msg, "--%s\r\n", boundary)
+	fmt.Fprintf(msg, "Content-Type: text/html; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(msg, "%s\r\n", htmlBody)
+
+	fmt.Fprintf(msg, "--%s--\r\n", boundary)
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goproject/internal/jwt"
+	"goproject/internal/oidc"
+	"goproject/internal/oidc/oidctest"
+)
+
+func TestExchange(t *testing.T) {
+	issuer, err := oidctest.NewIssuer("test-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuer.Close()
+
+	provider, err := oidc.Discover(context.Background(), issuer.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		kid     string
+		nonce   string
+		change  func(claims *oidc.Claims)
+		wantErr error
+	}{
+		{
+			name:  "valid",
+			kid:   oidctest.KeyID,
+			nonce: "nonce",
+		},
+		{
+			name:    "bad nonce",
+			kid:     oidctest.KeyID,
+			nonce:   "another nonce",
+			wantErr: oidc.ErrNonceMismatch,
+		},
+		{
+			name:    "wrong audience",
+			kid:     oidctest.KeyID,
+			nonce:   "nonce",
+			change:  func(claims *oidc.Claims) { claims.Audience = jwt.Audience{"another-client"} },
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name:    "wrong issuer",
+			kid:     oidctest.KeyID,
+			nonce:   "nonce",
+			change:  func(claims *oidc.Claims) { claims.Issuer = "https://attacker.example.com" },
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name:    "expired",
+			kid:     oidctest.KeyID,
+			nonce:   "nonce",
+			change:  func(claims *oidc.Claims) { claims.ExpiresAt = claims.IssuedAt - 3600 },
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			name:    "unknown key",
+			kid:     "another-key",
+			nonce:   "nonce",
+			wantErr: oidc.ErrInvalidIDToken,
+		},
+		{
+			// Whether an unverified email address is acceptable is up to the caller, so
+			// the token itself is fine.
+			name:   "unverified email",
+			kid:    oidctest.KeyID,
+			nonce:  "nonce",
+			change: func(claims *oidc.Claims) { claims.EmailVerified = false },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := issuer.Claims("subject-1", "alice@example.com", "nonce")
+			if tc.change != nil {
+				tc.change(claims)
+			}
+
+			idToken, err := issuer.Sign(tc.kid, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			issuer.Issue("code", idToken)
+
+			got, err := provider.Exchange(context.Background(), "code", "verifier", tc.nonce)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got.Subject != claims.Subject || got.Email != claims.Email || got.EmailVerified != claims.EmailVerified {
+				t.Errorf("got claims %+v; want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestExchangeUnknownCode(t *testing.T) {
+	issuer, err := oidctest.NewIssuer("test-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer issuer.Close()
+
+	provider, err := oidc.Discover(context.Background(), issuer.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Exchange(context.Background(), "unknown", "verifier", "nonce")
+	if err == nil {
+		t.Fatal("got no error for an unknown code")
+	}
+}
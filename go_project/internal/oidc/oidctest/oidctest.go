@@ -0,0 +1,164 @@
+// Package oidctest provides a stub OpenID Connect identity provider for tests. It
+// serves a discovery document, a key set and a token endpoint from an httptest.Server,
+// and hands out whichever ID token a test has registered for an authorization code.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"goproject/internal/jwt"
+	"goproject/internal/oidc"
+)
+
+// KeyID is the ID of the issuer's signing key, as published in its key set.
+const KeyID = "test-key"
+
+// Issuer is a running stub identity provider. Close it when the test is done.
+type Issuer struct {
+	*httptest.Server
+	ClientID string
+
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// NewIssuer starts an identity provider which issues ID tokens for the given client.
+func NewIssuer(clientID string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Issuer{
+		ClientID: clientID,
+		key:      key,
+		tokens:   make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("/jwks", i.jwks)
+	mux.HandleFunc("/token", i.token)
+
+	i.Server = httptest.NewServer(mux)
+	return i, nil
+}
+
+// Config returns the settings for a client of this issuer.
+func (i *Issuer) Config() oidc.Config {
+	return oidc.Config{
+		Issuer:      i.URL,
+		ClientID:    i.ClientID,
+		RedirectURL: "http://localhost/v1/oidc/callback",
+	}
+}
+
+// Claims returns valid claims for an ID token for the given subject, with a verified
+// email address. Tests change them to make the token invalid in some way.
+func (i *Issuer) Claims(subject, email, nonce string) *oidc.Claims {
+	now := time.Now()
+
+	return &oidc.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.URL,
+			Subject:   subject,
+			Audience:  jwt.Audience{i.ClientID},
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(5 * time.Minute).Unix(),
+		},
+		Email:         email,
+		EmailVerified: true,
+		Nonce:         nonce,
+	}
+}
+
+// Sign returns an RS256 ID token containing the claims, signed with the issuer's key
+// but naming the given key ID in its header.
+func (i *Issuer) Sign(kid string, claims interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Issue makes the token endpoint return idToken in exchange for code.
+func (i *Issuer) Issue(code, idToken string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.tokens[code] = idToken
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+// token swaps a code registered with Issue() for its ID token. Each code can only be
+// used once, as at a real provider.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("client_id") != i.ClientID {
+		http.Error(w, `{"error": "invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	i.mu.Lock()
+	idToken, ok := i.tokens[r.PostFormValue("code")]
+	delete(i.tokens, r.PostFormValue("code"))
+	i.mu.Unlock()
+
+	if !ok {
+		http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": idToken})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
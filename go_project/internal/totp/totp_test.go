@@ -0,0 +1,117 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret from RFC 6238 appendix B, the ASCII string
+// "12345678901234567890", base32-encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The test vectors from RFC 6238 appendix B are eight digits long, so only their
+	// last six digits are compared.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tc := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Code at %d = %q; want %q", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("got %q; want %q", got, "287082")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := Counter(now)
+
+	code := func(offset int64) string {
+		c, err := Code(rfcSecret, counter+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		skew        int
+		wantCounter int64
+		wantOK      bool
+	}{
+		{"current", code(0), 1, counter, true},
+		{"previous step", code(-1), 1, counter - 1, true},
+		{"next step", code(1), 1, counter + 1, true},
+		{"too old", code(-2), 1, 0, false},
+		{"too new", code(2), 1, 0, false},
+		{"no skew allowed", code(-1), 0, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"too short", code(0)[:5], 1, 0, false},
+		{"too long", code(0) + "0", 1, 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tc.code, now, tc.skew)
+			if got != tc.wantCounter || ok != tc.wantOK {
+				t.Errorf("got %d, %t; want %d, %t", got, ok, tc.wantCounter, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("got a %d byte secret; want 20", len(key))
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("got the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("goproject", "alice@example.com", rfcSecret)
+	want := "otpauth://totp/goproject:alice@example.com?algorithm=SHA1&digits=6&issuer=goproject&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
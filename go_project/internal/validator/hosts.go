@@ -0,0 +1,83 @@
+package validator
+
+import (
+	"net"
+	"strings"
+)
+
+// reservedBlocks are the address blocks, besides the loopback, private, link-local and
+// multicast ones that net.IP knows about, which aren't on the public internet.
+var reservedBlocks = func() []*net.IPNet {
+	var blocks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // "This network"
+		"100.64.0.0/10",   // Carrier-grade NAT
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // Documentation
+		"198.18.0.0/15",   // Benchmarking
+		"198.51.100.0/24", // Documentation
+		"203.0.113.0/24",  // Documentation
+		"240.0.0.0/4",     // Reserved, and broadcast
+		"64:ff9b::/96",    // NAT64, which can reach any IPv4 address
+		"64:ff9b:1::/48",  // Local-use NAT64
+		"2001:db8::/32",   // Documentation
+		"2002::/16",       // 6to4, which can reach any IPv4 address
+	} {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}()
+
+// internalSuffixes are domain suffixes which are only used on private networks.
+var internalSuffixes = []string{".localhost", ".local", ".localdomain", ".internal", ".intranet", ".lan", ".home.arpa"}
+
+// PublicIP returns true if the address is on the public internet, rather than being a
+// loopback, private, link-local (like the 169.254.169.254 metadata service of cloud
+// providers), multicast or otherwise reserved address.
+func PublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, block := range reservedBlocks {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicHost returns true if the host (as returned by url.URL.Hostname()) looks like it
+// is on the public internet: either a PublicIP(), or a domain name with more than one
+// label which doesn't end in a suffix used on private networks. It can't tell where a
+// domain name resolves to, so anything connecting to the host should also check the
+// addresses that it actually connects to.
+func PublicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if ip := net.ParseIP(host); ip != nil {
+		return PublicIP(ip)
+	}
+
+	// Names without a dot, like "localhost" or "db", are looked up on the local
+	// network.
+	if !strings.Contains(host, ".") {
+		return false
+	}
+
+	for _, suffix := range internalSuffixes {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package validator
+
+import "testing"
+
+func TestPublicHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"hooks.example.com.", true},
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"localhost", false},
+		{"LOCALHOST", false},
+		{"api.localhost", false},
+		{"db", false},
+		{"printer.local", false},
+		{"metadata.google.internal", false},
+		{"router.home.arpa", false},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:10.0.0.1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, tc := range tests {
+		if got := PublicHost(tc.host); got != tc.want {
+			t.Errorf("PublicHost(%q) = %t; want %t", tc.host, got, tc.want)
+		}
+	}
+}
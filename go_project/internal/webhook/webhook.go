@@ -0,0 +1,178 @@
+// Package webhook sends signed webhook requests. Each request is a JSON POST whose
+// body is signed with HMAC-SHA256 using the webhook's secret, so that receivers can
+// check that it came from us and hasn't been tampered with or replayed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"goproject/internal/validator"
+)
+
+// The headers sent with every delivery. The signature header looks like
+// "t=1700000000,v1=5257a869...", where t is the Unix time that the request was signed
+// at and v1 is the hex-encoded HMAC-SHA256 of "<t>.<body>".
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	ErrExpiredSignature = errors.New("webhook: signature timestamp is outside the tolerance")
+	ErrForbiddenAddress = errors.New("webhook: refusing to connect to a non-public address")
+)
+
+// Sign returns the value of the signature header for a body signed at the given time.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, body))
+}
+
+// Verify checks a signature header against the body that it came with, as a receiver
+// would. Signatures made more than tolerance before or after now are rejected, to
+// stop old requests from being replayed.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var t, v1 string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			v1 = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	signature, err := hex.DecodeString(v1)
+	if err != nil || !hmac.Equal(signature, mac(secret, t, body)) {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrExpiredSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Delivery is a single request to send to a webhook.
+type Delivery struct {
+	ID     int64
+	URL    string
+	Secret string
+	Event  string
+	Body   []byte
+}
+
+// Sender sends deliveries using its HTTP client.
+type Sender struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+// Send makes the request for a delivery and returns the response status code. Any
+// status outside the 2xx range is returned together with an error. The response body is
+// never included: the error ends up in the delivery log, and the body could be anything
+// at all that the URL returns.
+func (s *Sender) Send(ctx context.Context, d Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, time.Now(), d.Body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Read (some of) the body so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook: receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// NewTransport returns an http.Transport for sending deliveries which will only connect
+// to public addresses. Webhook URLs are checked with validator.PublicHost() when
+// they're saved, but a domain name can be pointed somewhere else afterwards (or between
+// the check and the request, in a DNS rebinding attack), so the address is checked
+// again once it has been resolved, just before connecting. Proxies from the environment
+// aren't used, as the check would then apply to the proxy instead of the receiver.
+func NewTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   controlPublic,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// controlPublic is the net.Dialer Control function used by NewTransport(). It's called
+// with the resolved address of every connection, before connecting.
+func controlPublic(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !validator.PublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// Backoff returns how long to wait before retrying a delivery which has failed the
+// given number of times: base after the first failure, doubling each time after that,
+// up to limit.
+func Backoff(failures int, base, limit time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= limit {
+			return limit
+		}
+	}
+	return min(delay, limit)
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"event": "artifact.created"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign(secret, now, body)
+
+	if !strings.HasPrefix(header, "t=1700000000,v1=") {
+		t.Fatalf("got header %q", header)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{"valid", secret, header, body, now, nil},
+		{"within tolerance", secret, header, body, now.Add(4 * time.Minute), nil},
+		{"wrong secret", "whsec_other", header, body, now, ErrInvalidSignature},
+		{"tampered body", secret, header, []byte(`{"event": "artifact.deleted"}`), now, ErrInvalidSignature},
+		{"tampered timestamp", secret, strings.Replace(header, "t=1700000000", "t=1700000100", 1), body, now, ErrInvalidSignature},
+		{"missing signature", secret, "t=1700000000", body, now, ErrInvalidSignature},
+		{"malformed", secret, "nonsense", body, now, ErrInvalidSignature},
+		{"too old", secret, header, body, now.Add(6 * time.Minute), ErrExpiredSignature},
+		{"from the future", secret, header, body, now.Add(-6 * time.Minute), ErrExpiredSignature},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Verify(tc.secret, tc.header, tc.body, 5*time.Minute, tc.now)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, limit := 30*time.Second, 6*time.Hour
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{1000, 6 * time.Hour},
+	}
+
+	for _, tc := range tests {
+		if got := Backoff(tc.failures, base, limit); got != tc.want {
+			t.Errorf("Backoff(%d) = %s; want %s", tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"event": "artifact.created"}`)
+
+	var status int
+	var received *http.Request
+	var receivedBody []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		// Receivers can send back anything, such as internal details or a page from
+		// an internal service.
+		io.WriteString(w, "secret internal details")
+	}))
+	defer ts.Close()
+
+	sender := &Sender{Client: ts.Client(), UserAgent: "test"}
+	delivery := Delivery{ID: 42, URL: ts.URL, Secret: secret, Event: "artifact.created", Body: body}
+
+	t.Run("success", func(t *testing.T) {
+		status = http.StatusNoContent
+
+		got, err := sender.Send(context.Background(), delivery)
+		if err != nil || got != http.StatusNoContent {
+			t.Fatalf("got %d, %v; want %d and no error", got, err, http.StatusNoContent)
+		}
+
+		if received.Method != http.MethodPost || string(receivedBody) != string(body) {
+			t.Errorf("got %s with body %q", received.Method, receivedBody)
+		}
+		if received.Header.Get(EventHeader) != "artifact.created" || received.Header.Get(DeliveryHeader) != "42" {
+			t.Errorf("got headers %v", received.Header)
+		}
+
+		err = Verify(secret, received.Header.Get(SignatureHeader), receivedBody, time.Minute, time.Now())
+		if err != nil {
+			t.Errorf("signature doesn't verify: %v", err)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		status = http.StatusInternalServerError
+
+		got, err := sender.Send(context.Background(), delivery)
+		if err == nil || got != http.StatusInternalServerError {
+			t.Fatalf("got %d, %v; want %d and an error", got, err, http.StatusInternalServerError)
+		}
+		if strings.Contains(err.Error(), "secret internal details") {
+			t.Errorf("error includes the response body: %v", err)
+		}
+	})
+}
+
+func TestSendRefusesNonPublicAddresses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the server")
+	}))
+	defer ts.Close()
+
+	// The test server listens on a loopback address, which the transport must refuse
+	// to connect to whatever the URL looked like when it was checked.
+	sender := &Sender{Client: &http.Client{Transport: NewTransport()}, UserAgent: "test"}
+
+	_, err := sender.Send(context.Background(), Delivery{ID: 1, URL: ts.URL, Secret: "whsec_test", Event: "artifact.created", Body: []byte(`{}`)})
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("got %v; want %v", err, ErrForbiddenAddress)
+	}
+}
@@ -0,0 +1,27 @@
+INSERT INTO permissions (code)
+VALUES
+('movies:read'),
+('movies:write');
+
+-- Turn the viewer and curator roles back into direct grants of the old codes.
+INSERT INTO users_permissions
+SELECT DISTINCT users_roles.user_id, (SELECT id FROM permissions WHERE code = 'movies:read')
+FROM users_roles
+INNER JOIN roles ON users_roles.role_id = roles.id
+WHERE roles.name IN ('viewer', 'curator', 'admin')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO users_permissions
+SELECT DISTINCT users_roles.user_id, (SELECT id FROM permissions WHERE code = 'movies:write')
+FROM users_roles
+INNER JOIN roles ON users_roles.role_id = roles.id
+WHERE roles.name IN ('curator', 'admin')
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM permissions WHERE code NOT IN ('movies:read', 'movies:write');
+
+ALTER TABLE permissions DROP CONSTRAINT IF EXISTS permissions_code_key;
@@ -0,0 +1,70 @@
+-- Permission codes are looked up by name, so they need to be unique.
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+-- Add a read and write permission for each resource, plus an admin permission for
+-- managing users.
+INSERT INTO permissions (code)
+VALUES
+('researchers:read'),
+('researchers:write'),
+('expeditions:read'),
+('expeditions:write'),
+('artifacts:read'),
+('artifacts:write'),
+('users:admin')
+ON CONFLICT (code) DO NOTHING;
+
+-- Roles bundle permissions together so that they can be granted in one go.
+CREATE TABLE IF NOT EXISTS roles (
+id bigserial PRIMARY KEY,
+name text UNIQUE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS roles_permissions (
+role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+PRIMARY KEY (role_id, permission_id)
+);
+CREATE TABLE IF NOT EXISTS users_roles (
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name)
+VALUES
+('viewer'),
+('curator'),
+('admin');
+
+-- viewers can read everything, curators can also write, and admins can do anything.
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'viewer' AND permissions.code LIKE '%:read';
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'curator' AND (permissions.code LIKE '%:read' OR permissions.code LIKE '%:write');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin';
+
+-- Carry the existing grants over: 'movies:read' becomes the viewer role and
+-- 'movies:write' becomes the curator role.
+INSERT INTO users_roles
+SELECT DISTINCT users_permissions.user_id, (SELECT id FROM roles WHERE name = 'viewer')
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+WHERE permissions.code = 'movies:read'
+ON CONFLICT DO NOTHING;
+
+INSERT INTO users_roles
+SELECT DISTINCT users_permissions.user_id, (SELECT id FROM roles WHERE name = 'curator')
+FROM users_permissions
+INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+WHERE permissions.code = 'movies:write'
+ON CONFLICT DO NOTHING;
+
+-- The old codes aren't used anywhere any more. Deleting them also removes the grants
+-- that reference them.
+DELETE FROM permissions WHERE code IN ('movies:read', 'movies:write');
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code IN ('expeditions:write_any', 'artifacts:write_any');
+ALTER TABLE users DROP COLUMN IF EXISTS researcher_id;
@@ -0,0 +1,15 @@
+-- A user can optionally be linked to the researcher profile that they write as.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS researcher_id integer REFERENCES researcher (researcher_id) ON DELETE SET NULL;
+
+-- Writing to artifacts and expeditions that belong to other researchers needs one of
+-- these elevated permissions, which only the admin role gets by default.
+INSERT INTO permissions (code)
+VALUES
+('expeditions:write_any'),
+('artifacts:write_any')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code LIKE '%:write_any'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,9 @@
+-- Failed logins are counted separately per email address and per client IP address.
+-- kind is either 'email' or 'ip', and key is the address itself.
+CREATE TABLE IF NOT EXISTS login_failures (
+    kind text NOT NULL,
+    key text NOT NULL,
+    failures integer NOT NULL,
+    last_failure_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (kind, key)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE permissions DROP COLUMN IF EXISTS requires_2fa;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totp;
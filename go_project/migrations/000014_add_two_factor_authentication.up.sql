@@ -0,0 +1,20 @@
+-- A user's TOTP secret. It only protects their logins once it has been confirmed with a
+-- code from their authenticator app. last_counter is the time step of the last code
+-- that was accepted, so that a code can't be used twice.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret text NOT NULL,
+    confirmed boolean NOT NULL DEFAULT false,
+    last_counter bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Single-use recovery codes, stored hashed, for when the authenticator app is lost.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
+
+-- Permissions which only count for users who have two-factor authentication enabled.
+ALTER TABLE permissions ADD COLUMN IF NOT EXISTS requires_2fa boolean NOT NULL DEFAULT false;
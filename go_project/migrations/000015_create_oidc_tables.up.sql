@@ -0,0 +1,18 @@
+-- Logins with the identity provider which have been started but not finished. Each one
+-- is used once, by the callback that finishes it.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state text PRIMARY KEY,
+    code_verifier text NOT NULL,
+    nonce text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- Links between accounts at an identity provider (identified by its issuer and the
+-- subject that it gives the user) and local users.
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS audit_events_created_at_idx;
+DROP INDEX IF EXISTS audit_events_actor_idx;
+
+ALTER TABLE audit_events DROP COLUMN IF EXISTS changes;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS ip;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,6 @@
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS request_id text NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS ip text NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS changes jsonb NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS artifact_versions;
+ALTER TABLE artifact DROP COLUMN IF EXISTS version;
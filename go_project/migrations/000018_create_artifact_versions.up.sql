@@ -0,0 +1,25 @@
+ALTER TABLE artifact ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+-- There's deliberately no foreign key to the artifact table. When an artifact is
+-- deleted its versions are kept, followed by a tombstone version marked as deleted, so
+-- that it can still be looked at and restored.
+CREATE TABLE IF NOT EXISTS artifact_versions (
+    artifact_id integer NOT NULL,
+    version integer NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    age INTEGER,
+    location VARCHAR(100) NOT NULL,
+    researcher_id INTEGER NOT NULL,
+    actor_id bigint NOT NULL DEFAULT 0,
+    deleted boolean NOT NULL DEFAULT false,
+    approximate boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (artifact_id, version)
+);
+
+-- Artifacts which already exist get a first version from how they are now. We don't
+-- know when they were created, so these versions are marked as approximate.
+INSERT INTO artifact_versions (artifact_id, version, title, age, location, researcher_id, approximate)
+SELECT artifact_id, version, title, age, location, researcher_id, true
+FROM artifact
+ON CONFLICT DO NOTHING;
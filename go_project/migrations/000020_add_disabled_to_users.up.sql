@@ -0,0 +1,4 @@
+-- Disabling an account is kept separate from activation, so that a user who has been
+-- disabled by an admin can't turn their account back on by requesting a new activation
+-- token.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE login_failures DROP COLUMN IF EXISTS previous_failure_at;
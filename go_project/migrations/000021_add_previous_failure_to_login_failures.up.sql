@@ -0,0 +1,4 @@
+-- previous_failure_at remembers when the failure before last_failure_at happened, so
+-- that an attempt can be counted and checked against the earlier failures in a single
+-- statement, and taken back again if the login turns out to be right.
+ALTER TABLE login_failures ADD COLUMN IF NOT EXISTS previous_failure_at timestamp(0) with time zone;